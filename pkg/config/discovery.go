@@ -23,11 +23,37 @@ import (
 	"github.com/kubeservice-stack/common/pkg/utils"
 )
 
+type DISCOVERYTYPE string
+
+const (
+	DISCOVERYETCD   DISCOVERYTYPE = "etcd"
+	DISCOVERYMEMORY DISCOVERYTYPE = "memory"
+	DISCOVERYFILE   DISCOVERYTYPE = "file"
+	DISCOVERYCONSUL DISCOVERYTYPE = "consul"
+)
+
 type Discovery struct {
+	Type        DISCOVERYTYPE  `toml:"type" json:"type" env:"DISCOVERY_TYPE" envDefault:"etcd"`      // 类型: etcd, memory, file, consul
 	Namespace   string         `toml:"namespace" json:"namespace" env:"DISCOVERY_NAMESPACE"`         // 命名空间
 	Endpoints   []string       `toml:"endpoints" json:"endpoints" env:"DISCOVERY_ENDPOINTS"`         // 连接端点
 	DialTimeout utils.Duration `toml:"dial_timeout" json:"dial_timeout" env:"DISCOVERY_DIALTIMEOUT"` // 连接超时时间
 	Prefix      string         `toml:"prefix" json:"prefix" env:"DISCOVERY_PREFIX"`                  // 前缀
+
+	CAFile             string `toml:"ca_file" json:"ca_file" env:"DISCOVERY_CAFILE"`                                       // TLS CA证书
+	CertFile           string `toml:"cert_file" json:"cert_file" env:"DISCOVERY_CERTFILE"`                                 // TLS 客户端证书
+	KeyFile            string `toml:"key_file" json:"key_file" env:"DISCOVERY_KEYFILE"`                                    // TLS 客户端私钥
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify" json:"insecure_skip_verify" env:"DISCOVERY_INSECURESKIPVERIFY"` // 跳过服务端证书校验
+	Username           string `toml:"username" json:"username" env:"DISCOVERY_USERNAME"`                                   // 用户名
+	Password           string `toml:"password" json:"discovery_password" env:"DISCOVERY_PASSWORD"`                         // 密码
+	Token              string `toml:"token" json:"token" env:"DISCOVERY_TOKEN"`                                            // 认证token
+
+	IsolateOwner   bool           `toml:"isolate_owner" json:"isolate_owner" env:"DISCOVERY_ISOLATEOWNER"`       // 按owner隔离key: <namespace>/<owner>/<key>
+	RequestTimeout utils.Duration `toml:"request_timeout" json:"request_timeout" env:"DISCOVERY_REQUESTTIMEOUT"` // 单次请求超时时间, 0为不限制
+}
+
+// TLSEnabled 是否配置了TLS证书
+func (ds Discovery) TLSEnabled() bool {
+	return ds.CAFile != "" || ds.CertFile != "" || ds.InsecureSkipVerify
 }
 
 func (ds Discovery) TOML() string {
@@ -37,6 +63,8 @@ func (ds Discovery) TOML() string {
 	endpoints, _ := json.Marshal(ds.Endpoints)
 	return fmt.Sprintf(`
 [discovery]
+  ## discovery 类型, 支持etcd/memory/file/consul, 默认etcd
+  type = "%s"
   ## etcd namespace
   namespace = "%s"
   ## etcd 集群配置
@@ -44,16 +72,45 @@ func (ds Discovery) TOML() string {
   ## ETCD连接 timeout时间
   dial_timeout = "%s"
   ## ETCD前缀key
-  prefix = "%s"`,
+  prefix = "%s"
+  ## TLS CA证书路径
+  ca_file = "%s"
+  ## TLS 客户端证书路径
+  cert_file = "%s"
+  ## TLS 客户端私钥路径
+  key_file = "%s"
+  ## 是否跳过服务端证书校验
+  insecure_skip_verify = %v
+  ## 认证用户名
+  username = "%s"
+  ## 认证密码
+  password = "%s"
+  ## 认证token, etcd为auth token, consul为ACL token
+  token = "%s"
+  ## 是否按owner隔离key, 开启后key为 <namespace>/<owner>/<key>
+  isolate_owner = %v
+  ## 单次请求超时时间, 0为不限制
+  request_timeout = "%s"`,
+		ds.Type,
 		ds.Namespace,
 		endpoints,
 		ds.DialTimeout.String(),
 		ds.Prefix,
+		ds.CAFile,
+		ds.CertFile,
+		ds.KeyFile,
+		ds.InsecureSkipVerify,
+		ds.Username,
+		ds.Password,
+		ds.Token,
+		ds.IsolateOwner,
+		ds.RequestTimeout.String(),
 	)
 }
 
 func (ds Discovery) DefaultConfig() Discovery {
 	ds = Discovery{
+		Type:      DISCOVERYETCD,
 		Namespace: "application",
 		Endpoints: []string{"http://127.0.0.1:2379"},
 		Prefix:    "",
@@ -28,6 +28,8 @@ func Test_Discovery(t *testing.T) {
 	aa := GlobalCfg.Discovery.DefaultConfig().TOML()
 	assert.Equal(aa, `
 [discovery]
+  ## discovery 类型, 支持etcd/memory/file/consul, 默认etcd
+  type = "etcd"
   ## etcd namespace
   namespace = "application"
   ## etcd 集群配置
@@ -35,5 +37,31 @@ func Test_Discovery(t *testing.T) {
   ## ETCD连接 timeout时间
   dial_timeout = "0s"
   ## ETCD前缀key
-  prefix = ""`)
+  prefix = ""
+  ## TLS CA证书路径
+  ca_file = ""
+  ## TLS 客户端证书路径
+  cert_file = ""
+  ## TLS 客户端私钥路径
+  key_file = ""
+  ## 是否跳过服务端证书校验
+  insecure_skip_verify = false
+  ## 认证用户名
+  username = ""
+  ## 认证密码
+  password = ""
+  ## 认证token, etcd为auth token, consul为ACL token
+  token = ""
+  ## 是否按owner隔离key, 开启后key为 <namespace>/<owner>/<key>
+  isolate_owner = false
+  ## 单次请求超时时间, 0为不限制
+  request_timeout = "0s"`)
+}
+
+func Test_DiscoveryTLSEnabled(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(GlobalCfg.Discovery.DefaultConfig().TLSEnabled())
+	assert.True(Discovery{CAFile: "ca.pem"}.TLSEnabled())
+	assert.True(Discovery{InsecureSkipVerify: true}.TLSEnabled())
 }
@@ -110,3 +110,14 @@ func (gcf GinConfig) ListenAddr() string {
 	}
 	return fmt.Sprintf("0.0.0.0:%d", gcf.Port)
 }
+
+// Validate 校验服务类型与端口
+func (gcf GinConfig) Validate() error {
+	if gcf.ServerType != "frontend" && gcf.ServerType != "backend" {
+		return fmt.Errorf("unknown gin server_type %q", gcf.ServerType)
+	}
+	if gcf.Port <= 0 || gcf.Port > 65535 {
+		return fmt.Errorf("invalid gin port %d", gcf.Port)
+	}
+	return nil
+}
@@ -59,3 +59,15 @@ func Test_GinConfigListenAddr(t *testing.T) {
 	aa := GlobalCfg.ListenAddr()
 	assert.Equal(aa, "0.0.0.0:9445")
 }
+
+func Test_GinConfigValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := GlobalCfg.GinConfig.DefaultConfig()
+	assert.Nil(cfg.Validate())
+	cfg.Port = 70000
+	assert.NotNil(cfg.Validate())
+	cfg.Port = DefaultPort
+	cfg.ServerType = "proxy"
+	assert.NotNil(cfg.Validate())
+}
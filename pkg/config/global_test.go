@@ -75,6 +75,8 @@ func Test_global(t *testing.T) {
   ## 自定义metric自动填充kv数据, 默认为{}
   metrics_tags = 'null'
 [discovery]
+  ## discovery 类型, 支持etcd/memory/file/consul, 默认etcd
+  type = "etcd"
   ## etcd namespace
   namespace = ""
   ## etcd 集群配置
@@ -83,6 +85,24 @@ func Test_global(t *testing.T) {
   dial_timeout = "0s"
   ## ETCD前缀key
   prefix = ""
+  ## TLS CA证书路径
+  ca_file = ""
+  ## TLS 客户端证书路径
+  cert_file = ""
+  ## TLS 客户端私钥路径
+  key_file = ""
+  ## 是否跳过服务端证书校验
+  insecure_skip_verify = false
+  ## 认证用户名
+  username = ""
+  ## 认证密码
+  password = ""
+  ## 认证token, etcd为auth token, consul为ACL token
+  token = ""
+  ## 是否按owner隔离key, 开启后key为 <namespace>/<owner>/<key>
+  isolate_owner = false
+  ## 单次请求超时时间, 0为不限制
+  request_timeout = "0s"
 [gin]
   ## APP name
   app = "server-override"
@@ -20,6 +20,7 @@ package config
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 )
 
 var defaultParentDir = "/tmp/media"
@@ -74,3 +75,12 @@ func (l Logging) DefaultConfig() Logging {
 	}
 	return l
 }
+
+// Validate 校验日志等级
+func (l Logging) Validate() error {
+	switch strings.ToLower(l.Level) {
+	case "error", "warn", "info", "debug":
+		return nil
+	}
+	return fmt.Errorf("unknown logging level %q", l.Level)
+}
@@ -44,3 +44,11 @@ func Test_logger(t *testing.T) {
   ## 根据以下情况保留旧日志文件的最大天数：时间戳编码在其文件名中； 一天定义为24小时
   maxage = 30`)
 }
+
+func Test_loggerValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(GlobalCfg.Logging.DefaultConfig().Validate())
+	assert.Nil(Logging{Level: "DEBUG"}.Validate())
+	assert.NotNil(Logging{Level: "verbose"}.Validate())
+}
@@ -43,3 +43,14 @@ func (rl RateLimit) DefaultConfig() RateLimit {
 		Burst: 20,
 	}
 }
+
+// Validate 校验qps与并发数
+func (rl RateLimit) Validate() error {
+	if rl.QPS <= 0 {
+		return fmt.Errorf("ratelimit qps must be positive, got %d", rl.QPS)
+	}
+	if rl.Burst < 0 {
+		return fmt.Errorf("ratelimit burst must not be negative, got %d", rl.Burst)
+	}
+	return nil
+}
@@ -34,3 +34,11 @@ func Test_Ratelimit(t *testing.T) {
   ## 并发数
   burst = 20`)
 }
+
+func Test_RatelimitValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(GlobalCfg.RateLimit.DefaultConfig().Validate())
+	assert.NotNil(RateLimit{QPS: 0, Burst: 1}.Validate())
+	assert.NotNil(RateLimit{QPS: 1, Burst: -1}.Validate())
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+const (
+	LoggingSection   = "logging"   // 日志配置段
+	RateLimitSection = "ratelimit" // 限流配置段
+	GinSection       = "gin"       // gin配置段
+)
+
+// RateLimitUpdater 可动态修改限流参数, 例如 *ratelimiter.RateLimiters
+type RateLimitUpdater interface {
+	UpdateRateLimit(name string, qps, burst int)
+}
+
+// BindLogging 注册logging配置段, 日志等级变化后立即生效
+func BindLogging(c *Center, def config.Logging) *Section[config.Logging] {
+	return NewSection(c, LoggingSection, def).OnChange(func(old, new config.Logging) {
+		if old.Level == new.Level {
+			return
+		}
+		if err := logger.RunningAtomicLevel.UnmarshalText([]byte(new.Level)); err != nil {
+			c.logger.Error("update logging level error", logger.Error(err), logger.String("level", new.Level))
+		}
+	})
+}
+
+// BindRateLimit 注册ratelimit配置段, 变化后更新limiter中名为name的限流
+func BindRateLimit(c *Center, def config.RateLimit, limiter RateLimitUpdater, name string) *Section[config.RateLimit] {
+	return NewSection(c, RateLimitSection, def).OnChange(func(_, new config.RateLimit) {
+		limiter.UpdateRateLimit(name, new.QPS, new.Burst)
+	})
+}
+
+// BindGin 注册gin配置段
+func BindGin(c *Center, def config.GinConfig) *Section[config.GinConfig] {
+	return NewSection(c, GinSection, def)
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+动态配置中心: 配置段以toml格式存放在Discovery的 <root>/<section> key中,
+watch变化后解码、校验并通知订阅者, 无需重启即可修改日志等级、限流等配置.
+*/
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+const defaultRoot = "/config" // 默认配置根路径
+
+var (
+	ErrCenterStarted = errors.New("remote: config center is already started")
+	ErrCenterClosed  = errors.New("remote: config center is closed")
+)
+
+// Validator 配置段实现Validate时, 解码后的值校验失败不会生效
+type Validator interface {
+	Validate() error
+}
+
+// updater 一个已注册的配置段
+type updater interface {
+	update(data []byte) error
+	reset()
+}
+
+type Option func(c *Center)
+
+// WithRoot 配置根路径, 配置段的key为 <root>/<section>. Defaults to /config.
+func WithRoot(root string) Option {
+	return func(c *Center) {
+		c.root = strings.TrimSuffix(root, "/")
+	}
+}
+
+// Center 基于Discovery的动态配置中心
+type Center struct {
+	ds     discovery.Discovery
+	root   string
+	logger *logger.Logger
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	// applyMu 串行化配置段的更新, 保护sections和revs
+	applyMu  sync.Mutex
+	sections map[string]updater
+	revs     map[string]int64 // 各配置段最近一次生效的revision, 旧的值不会覆盖新的值
+}
+
+func NewCenter(ds discovery.Discovery, opts ...Option) *Center {
+	c := &Center{
+		ds:       ds,
+		root:     defaultRoot,
+		logger:   logger.GetLogger("pkg/common/config", "remote"),
+		sections: make(map[string]updater),
+		revs:     make(map[string]int64),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Key 配置段在discovery中的key
+func (c *Center) Key(section string) string {
+	return c.root + "/" + section
+}
+
+// Publish 将v编码为toml写入配置段; v实现Validator时先校验
+func (c *Center) Publish(ctx context.Context, section string, v interface{}) error {
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return fmt.Errorf("remote: invalid config section[%s]: %w", section, err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return fmt.Errorf("remote: encode config section[%s] error: %w", section, err)
+	}
+	return c.ds.Put(ctx, c.Key(section), buf.Bytes())
+}
+
+// Delete 删除配置段, 订阅者恢复为默认值
+func (c *Center) Delete(ctx context.Context, section string) error {
+	return c.ds.Delete(ctx, c.Key(section))
+}
+
+// Start 加载已有的配置段并开始watch, 阻塞直到首次加载完成或ctx结束
+func (c *Center) Start(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrCenterClosed
+	}
+	if c.started {
+		c.mu.Unlock()
+		return ErrCenterStarted
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	c.started, c.cancel, c.done = true, cancel, make(chan struct{})
+	c.mu.Unlock()
+
+	ready := make(chan struct{})
+	go c.watch(watchCtx, ready)
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		// 首次加载未完成, 停止watch后可以重新Start
+		cancel()
+		<-c.done
+		c.mu.Lock()
+		c.started, c.cancel = false, nil
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (c *Center) watch(ctx context.Context, ready chan struct{}) {
+	defer close(c.done)
+	var once sync.Once
+	for evt := range c.ds.WatchPrefix(ctx, c.root+"/", true) {
+		if evt.Err != nil {
+			c.logger.Error("watch config center error", logger.Error(evt.Err), logger.String("root", c.root))
+			continue
+		}
+		c.apply(evt)
+		once.Do(func() { close(ready) })
+	}
+}
+
+func (c *Center) apply(evt *discovery.Event) {
+	values := make(map[string]discovery.EventKeyValue, len(evt.KeyValues))
+	for _, kv := range evt.KeyValues {
+		values[strings.TrimPrefix(kv.Key, c.root+"/")] = kv
+	}
+
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+	switch evt.Type {
+	case discovery.EventTypeAll:
+		// 全量同步: 不存在的配置段恢复默认值
+		for name, s := range c.sections {
+			if _, ok := values[name]; !ok {
+				s.reset()
+			}
+		}
+		for name, kv := range values {
+			if s, ok := c.sections[name]; ok && c.newer(name, kv.Rev) {
+				c.update(name, s, kv.Value)
+			}
+		}
+	case discovery.EventTypeDelete:
+		for name, kv := range values {
+			if s, ok := c.sections[name]; ok && c.newer(name, kv.Rev) {
+				s.reset()
+			}
+		}
+	default:
+		for name, kv := range values {
+			if s, ok := c.sections[name]; ok && c.newer(name, kv.Rev) {
+				c.update(name, s, kv.Value)
+			}
+		}
+	}
+}
+
+// newer rev不比配置段已生效的revision旧时记录并返回true, 需持有applyMu
+func (c *Center) newer(name string, rev int64) bool {
+	if rev < c.revs[name] {
+		return false
+	}
+	c.revs[name] = rev
+	return true
+}
+
+func (c *Center) update(name string, s updater, data []byte) {
+	if err := s.update(data); err != nil {
+		// 保留最近一次有效的值
+		c.logger.Error("apply config section error", logger.Error(err), logger.String("section", name))
+	}
+}
+
+// register 注册配置段, 已Start时立即加载当前值; 加载期间watch已应用更新的值时不覆盖
+func (c *Center) register(name string, s updater) {
+	c.applyMu.Lock()
+	if _, ok := c.sections[name]; ok {
+		c.applyMu.Unlock()
+		panic("remote: section " + name + " is already registered")
+	}
+	c.sections[name] = s
+	c.applyMu.Unlock()
+
+	c.mu.Lock()
+	started := c.started && !c.closed
+	c.mu.Unlock()
+	if !started {
+		return
+	}
+	key := c.Key(name)
+	txn := c.ds.NewTransaction()
+	txn.Get(key)
+	result, err := c.ds.Txn(context.Background(), txn)
+	if err != nil {
+		c.logger.Error("load config section error", logger.Error(err), logger.String("section", name))
+		return
+	}
+	for _, resp := range result.Responses {
+		if resp.Type != discovery.TxnOpGet || resp.Key != key || !resp.Found {
+			continue
+		}
+		c.applyMu.Lock()
+		if c.newer(name, resp.Rev) {
+			c.update(name, s, resp.Value)
+		}
+		c.applyMu.Unlock()
+	}
+}
+
+// Close 停止watch, 已注册的配置段保留最后的值
+func (c *Center) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	cancel, done := c.cancel, c.done
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+type fakeLimiter struct {
+	sync.Mutex
+	qps, burst map[string]int
+}
+
+func (l *fakeLimiter) UpdateRateLimit(name string, qps, burst int) {
+	l.Lock()
+	defer l.Unlock()
+	l.qps[name], l.burst[name] = qps, burst
+}
+
+func (l *fakeLimiter) get(name string) (int, int) {
+	l.Lock()
+	defer l.Unlock()
+	return l.qps[name], l.burst[name]
+}
+
+func newTestCenter(t *testing.T) (*Center, discovery.Discovery) {
+	ds, err := discovery.NewDiscoveryFactory("nobody").CreateDiscovery(config.Discovery{
+		Type:      config.DISCOVERYMEMORY,
+		Endpoints: []string{t.Name()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCenter(ds), ds
+}
+
+func TestCenter_Section(t *testing.T) {
+	assert := assert.New(t)
+	c, ds := newTestCenter(t)
+	defer ds.Close()
+	ctx := context.TODO()
+
+	def := config.RateLimit{}.DefaultConfig()
+	assert.Nil(c.Publish(ctx, RateLimitSection, config.RateLimit{QPS: 50, Burst: 5}))
+	assert.NotNil(c.Publish(ctx, RateLimitSection, config.RateLimit{QPS: 0}))
+
+	limiter := &fakeLimiter{qps: map[string]int{}, burst: map[string]int{}}
+	section := BindRateLimit(c, def, limiter, "api")
+	changed := make(chan config.RateLimit, 10)
+	section.OnChange(func(_, new config.RateLimit) { changed <- new })
+
+	assert.Nil(c.Start(ctx))
+	defer c.Close()
+	assert.ErrorIs(c.Start(ctx), ErrCenterStarted)
+	assert.Equal(config.RateLimit{QPS: 50, Burst: 5}, section.Get())
+	<-changed
+
+	// 未配置的字段保持默认值
+	assert.Nil(ds.Put(ctx, c.Key(RateLimitSection), []byte("qps = 200")))
+	assert.Equal(config.RateLimit{QPS: 200, Burst: def.Burst}, waitChange(t, changed))
+	qps, burst := limiter.get("api")
+	assert.Equal(200, qps)
+	assert.Equal(def.Burst, burst)
+
+	// 解码或校验失败保留原值
+	assert.Nil(ds.Put(ctx, c.Key(RateLimitSection), []byte("qps = -1")))
+	assert.Nil(ds.Put(ctx, c.Key(RateLimitSection), []byte("qps = [")))
+	assert.Nil(ds.Put(ctx, c.Key(RateLimitSection), []byte("qps = 300")))
+	assert.Equal(300, waitChange(t, changed).QPS)
+
+	// 删除后恢复默认值
+	assert.Nil(c.Delete(ctx, RateLimitSection))
+	assert.Equal(def, waitChange(t, changed))
+}
+
+func TestCenter_RegisterAfterStart(t *testing.T) {
+	assert := assert.New(t)
+	c, ds := newTestCenter(t)
+	defer ds.Close()
+	ctx := context.TODO()
+
+	assert.Nil(c.Start(ctx))
+	assert.Nil(c.Publish(ctx, GinSection, config.GinConfig{}.DefaultConfig()))
+	gin := config.GinConfig{}.DefaultConfig()
+	gin.Port = 8080
+	assert.Nil(c.Publish(ctx, GinSection, gin))
+
+	section := BindGin(c, config.GinConfig{}.DefaultConfig())
+	assert.Equal(8080, section.Get().Port)
+	assert.Equal(GinSection, section.Name())
+	assert.Panics(func() { BindGin(c, gin) })
+
+	c.Close()
+	c.Close()
+	assert.ErrorIs(c.Start(ctx), ErrCenterClosed)
+}
+
+// hookDiscovery 用于模拟watch没有首次同步, 以及加载配置段期间watch应用了更新的值
+type hookDiscovery struct {
+	discovery.Discovery
+	watch func(ctx context.Context) discovery.WatchEventChan
+	txn   func(result *discovery.TxnResult)
+}
+
+func (d *hookDiscovery) WatchPrefix(ctx context.Context, prefixKey string, fetchVal bool) discovery.WatchEventChan {
+	if d.watch != nil {
+		return d.watch(ctx)
+	}
+	return d.Discovery.WatchPrefix(ctx, prefixKey, fetchVal)
+}
+
+func (d *hookDiscovery) Txn(ctx context.Context, txn discovery.Transaction) (*discovery.TxnResult, error) {
+	result, err := d.Discovery.Txn(ctx, txn)
+	if err == nil && d.txn != nil {
+		d.txn(result)
+	}
+	return result, err
+}
+
+func TestCenter_StartTimeout(t *testing.T) {
+	assert := assert.New(t)
+	_, ds := newTestCenter(t)
+	defer ds.Close()
+
+	var stopped int32
+	c := NewCenter(&hookDiscovery{Discovery: ds, watch: func(ctx context.Context) discovery.WatchEventChan {
+		ch := make(chan *discovery.Event)
+		go func() {
+			<-ctx.Done()
+			atomic.AddInt32(&stopped, 1)
+			close(ch)
+		}()
+		return ch
+	}})
+
+	// 首次加载超时后停止watch, 可以重新Start
+	for i := 1; i <= 2; i++ {
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+		assert.ErrorIs(c.Start(ctx), context.DeadlineExceeded)
+		cancel()
+		assert.Equal(int32(i), atomic.LoadInt32(&stopped))
+	}
+	c.Close()
+}
+
+func TestCenter_RegisterStale(t *testing.T) {
+	assert := assert.New(t)
+	_, ds := newTestCenter(t)
+	defer ds.Close()
+	ctx := context.TODO()
+
+	hook := &hookDiscovery{Discovery: ds}
+	c := NewCenter(hook)
+	assert.Nil(c.Start(ctx))
+	defer c.Close()
+	gin := config.GinConfig{}.DefaultConfig()
+	gin.Port = 8080
+	assert.Nil(c.Publish(ctx, GinSection, gin))
+
+	// 读取当前值之后watch应用了更新的值, 读取到的旧值不生效
+	hook.txn = func(result *discovery.TxnResult) {
+		rev := result.Responses[0].Rev
+		c.apply(&discovery.Event{
+			Type:      discovery.EventTypeModify,
+			KeyValues: []discovery.EventKeyValue{{Key: c.Key(GinSection), Value: []byte("port = 9090"), Rev: rev + 1}},
+		})
+	}
+	section := BindGin(c, config.GinConfig{}.DefaultConfig())
+	assert.Equal(9090, section.Get().Port)
+}
+
+func TestBindLogging(t *testing.T) {
+	assert := assert.New(t)
+	c, ds := newTestCenter(t)
+	defer ds.Close()
+	defer logger.RunningAtomicLevel.SetLevel(logger.RunningAtomicLevel.Level())
+	ctx := context.TODO()
+
+	def := config.Logging{}.DefaultConfig()
+	section := BindLogging(c, def)
+	changed := make(chan config.Logging, 10)
+	section.OnChange(func(_, new config.Logging) { changed <- new })
+	section.Validate(func(l config.Logging) error {
+		if l.MaxSize == 0 {
+			return errors.New("maxsize is zero")
+		}
+		return nil
+	})
+	assert.Nil(c.Start(ctx))
+	defer c.Close()
+
+	assert.Nil(ds.Put(ctx, c.Key(LoggingSection), []byte("level = \"verbose\"")))
+	assert.Nil(ds.Put(ctx, c.Key(LoggingSection), []byte("level = \"debug\"\nmaxsize = 0")))
+	assert.Nil(ds.Put(ctx, c.Key(LoggingSection), []byte("level = \"debug\"")))
+	assert.Equal("debug", waitChange(t, changed).Level)
+	assert.Equal("debug", logger.RunningAtomicLevel.String())
+}
+
+func waitChange[T any](t *testing.T, ch chan T) T {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait config change timeout")
+		var v T
+		return v
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Section 类型化的配置段, 保存最近一次有效的值
+type Section[T any] struct {
+	name string
+	def  T
+
+	mu         sync.RWMutex
+	value      T
+	validators []func(T) error
+	handlers   []func(old, new T)
+}
+
+// NewSection 在c上注册名为name的配置段, 未配置或被删除时使用def; 同名配置段重复注册会panic
+func NewSection[T any](c *Center, name string, def T) *Section[T] {
+	s := &Section[T]{
+		name:  name,
+		def:   def,
+		value: def,
+	}
+	c.register(name, s)
+	return s
+}
+
+// Name 配置段名称
+func (s *Section[T]) Name() string {
+	return s.name
+}
+
+// Get 当前生效的值
+func (s *Section[T]) Get() T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+// Validate 增加校验函数, 校验失败的值不会生效
+func (s *Section[T]) Validate(fn func(T) error) *Section[T] {
+	s.mu.Lock()
+	s.validators = append(s.validators, fn)
+	s.mu.Unlock()
+	return s
+}
+
+// OnChange 值变化后按注册顺序回调
+func (s *Section[T]) OnChange(fn func(old, new T)) *Section[T] {
+	s.mu.Lock()
+	s.handlers = append(s.handlers, fn)
+	s.mu.Unlock()
+	return s
+}
+
+// update 在默认值基础上解码data, 未配置的字段保持默认值
+func (s *Section[T]) update(data []byte) error {
+	value := s.def
+	if _, err := toml.Decode(string(data), &value); err != nil {
+		return fmt.Errorf("decode config section[%s] error: %w", s.name, err)
+	}
+	if err := s.validate(value); err != nil {
+		return fmt.Errorf("invalid config section[%s]: %w", s.name, err)
+	}
+	s.set(value)
+	return nil
+}
+
+func (s *Section[T]) reset() {
+	s.set(s.def)
+}
+
+func (s *Section[T]) validate(value T) error {
+	if validator, ok := any(value).(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return err
+		}
+	}
+	s.mu.RLock()
+	validators := s.validators
+	s.mu.RUnlock()
+	for _, fn := range validators {
+		if err := fn(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Section[T]) set(value T) {
+	s.mu.Lock()
+	old := s.value
+	if reflect.DeepEqual(old, value) {
+		s.mu.Unlock()
+		return
+	}
+	s.value = value
+	handlers := s.handlers
+	s.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(old, value)
+	}
+}
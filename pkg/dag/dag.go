@@ -135,6 +135,19 @@ func (d *DAG) GetVertex(id interface{}) (*Vertex, error) {
 	return vertex, nil
 }
 
+// Vertices return all the vertices of the graph in insertion order.
+func (d *DAG) Vertices() []*Vertex {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	vertices := make([]*Vertex, 0, d.vertices.Size())
+	for _, vertex := range d.vertices.Values() {
+		vertices = append(vertices, vertex.(*Vertex))
+	}
+
+	return vertices
+}
+
 // Order return the number of vertices in the graph.
 func (d *DAG) Order() int {
 	numVertices := d.vertices.Size()
@@ -145,6 +145,21 @@ func TestDAGOrder(t *testing.T) {
 	assert.Equal(3, dag1.Order())
 }
 
+func TestDAGVertices(t *testing.T) {
+	assert := assert.New(t)
+	dag1 := NewDAG()
+
+	assert.Empty(dag1.Vertices())
+
+	vertex1 := NewVertex("1", nil)
+	vertex2 := NewVertex("2", nil)
+
+	assert.Nil(dag1.AddVertex(vertex1))
+	assert.Nil(dag1.AddVertex(vertex2))
+
+	assert.Equal([]*Vertex{vertex1, vertex2}, dag1.Vertices())
+}
+
 func TestDAGSize(t *testing.T) {
 	assert := assert.New(t)
 	dag1 := NewDAG()
@@ -0,0 +1,735 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+const (
+	consulMinTTL         = 10               // consul session最小ttl为10s
+	consulWatchWait      = 30 * time.Second // blocking query最长等待时间
+	consulDestroyTimeout = 3 * time.Second  // destroy session超时时间
+	consulTxnRetries     = 5                // 读取的key被并发修改时txn最多重试次数
+	consulTxnBackoff     = 10 * time.Millisecond
+)
+
+func init() {
+	Register(config.DISCOVERYCONSUL, newConsulDiscovery)
+}
+
+// consulDiscovery 基于consul KV HTTP API的discovery.
+// lease对应consul session(Behavior=delete), watch基于blocking query, transaction基于/v1/txn.
+// consul的key不能以"/"开头, 写入时去掉开头的"/", 读取时补回.
+type consulDiscovery struct {
+	namespace string
+	isolated  bool
+	token     string
+	username  string
+	password  string
+	prefix    string
+	endpoint  string
+	client    *http.Client
+	logger    *logger.Logger
+	metrics   *heartbeatMetrics
+}
+
+func newConsulDiscovery(cfg config.Discovery, owner string) (Discovery, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("consul discovery needs an endpoint")
+	}
+	tlsCfg, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := strings.TrimRight(cfg.Endpoints[0], "/")
+	if !strings.Contains(endpoint, "://") {
+		if tlsCfg != nil {
+			endpoint = "https://" + endpoint
+		} else {
+			endpoint = "http://" + endpoint
+		}
+	}
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout.Duration()}
+	cd := &consulDiscovery{
+		namespace: ownerNamespace(cfg, owner),
+		isolated:  cfg.IsolateOwner,
+		token:     cfg.Token,
+		username:  cfg.Username,
+		password:  cfg.Password,
+		prefix:    cfg.Prefix,
+		endpoint:  endpoint,
+		client:    &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext, TLSClientConfig: tlsCfg}},
+		logger:    logger.GetLogger(owner, "CONSUL"),
+		metrics:   defaultHeartbeatMetrics,
+	}
+	cd.logger.Info("new consul client successfully", logger.String("endpoint", endpoint))
+	return cd, nil
+}
+
+type consulKV struct {
+	Key         string
+	Value       []byte
+	CreateIndex uint64
+	ModifyIndex uint64
+	Session     string
+}
+
+type consulTxnKV struct {
+	Verb    string
+	Key     string
+	Value   []byte `json:",omitempty"`
+	Index   uint64 `json:",omitempty"`
+	Session string `json:",omitempty"`
+}
+
+type consulTxnOp struct {
+	KV consulTxnKV
+}
+
+// consulTxnResponse txn成功时每个写入op的结果, KV不包含Value
+type consulTxnResponse struct {
+	Results []struct {
+		KV consulKV
+	}
+}
+
+// keyPath return consul key with prefix and namespace, and whether the key starts with "/"
+func (cd *consulDiscovery) keyPath(key string) (string, bool) {
+	if cd.isolated {
+		key = isolateKey(key)
+	}
+	if len(cd.namespace) > 0 {
+		key = filepath.Join(cd.namespace, key)
+	}
+	key = cd.prefix + key
+	return strings.TrimPrefix(key, "/"), strings.HasPrefix(key, "/")
+}
+
+// parseKey parses the consul key, removes the prefix and namespace
+func (cd *consulDiscovery) parseKey(key string, rooted bool) string {
+	if rooted {
+		key = "/" + key
+	}
+	key = strings.TrimPrefix(key, cd.prefix)
+	if len(cd.namespace) == 0 {
+		return key
+	}
+	return strings.Replace(key, cd.namespace, "", 1)
+}
+
+func (cd *consulDiscovery) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u := cd.endpoint + (&url.URL{Path: path}).EscapedPath()
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if cd.token != "" {
+		req.Header.Set("X-Consul-Token", cd.token)
+	}
+	if cd.username != "" {
+		req.SetBasicAuth(cd.username, cd.password)
+	}
+	return cd.client.Do(req)
+}
+
+// call 发送请求, 2xx时将响应解码到out
+func (cd *consulDiscovery) call(ctx context.Context, method, path string, query url.Values, body []byte, out interface{}) error {
+	resp, err := cd.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("consul %s %s error: %s %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// list 读取key或前缀下的数据, 返回consul index
+func (cd *consulDiscovery) list(ctx context.Context, key string, recurse bool, index uint64) ([]consulKV, uint64, error) {
+	query := url.Values{}
+	if recurse {
+		query.Set("recurse", "")
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWatchWait.String())
+	}
+	resp, err := cd.do(ctx, http.MethodGet, "/v1/kv/"+key, query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, newIndex, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, 0, fmt.Errorf("consul get %s error: %s", key, resp.Status)
+	}
+	var kvs []consulKV
+	if err := json.NewDecoder(resp.Body).Decode(&kvs); err != nil {
+		return nil, 0, err
+	}
+	return kvs, newIndex, nil
+}
+
+func (cd *consulDiscovery) Get(ctx context.Context, key string) ([]byte, error) {
+	path, _ := cd.keyPath(key)
+	kvs, _, err := cd.list(ctx, path, false, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get value failure for key[%s], error:%s", key, err)
+	}
+	if len(kvs) == 0 {
+		return nil, ErrNotExist
+	}
+	if len(kvs[0].Value) == 0 {
+		return nil, fmt.Errorf("key[%s]'s value is empty", key)
+	}
+	return kvs[0].Value, nil
+}
+
+func (cd *consulDiscovery) List(ctx context.Context, prefix string) ([]KeyValue, error) {
+	path, rooted := cd.keyPath(prefix)
+	kvs, _, err := cd.list(ctx, path, true, 0)
+	if err != nil {
+		return nil, err
+	}
+	var result []KeyValue
+	for _, kv := range kvs {
+		if len(kv.Value) > 0 {
+			result = append(result, KeyValue{Key: cd.parseKey(kv.Key, rooted), Value: kv.Value})
+		}
+	}
+	return result, nil
+}
+
+func (cd *consulDiscovery) Put(ctx context.Context, key string, val []byte) error {
+	path, _ := cd.keyPath(key)
+	return cd.call(ctx, http.MethodPut, "/v1/kv/"+path, nil, val, nil)
+}
+
+func (cd *consulDiscovery) Delete(ctx context.Context, key string) error {
+	path, _ := cd.keyPath(key)
+	return cd.call(ctx, http.MethodDelete, "/v1/kv/"+path, nil, nil, nil)
+}
+
+func (cd *consulDiscovery) createSession(ctx context.Context, ttl int64) (string, error) {
+	if ttl < consulMinTTL {
+		ttl = consulMinTTL
+	}
+	body, _ := json.Marshal(map[string]string{
+		"TTL":       fmt.Sprintf("%ds", ttl),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	})
+	var session struct{ ID string }
+	if err := cd.call(ctx, http.MethodPut, "/v1/session/create", nil, body, &session); err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+func (cd *consulDiscovery) Heartbeat(ctx context.Context, key string, value []byte, ttl int64) (<-chan Closed, error) {
+	ttl = leaseTTL(ttl)
+	session, err := cd.createSession(ctx, ttl)
+	if err != nil {
+		return nil, err
+	}
+	path, _ := cd.keyPath(key)
+	var acquired bool
+	query := url.Values{"acquire": []string{session}}
+	err = cd.call(ctx, http.MethodPut, "/v1/kv/"+path, query, value, &acquired)
+	if err == nil && !acquired {
+		err = fmt.Errorf("key[%s] is held by another session", key)
+	}
+	if err != nil {
+		_ = cd.call(ctx, http.MethodPut, "/v1/session/destroy/"+session, nil, nil, nil)
+		return nil, err
+	}
+	return cd.keepAlive(ctx, session, ttl), nil
+}
+
+func (cd *consulDiscovery) Elect(ctx context.Context, key string, value []byte, ttl int64) (bool, <-chan Closed, error) {
+	success, _, lost, err := cd.ElectRevision(ctx, key, value, ttl)
+	return success, lost, err
+}
+
+// ElectRevision 返回选举事务写入的leader key的ModifyIndex
+func (cd *consulDiscovery) ElectRevision(ctx context.Context, key string, value []byte, ttl int64) (bool, int64, <-chan Closed, error) {
+	ttl = leaseTTL(ttl)
+	session, err := cd.createSession(ctx, ttl)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	path, _ := cd.keyPath(key)
+	success, results, err := cd.txnResults(ctx, []consulTxnOp{
+		{KV: consulTxnKV{Verb: "check-not-exists", Key: path}},
+		{KV: consulTxnKV{Verb: "lock", Key: path, Value: value, Session: session}},
+	})
+	if err != nil || !success {
+		_ = cd.call(ctx, http.MethodPut, "/v1/session/destroy/"+session, nil, nil, nil)
+		return false, 0, nil, err
+	}
+	var rev int64
+	for _, kv := range results {
+		if kv.Key == path {
+			rev = int64(kv.ModifyIndex)
+		}
+	}
+	return true, rev, cd.keepAlive(ctx, session, ttl), nil
+}
+
+// keepAlive 后台renew session, session过期后删除key.
+// ctx结束后destroy session并立即删除key, 同一key可以马上重新Heartbeat
+func (cd *consulDiscovery) keepAlive(ctx context.Context, session string, ttl int64) <-chan Closed {
+	ch := make(chan Closed)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(time.Duration(ttl) * time.Second / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				cd.destroySession(session)
+				return
+			case <-ticker.C:
+				resp, err := cd.do(ctx, http.MethodPut, "/v1/session/renew/"+session, nil, nil)
+				if err != nil {
+					cd.metrics.failures.Inc(1)
+					cd.logger.Error("renew consul session error, retry.", logger.Error(err), logger.String("session", session))
+					continue
+				}
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusNotFound {
+					cd.metrics.failures.Inc(1)
+					cd.logger.Error("consul session expired, stop keepalive", logger.String("session", session))
+					return
+				}
+				cd.metrics.renewals.Inc(1)
+			}
+		}
+	}()
+	return ch
+}
+
+func (cd *consulDiscovery) destroySession(session string) {
+	ctx, cancel := context.WithTimeout(context.Background(), consulDestroyTimeout)
+	defer cancel()
+	if err := cd.call(ctx, http.MethodPut, "/v1/session/destroy/"+session, nil, nil, nil); err != nil {
+		cd.logger.Error("destroy consul session error", logger.Error(err), logger.String("session", session))
+	}
+}
+
+func (cd *consulDiscovery) Watch(ctx context.Context, key string, fetchVal bool) WatchEventChan {
+	path, rooted := cd.keyPath(key)
+	return cd.watch(ctx, path, rooted, false)
+}
+
+func (cd *consulDiscovery) WatchPrefix(ctx context.Context, prefixKey string, fetchVal bool) WatchEventChan {
+	path, rooted := cd.keyPath(prefixKey)
+	return cd.watch(ctx, path, rooted, true)
+}
+
+// watch 通过blocking query获得变化, 与上一次结果比较生成modify和delete event
+func (cd *consulDiscovery) watch(ctx context.Context, key string, rooted, recurse bool) WatchEventChan {
+	eventCh := make(chan *Event)
+	go func() {
+		defer close(eventCh)
+		send := func(evt *Event) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case eventCh <- evt:
+				return true
+			}
+		}
+
+		var (
+			index uint64
+			last  map[string]consulKV
+		)
+		for {
+			kvs, newIndex, err := cd.list(ctx, key, recurse, index)
+			if err != nil {
+				if ctx.Err() != nil || !send(&Event{Err: err}) {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(defaultRetryInterval):
+				}
+				continue
+			}
+			current := make(map[string]consulKV, len(kvs))
+			for _, kv := range kvs {
+				current[kv.Key] = kv
+			}
+
+			var events []*Event
+			if last == nil {
+				evt := &Event{Type: EventTypeAll}
+				for _, kv := range kvs {
+					evt.KeyValues = append(evt.KeyValues, EventKeyValue{Key: cd.parseKey(kv.Key, rooted), Value: kv.Value, Rev: int64(kv.ModifyIndex)})
+				}
+				events = append(events, evt)
+			} else {
+				for _, kv := range kvs {
+					if old, ok := last[kv.Key]; !ok || old.ModifyIndex != kv.ModifyIndex {
+						events = append(events, &Event{
+							Type:      EventTypeModify,
+							KeyValues: []EventKeyValue{{Key: cd.parseKey(kv.Key, rooted), Value: kv.Value, Rev: int64(kv.ModifyIndex)}},
+						})
+					}
+				}
+				for k := range last {
+					if _, ok := current[k]; !ok {
+						events = append(events, &Event{
+							Type:      EventTypeDelete,
+							KeyValues: []EventKeyValue{{Key: cd.parseKey(k, rooted), Rev: int64(newIndex)}},
+						})
+					}
+				}
+			}
+			for _, evt := range events {
+				if !send(evt) {
+					return
+				}
+			}
+			last = current
+			// index回退时重新开始blocking query
+			if newIndex < index {
+				newIndex = 0
+			}
+			index = newIndex
+		}
+	}()
+	return eventCh
+}
+
+// txn 执行consul事务, 检查失败时返回false
+func (cd *consulDiscovery) txn(ctx context.Context, ops []consulTxnOp) (bool, error) {
+	success, _, err := cd.txnResults(ctx, ops)
+	return success, err
+}
+
+// txnResults 执行txn, 成功时返回写入op的结果
+func (cd *consulDiscovery) txnResults(ctx context.Context, ops []consulTxnOp) (bool, []consulKV, error) {
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return false, nil, err
+	}
+	resp, err := cd.do(ctx, http.MethodPut, "/v1/txn", nil, body)
+	if err != nil {
+		return false, nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var result consulTxnResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+			return false, nil, fmt.Errorf("decode consul txn response error: %w", err)
+		}
+		kvs := make([]consulKV, 0, len(result.Results))
+		for _, r := range result.Results {
+			kvs = append(kvs, r.KV)
+		}
+		return true, kvs, nil
+	case http.StatusConflict:
+		return false, nil, nil
+	default:
+		msg, _ := io.ReadAll(resp.Body)
+		return false, nil, fmt.Errorf("consul txn error: %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+}
+
+func (cd *consulDiscovery) Batch(ctx context.Context, batch Batch) (bool, error) {
+	var ops []consulTxnOp
+	for _, kv := range batch.KVs {
+		path, _ := cd.keyPath(kv.Key)
+		ops = append(ops, consulTxnOp{KV: consulTxnKV{Verb: "set", Key: path, Value: kv.Value}})
+	}
+	return cd.txn(ctx, ops)
+}
+
+func (cd *consulDiscovery) NewTransaction() Transaction {
+	return &consulTransaction{cd: cd, then: &consulTxnOps{cd: cd}, els: &consulTxnOps{cd: cd}}
+}
+
+func (cd *consulDiscovery) Commit(ctx context.Context, txn Transaction) error {
+	result, err := cd.Txn(ctx, txn)
+	if err != nil {
+		return err
+	}
+	if !result.Succeeded {
+		return ErrTxnFailed
+	}
+	return nil
+}
+
+// Txn consul事务不支持分支和比较value, 先读取涉及的key在本地计算比较结果,
+// 再以check-index/check-not-exists保证这些key未被修改的前提下执行对应分支; key被并发修改时重试
+func (cd *consulDiscovery) Txn(ctx context.Context, txn Transaction) (*TxnResult, error) {
+	t, ok := txn.(*consulTransaction)
+	if !ok {
+		return nil, ErrTxnConvert
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	retry := &backoff{interval: consulTxnBackoff}
+	for attempt := 0; ; attempt++ {
+		snapshot, guards, err := cd.snapshot(ctx, t.keys())
+		if err != nil {
+			return nil, err
+		}
+		succeeded := true
+		for _, cmp := range t.cmps {
+			if !cmp.check(snapshot[cmp.key]) {
+				succeeded = false
+				break
+			}
+		}
+		branch := t.then
+		if !succeeded {
+			branch = t.els
+		}
+		var writes []consulTxnOp
+		for _, op := range branch.ops {
+			if op.KV.Verb != "get" {
+				writes = append(writes, op)
+			}
+		}
+		ops := append(guards, writes...)
+		applied := true
+		if len(ops) > 0 {
+			if applied, err = cd.txn(ctx, ops); err != nil {
+				return nil, err
+			}
+		}
+		if applied {
+			return &TxnResult{Succeeded: succeeded, Responses: branch.responses(snapshot)}, nil
+		}
+		// 读取之后key被并发修改, 退避后重新读取
+		if attempt >= consulTxnRetries {
+			return nil, ErrTxnConflict
+		}
+		if !retry.wait(ctx) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// snapshot 读取keys的当前值, 返回保证这些key未被修改的检查操作
+func (cd *consulDiscovery) snapshot(ctx context.Context, keys []string) (map[string]*consulKV, []consulTxnOp, error) {
+	snapshot := make(map[string]*consulKV, len(keys))
+	var guards []consulTxnOp
+	for _, key := range keys {
+		kvs, _, err := cd.list(ctx, key, false, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(kvs) == 0 {
+			guards = append(guards, consulTxnOp{KV: consulTxnKV{Verb: "check-not-exists", Key: key}})
+			continue
+		}
+		snapshot[key] = &kvs[0]
+		guards = append(guards, consulTxnOp{KV: consulTxnKV{Verb: "check-index", Key: key, Index: kvs[0].ModifyIndex}})
+	}
+	return snapshot, guards, nil
+}
+
+func (cd *consulDiscovery) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	return compareAndSwap(ctx, cd, key, old, new)
+}
+
+func (cd *consulDiscovery) Close() error {
+	cd.client.CloseIdleConnections()
+	return nil
+}
+
+type consulTransaction struct {
+	cd   *consulDiscovery
+	cmps []consulCmp
+	then *consulTxnOps
+	els  *consulTxnOps
+	err  error
+}
+
+// consulCmp 在本地比较读取到的key, key不存在时kv为nil
+type consulCmp struct {
+	key   string
+	check func(kv *consulKV) bool
+}
+
+// keys 比较和读取涉及的key, 去重
+func (t *consulTransaction) keys() []string {
+	seen := make(map[string]struct{})
+	var keys []string
+	add := func(key string) {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	for _, cmp := range t.cmps {
+		add(cmp.key)
+	}
+	for _, ops := range []*consulTxnOps{t.then, t.els} {
+		for _, op := range ops.ops {
+			if op.KV.Verb != "set" {
+				add(op.KV.Key)
+			}
+		}
+	}
+	return keys
+}
+
+// ModRevisionCmp revision对应consul ModifyIndex, key不存在时为0
+func (t *consulTransaction) ModRevisionCmp(key, op string, v interface{}) {
+	t.indexCmp(key, op, v, func(kv *consulKV) uint64 { return kv.ModifyIndex })
+}
+
+// CreateRevisionCmp revision对应consul CreateIndex, key不存在时为0
+func (t *consulTransaction) CreateRevisionCmp(key, op string, v interface{}) {
+	t.indexCmp(key, op, v, func(kv *consulKV) uint64 { return kv.CreateIndex })
+}
+
+// VersionCmp consul不记录key的修改次数, 不支持
+func (t *consulTransaction) VersionCmp(key, op string, v interface{}) {
+	t.err = fmt.Errorf("consul transaction does not support version compare on key[%s]", key)
+}
+
+func (t *consulTransaction) indexCmp(key, op string, v interface{}, field func(kv *consulKV) uint64) {
+	if _, ok := toRevision(v); !ok {
+		t.err = fmt.Errorf("consul transaction does not support compare %s %v", op, v)
+		return
+	}
+	path, _ := t.cd.keyPath(key)
+	t.cmps = append(t.cmps, consulCmp{key: path, check: func(kv *consulKV) bool {
+		var rev int64
+		if kv != nil {
+			rev = int64(field(kv))
+		}
+		return compareRevision(rev, op, v)
+	}})
+}
+
+func (t *consulTransaction) ValueCmp(key, op string, value []byte) {
+	path, _ := t.cd.keyPath(key)
+	t.cmps = append(t.cmps, consulCmp{key: path, check: func(kv *consulKV) bool {
+		return kv != nil && compareValue(kv.Value, op, value)
+	}})
+}
+
+func (t *consulTransaction) KeyMissing(key string) {
+	path, _ := t.cd.keyPath(key)
+	t.cmps = append(t.cmps, consulCmp{key: path, check: func(kv *consulKV) bool { return kv == nil }})
+}
+
+func (t *consulTransaction) Put(key string, value []byte) {
+	t.then.Put(key, value)
+}
+
+func (t *consulTransaction) Delete(key string) {
+	t.then.Delete(key)
+}
+
+func (t *consulTransaction) Get(key string) {
+	t.then.Get(key)
+}
+
+func (t *consulTransaction) Else() TxnOps {
+	return t.els
+}
+
+// consulTxnOps 事务一个分支中的操作, get只在本地根据读取的数据返回结果
+type consulTxnOps struct {
+	cd   *consulDiscovery
+	ops  []consulTxnOp
+	keys []string
+}
+
+func (o *consulTxnOps) add(key, verb string, value []byte) {
+	path, _ := o.cd.keyPath(key)
+	o.ops = append(o.ops, consulTxnOp{KV: consulTxnKV{Verb: verb, Key: path, Value: value}})
+	o.keys = append(o.keys, key)
+}
+
+func (o *consulTxnOps) Put(key string, value []byte) {
+	o.add(key, "set", value)
+}
+
+func (o *consulTxnOps) Delete(key string) {
+	o.add(key, "delete", nil)
+}
+
+func (o *consulTxnOps) Get(key string) {
+	o.add(key, "get", nil)
+}
+
+// responses 在事务前读取的数据上依次应用操作生成结果; 事务内写入后再读取的key没有revision
+func (o *consulTxnOps) responses(snapshot map[string]*consulKV) []TxnResponse {
+	responses := make([]TxnResponse, 0, len(o.ops))
+	for i, op := range o.ops {
+		resp := TxnResponse{Key: o.keys[i]}
+		kv := snapshot[op.KV.Key]
+		switch op.KV.Verb {
+		case "get":
+			resp.Type = TxnOpGet
+			if kv != nil {
+				resp.Value, resp.Rev, resp.Found = kv.Value, int64(kv.ModifyIndex), true
+			}
+		case "delete":
+			resp.Type = TxnOpDelete
+			resp.Found = kv != nil
+			delete(snapshot, op.KV.Key)
+		default:
+			resp.Type = TxnOpPut
+			snapshot[op.KV.Key] = &consulKV{Key: op.KV.Key, Value: op.KV.Value}
+		}
+		responses = append(responses, resp)
+	}
+	return responses
+}
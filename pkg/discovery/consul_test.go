@@ -0,0 +1,329 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+)
+
+// fakeConsul 实现consul KV, session和txn API的一个子集
+type fakeConsul struct {
+	mu        sync.Mutex
+	index     uint64
+	kvs       map[string]consulKV
+	sessions  int
+	destroyed []string
+	changed   chan struct{}
+}
+
+func newFakeConsul() (*httptest.Server, *fakeConsul) {
+	fc := &fakeConsul{kvs: make(map[string]consulKV), changed: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/kv/", fc.handleKV)
+	mux.HandleFunc("/v1/txn", fc.handleTxn)
+	mux.HandleFunc("/v1/session/create", func(w http.ResponseWriter, r *http.Request) {
+		fc.mu.Lock()
+		fc.sessions++
+		id := strconv.Itoa(fc.sessions)
+		fc.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	})
+	mux.HandleFunc("/v1/session/destroy/", func(w http.ResponseWriter, r *http.Request) {
+		fc.mu.Lock()
+		fc.destroyed = append(fc.destroyed, strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/"))
+		fc.mu.Unlock()
+	})
+	mux.HandleFunc("/v1/session/", func(w http.ResponseWriter, r *http.Request) {})
+	return httptest.NewServer(mux), fc
+}
+
+func (fc *fakeConsul) setLocked(kv consulKV) {
+	fc.index++
+	if old, ok := fc.kvs[kv.Key]; ok {
+		kv.CreateIndex = old.CreateIndex
+	} else {
+		kv.CreateIndex = fc.index
+	}
+	kv.ModifyIndex = fc.index
+	fc.kvs[kv.Key] = kv
+	close(fc.changed)
+	fc.changed = make(chan struct{})
+}
+
+func (fc *fakeConsul) deleteLocked(key string) {
+	fc.index++
+	delete(fc.kvs, key)
+	close(fc.changed)
+	fc.changed = make(chan struct{})
+}
+
+func (fc *fakeConsul) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	query := r.URL.Query()
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		if index, _ := strconv.ParseUint(query.Get("index"), 10, 64); index > 0 && index >= fc.index {
+			changed := fc.changed
+			fc.mu.Unlock()
+			select {
+			case <-changed:
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			fc.mu.Lock()
+		}
+		var result []consulKV
+		for k, kv := range fc.kvs {
+			if k == key || (query.Has("recurse") && strings.HasPrefix(k, key)) {
+				result = append(result, kv)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(fc.index, 10))
+		if len(result) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	case http.MethodPut:
+		value, _ := io.ReadAll(r.Body)
+		session := query.Get("acquire")
+		if old, ok := fc.kvs[key]; ok && session != "" && old.Session != "" && old.Session != session {
+			_, _ = w.Write([]byte("false"))
+			return
+		}
+		fc.setLocked(consulKV{Key: key, Value: value, Session: session})
+		_, _ = w.Write([]byte("true"))
+	case http.MethodDelete:
+		fc.deleteLocked(key)
+	}
+}
+
+func (fc *fakeConsul) handleTxn(w http.ResponseWriter, r *http.Request) {
+	var ops []consulTxnOp
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for _, op := range ops {
+		kv, ok := fc.kvs[op.KV.Key]
+		if (op.KV.Verb == "check-not-exists" && ok) || (op.KV.Verb == "check-index" && (!ok || kv.ModifyIndex != op.KV.Index)) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	}
+	var result consulTxnResponse
+	for _, op := range ops {
+		switch op.KV.Verb {
+		case "set", "lock":
+			fc.setLocked(consulKV{Key: op.KV.Key, Value: op.KV.Value, Session: op.KV.Session})
+			kv := fc.kvs[op.KV.Key]
+			kv.Value = nil
+			result.Results = append(result.Results, struct{ KV consulKV }{KV: kv})
+		case "delete":
+			fc.deleteLocked(op.KV.Key)
+		}
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	assert := assert.New(t)
+	server, fc := newFakeConsul()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	factory := NewDiscoveryFactory("nobody")
+	_, err := factory.CreateDiscovery(config.Discovery{Type: config.DISCOVERYCONSUL})
+	assert.NotNil(err)
+	ds, err := factory.CreateDiscovery(config.Discovery{
+		Type:      config.DISCOVERYCONSUL,
+		Namespace: "/test/list",
+		Endpoints: []string{strings.TrimPrefix(server.URL, "http://")},
+	})
+	assert.Nil(err)
+	defer ds.Close()
+
+	_, err = ds.Get(ctx, "/test/key1")
+	assert.ErrorIs(err, ErrNotExist)
+	assert.Nil(ds.Put(ctx, "/test/key1", []byte("dongjiang")))
+	assert.Nil(ds.Put(ctx, "/test/key2", []byte("dongjiang")))
+	assert.Nil(ds.Put(ctx, "/test/key3", []byte{}))
+	val, err := ds.Get(ctx, "/test/key1")
+	assert.Nil(err)
+	assert.Equal("dongjiang", string(val))
+	list, err := ds.List(ctx, "/test")
+	assert.Nil(err)
+	assert.Equal([]KeyValue{{Key: "/test/key1", Value: []byte("dongjiang")}, {Key: "/test/key2", Value: []byte("dongjiang")}}, list)
+
+	ch := ds.WatchPrefix(ctx, "/test", true)
+	evt := <-ch
+	assert.Equal(EventTypeAll, evt.Type)
+	assert.Len(evt.KeyValues, 3)
+	assert.Nil(ds.Delete(ctx, "/test/key3"))
+	evt = <-ch
+	assert.Equal(EventTypeDelete, evt.Type)
+	assert.Equal("/test/key3", evt.KeyValues[0].Key)
+	ok, err := ds.Batch(ctx, Batch{KVs: []KeyValue{{Key: "/test/key4", Value: []byte("4")}}})
+	assert.Nil(err)
+	assert.True(ok)
+	evt = <-ch
+	assert.Equal(EventTypeModify, evt.Type)
+	assert.Equal("/test/key4", evt.KeyValues[0].Key)
+
+	ok, closed, err := ds.Elect(ctx, "/master", []byte("node1"), 1)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.NotNil(closed)
+	ok, _, err = ds.Elect(ctx, "/master", []byte("node2"), 1)
+	assert.Nil(err)
+	assert.False(ok)
+
+	ok, rev, _, err := ds.(RevisionElector).ElectRevision(ctx, "/leader", []byte("node1"), 1)
+	assert.Nil(err)
+	assert.True(ok)
+	evt = <-ds.Watch(ctx, "/leader", true)
+	assert.Equal(evt.KeyValues[0].Rev, rev)
+
+	_, err = ds.Heartbeat(ctx, "/node", []byte("node"), 1)
+	assert.Nil(err)
+	_, err = ds.Heartbeat(ctx, "/master", []byte("node2"), 1)
+	assert.NotNil(err)
+	// 没有获得key时destroy新建的session
+	fc.mu.Lock()
+	assert.Contains(fc.destroyed, strconv.Itoa(fc.sessions))
+	fc.mu.Unlock()
+
+	txn := ds.NewTransaction()
+	txn.ModRevisionCmp("/txn", "=", 0)
+	txn.Put("/txn", []byte("v1"))
+	assert.Nil(ds.Commit(ctx, txn))
+	txn = ds.NewTransaction()
+	txn.ModRevisionCmp("/txn", "=", 0)
+	txn.Delete("/txn")
+	assert.ErrorIs(ds.Commit(ctx, txn), ErrTxnFailed)
+	txn = ds.NewTransaction()
+	txn.ModRevisionCmp("/txn", ">", 0)
+	assert.Nil(ds.Commit(ctx, txn))
+	txn = ds.NewTransaction()
+	txn.VersionCmp("/txn", ">", 0)
+	assert.NotNil(ds.Commit(ctx, txn))
+
+	txn = ds.NewTransaction()
+	txn.ValueCmp("/txn", "=", []byte("v2"))
+	txn.Put("/txn", []byte("v3"))
+	txn.Else().Get("/txn")
+	txn.Else().Delete("/missing")
+	result, err := ds.Txn(ctx, txn)
+	assert.Nil(err)
+	assert.False(result.Succeeded)
+	value, found := result.Get("/txn")
+	assert.True(found)
+	assert.Equal("v1", string(value))
+	assert.False(result.Responses[1].Found)
+
+	ok, err = ds.CompareAndSwap(ctx, "/txn", []byte("v1"), []byte("v2"))
+	assert.Nil(err)
+	assert.True(ok)
+	ok, err = ds.CompareAndSwap(ctx, "/txn", []byte("v1"), []byte("v3"))
+	assert.Nil(err)
+	assert.False(ok)
+	ok, err = ds.CompareAndSwap(ctx, "/cas", nil, []byte("v1"))
+	assert.Nil(err)
+	assert.True(ok)
+	val, err = ds.Get(ctx, "/txn")
+	assert.Nil(err)
+	assert.Equal("v2", string(val))
+	assert.ErrorIs(ds.Commit(ctx, &memoryTransaction{}), ErrTxnConvert)
+}
+
+func TestConsulDiscovery_Auth(t *testing.T) {
+	assert := assert.New(t)
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ds, err := NewDiscoveryFactory("nobody").CreateDiscovery(config.Discovery{
+		Type:      config.DISCOVERYCONSUL,
+		Endpoints: []string{server.URL},
+		Token:     "acl-token",
+		Username:  "user",
+		Password:  "pass",
+	})
+	assert.Nil(err)
+	_, err = ds.Get(context.TODO(), "/key")
+	assert.ErrorIs(err, ErrNotExist)
+	header := <-headers
+	assert.Equal("acl-token", header.Get("X-Consul-Token"))
+	assert.Equal("Basic dXNlcjpwYXNz", header.Get("Authorization"))
+}
+
+func TestConsulDiscovery_TxnConflict(t *testing.T) {
+	assert := assert.New(t)
+	var txns int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/txn" {
+			atomic.AddInt32(&txns, 1)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ds, err := NewDiscoveryFactory("nobody").CreateDiscovery(config.Discovery{
+		Type:      config.DISCOVERYCONSUL,
+		Endpoints: []string{server.URL},
+	})
+	assert.Nil(err)
+	defer ds.Close()
+
+	txn := ds.NewTransaction()
+	txn.ModRevisionCmp("/txn", "=", 0)
+	txn.Put("/txn", []byte("v1"))
+	_, err = ds.Txn(context.Background(), txn)
+	assert.ErrorIs(err, ErrTxnConflict)
+	assert.Equal(int32(consulTxnRetries+1), atomic.LoadInt32(&txns))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = ds.Txn(ctx, txn)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+}
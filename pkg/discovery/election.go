@@ -0,0 +1,282 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+var (
+	ErrNoLeader       = fmt.Errorf("election has no leader")
+	ErrElectionClosed = fmt.Errorf("election is closed")
+)
+
+// Election 基于Discovery.Elect的leader选举, 可用于所有discovery后端
+type Election struct {
+	ds     Discovery
+	key    string
+	value  []byte
+	ttl    int64
+	logger *logger.Logger
+
+	mu          sync.Mutex
+	closed      bool
+	cancel      context.CancelFunc // 停止keepalive
+	leaderCtx   context.Context
+	leaderRev   int64
+	campaigning bool
+}
+
+// NewElection 在key上以value参与选举, ttl为leader lease时长(秒)
+func NewElection(ds Discovery, key string, value []byte, ttl int64) *Election {
+	return &Election{
+		ds:     ds,
+		key:    key,
+		value:  value,
+		ttl:    ttl,
+		logger: logger.GetLogger("pkg/common/discovery", "Election"),
+	}
+}
+
+// Campaign 阻塞直到成为leader或ctx结束. ctx只用于等待, 成为leader后直到Resign或失去lease前保持leader.
+// 已是leader时直接返回, Election不做进程内互斥, 需要互斥时使用locker.Mutex
+func (e *Election) Campaign(ctx context.Context) error {
+	if err := e.startCampaign(); err != nil {
+		return err
+	}
+	defer e.stopCampaign()
+
+	for {
+		// 先watch再竞选, 不会错过leader key的删除
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		events := e.ds.Watch(watchCtx, e.key, true)
+		success, err := e.campaign()
+		if err != nil || success {
+			stopWatch()
+			return err
+		}
+		err = e.waitLeaderGone(ctx, events)
+		stopWatch()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// TryCampaign 竞选一次, 返回是否成为leader, 不等待
+func (e *Election) TryCampaign(ctx context.Context) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if err := e.startCampaign(); err != nil {
+		return false, err
+	}
+	defer e.stopCampaign()
+	return e.campaign()
+}
+
+func (e *Election) startCampaign() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrElectionClosed
+	}
+	if e.campaigning {
+		return fmt.Errorf("election on key[%s] is already campaigning", e.key)
+	}
+	e.campaigning = true
+	return nil
+}
+
+func (e *Election) stopCampaign() {
+	e.mu.Lock()
+	e.campaigning = false
+	e.mu.Unlock()
+}
+
+func (e *Election) campaign() (bool, error) {
+	if e.IsLeader() {
+		return true, nil
+	}
+	keepCtx, cancel := context.WithCancel(context.Background())
+	var (
+		success bool
+		rev     int64
+		lost    <-chan Closed
+		err     error
+	)
+	// revision必须来自选举事务本身, 之后再读取时key可能已属于其他leader
+	if re, ok := e.ds.(RevisionElector); ok {
+		success, rev, lost, err = re.ElectRevision(keepCtx, e.key, e.value, e.ttl)
+	} else {
+		success, lost, err = e.ds.Elect(keepCtx, e.key, e.value, e.ttl)
+	}
+	if err != nil || !success {
+		cancel()
+		return false, err
+	}
+	return true, e.becomeLeader(keepCtx, cancel, lost, rev)
+}
+
+func (e *Election) waitLeaderGone(ctx context.Context, events WatchEventChan) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case evt, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return nil
+			}
+			if evt.Err != nil {
+				continue
+			}
+			if evt.Type == EventTypeDelete || (evt.Type == EventTypeAll && len(evt.KeyValues) == 0) {
+				return nil
+			}
+		}
+	}
+}
+
+func (e *Election) becomeLeader(keepCtx context.Context, cancel context.CancelFunc, lost <-chan Closed, rev int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		cancel()
+		return ErrElectionClosed
+	}
+	if e.cancel != nil {
+		// 上一次的leadership已丢失
+		e.cancel()
+	}
+	leaderCtx, leaderDone := context.WithCancel(keepCtx)
+	e.cancel = cancel
+	e.leaderCtx = leaderCtx
+	e.leaderRev = rev
+	go func() {
+		select {
+		case <-lost:
+			e.logger.Error("election leadership lost", logger.String("key", e.key))
+		case <-leaderCtx.Done():
+		}
+		leaderDone()
+	}()
+	return nil
+}
+
+// Resign 放弃leader, 不是leader时直接返回
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	cancel, rev := e.cancel, e.leaderRev
+	e.cancel, e.leaderCtx, e.leaderRev = nil, nil, 0
+	e.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	if rev > 0 {
+		txn := e.ds.NewTransaction()
+		txn.ModRevisionCmp(e.key, "=", rev)
+		txn.Delete(e.key)
+		err := e.ds.Commit(ctx, txn)
+		if err == nil || !errors.Is(err, ErrTxnFailed) {
+			return err
+		}
+	}
+	// revision已变化(例如keepalive重新写入), 仍是自己的value时原子删除
+	txn := e.ds.NewTransaction()
+	txn.ValueCmp(e.key, "=", e.value)
+	txn.Delete(e.key)
+	if err := e.ds.Commit(ctx, txn); err != nil && !errors.Is(err, ErrTxnFailed) {
+		return err
+	}
+	return nil
+}
+
+// Rev 成为leader时选举事务写入的leader key的revision, 随每次选举单调递增, 可作为fencing token;
+// 不是leader或discovery后端没有实现RevisionElector时为0
+func (e *Election) Rev() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leaderCtx == nil || e.leaderCtx.Err() != nil {
+		return 0
+	}
+	return e.leaderRev
+}
+
+// IsLeader 当前是否是leader
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leaderCtx != nil && e.leaderCtx.Err() == nil
+}
+
+// LeaderContext 在失去leader或Resign后被cancel; 不是leader时返回已cancel的context
+func (e *Election) LeaderContext() context.Context {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leaderCtx != nil {
+		return e.leaderCtx
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// Leader 当前leader的value
+func (e *Election) Leader(ctx context.Context) ([]byte, error) {
+	val, err := e.ds.Get(ctx, e.key)
+	if errors.Is(err, ErrNotExist) {
+		return nil, ErrNoLeader
+	}
+	return val, err
+}
+
+// Observe 推送每一次leader变化后的value, ctx结束后关闭
+func (e *Election) Observe(ctx context.Context) <-chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		for evt := range e.ds.Watch(ctx, e.key, true) {
+			if evt.Err != nil || evt.Type == EventTypeDelete || len(evt.KeyValues) == 0 {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- evt.KeyValues[0].Value:
+			}
+		}
+	}()
+	return ch
+}
+
+// Close 放弃leader并不再参与选举
+func (e *Election) Close(ctx context.Context) error {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	return e.Resign(ctx)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+)
+
+func TestElection(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestMemoryDiscovery(t, config.Discovery{})
+	e1 := NewElection(ds, "/election/master", []byte("node1"), 1)
+	e2 := NewElection(ds, "/election/master", []byte("node2"), 1)
+
+	_, err := e1.Leader(ctx)
+	assert.ErrorIs(err, ErrNoLeader)
+	assert.False(e1.IsLeader())
+	assert.NotNil(e1.LeaderContext().Err())
+
+	observed := e2.Observe(ctx)
+	assert.Nil(e1.Campaign(ctx))
+	assert.Nil(e1.Campaign(ctx))
+	assert.True(e1.IsLeader())
+	assert.True(e1.Rev() > 0)
+	assert.Equal(int64(0), e2.Rev())
+	ok, err := e2.TryCampaign(ctx)
+	assert.Nil(err)
+	assert.False(ok)
+	leaderCtx := e1.LeaderContext()
+	assert.Nil(leaderCtx.Err())
+	assert.Equal("node1", string(<-observed))
+
+	leader, err := e2.Leader(ctx)
+	assert.Nil(err)
+	assert.Equal("node1", string(leader))
+
+	// e2 阻塞直到e1 resign
+	campaigned := make(chan error, 1)
+	go func() { campaigned <- e2.Campaign(ctx) }()
+	select {
+	case <-campaigned:
+		t.Fatal("campaign should block while another node is the leader")
+	case <-time.After(1500 * time.Millisecond):
+	}
+	assert.True(e1.IsLeader())
+
+	assert.Nil(e1.Resign(ctx))
+	assert.Nil(e1.Resign(ctx))
+	assert.NotNil(leaderCtx.Err())
+	assert.False(e1.IsLeader())
+	assert.Nil(<-campaigned)
+	assert.True(e2.IsLeader())
+	assert.Equal("node2", string(<-observed))
+
+	// ctx结束时停止等待
+	waitCtx, waitCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer waitCancel()
+	assert.ErrorIs(e1.Campaign(waitCtx), context.DeadlineExceeded)
+
+	assert.Nil(e2.Close(ctx))
+	assert.ErrorIs(e2.Campaign(ctx), ErrElectionClosed)
+	_, err = e1.Leader(ctx)
+	assert.ErrorIs(err, ErrNoLeader)
+}
+
+func TestElection_lost(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	ds := newTestMemoryDiscovery(t, config.Discovery{})
+	e := NewElection(ds, "/election/lost", []byte("node1"), 1)
+	assert.Nil(e.Campaign(ctx))
+	leaderCtx := e.LeaderContext()
+
+	// 其他节点删除key并成为leader, 本节点lease被撤销后失去leader
+	md := ds.(*memoryDiscovery)
+	kv, ok := md.store.get("/election/lost")
+	assert.True(ok)
+	md.store.revoke(kv.lease)
+	select {
+	case <-leaderCtx.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("leader context is not cancelled after leadership lost")
+	}
+	assert.False(e.IsLeader())
+	assert.Nil(e.Resign(ctx))
+}
+
+func TestElection_revision(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	ds := newTestMemoryDiscovery(t, config.Discovery{})
+	md := ds.(*memoryDiscovery)
+
+	e := NewElection(ds, "/election/rev", []byte("node1"), 1)
+	assert.Nil(e.Campaign(ctx))
+	kv, ok := md.store.get("/election/rev")
+	assert.True(ok)
+	rev := e.Rev()
+	assert.Equal(kv.modRevision, rev)
+
+	// key被其他leader覆盖后, fencing token仍是本次选举的revision, Resign不删除其他leader的key
+	assert.Nil(ds.Put(ctx, "/election/rev", []byte("node2")))
+	assert.Equal(rev, e.Rev())
+	assert.Nil(e.Resign(ctx))
+	val, err := ds.Get(ctx, "/election/rev")
+	assert.Nil(err)
+	assert.Equal("node2", string(val))
+
+	// revision变化但仍是自己的value时删除
+	assert.Nil(ds.Delete(ctx, "/election/rev"))
+	assert.Nil(e.Campaign(ctx))
+	assert.Nil(ds.Put(ctx, "/election/rev", []byte("node1")))
+	assert.Nil(e.Resign(ctx))
+	_, err = ds.Get(ctx, "/election/rev")
+	assert.ErrorIs(err, ErrNotExist)
+
+	// 后端没有实现RevisionElector时没有fencing token
+	plain := NewElection(struct{ Discovery }{ds}, "/election/plain", []byte("node1"), 1)
+	assert.Nil(plain.Campaign(ctx))
+	assert.True(plain.IsLeader())
+	assert.Equal(int64(0), plain.Rev())
+	assert.Nil(plain.Close(ctx))
+}
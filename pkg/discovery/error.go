@@ -27,6 +27,7 @@ var (
 	ErrNoKey       = fmt.Errorf("etcd has no such key")
 	ErrTxnFailed   = fmt.Errorf("role changed or target revision mismatch")
 	ErrTxnConvert  = fmt.Errorf("cannot covert etcd transaction")
+	ErrTxnConflict = fmt.Errorf("transaction keys modified concurrently, retries exhausted")
 )
 
 // 将txn响应和错误转化为一个错误
@@ -25,16 +25,27 @@ import (
 
 	"github.com/kubeservice-stack/common/pkg/config"
 	"github.com/kubeservice-stack/common/pkg/logger"
+	"github.com/kubeservice-stack/common/pkg/metrics"
 
 	etcdcliv3 "go.etcd.io/etcd/client/v3"
 	etcdcliv3namespace "go.etcd.io/etcd/client/v3/namespace"
+	"google.golang.org/grpc"
 )
 
+func init() {
+	Register(config.DISCOVERYETCD, newEtedDiscovery)
+}
+
 type etcdDiscovery struct {
-	namespace string
-	client    *etcdcliv3.Client
-	prefix    string
-	logger    *logger.Logger
+	namespace      string
+	isolated       bool
+	client         *etcdcliv3.Client
+	prefix         string
+	requestTimeout time.Duration
+	logger         *logger.Logger
+
+	watchMetrics     *watchMetrics
+	heartbeatMetrics *heartbeatMetrics
 }
 
 func newEtedDiscovery(cfg config.Discovery, owner string) (Discovery, error) {
@@ -43,6 +54,17 @@ func newEtedDiscovery(cfg config.Discovery, owner string) (Discovery, error) {
 		MaxCallSendMsgSize: 100 * 1024 * 1024, // 100MiB
 		MaxCallRecvMsgSize: 0,                 // math.MaxInt32
 		DialTimeout:        cfg.DialTimeout.Duration() * time.Second,
+		Username:           cfg.Username,
+		Password:           cfg.Password,
+	}
+	tlsCfg, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cf.TLS = tlsCfg
+	// 用户名密码认证时由client获取token
+	if cfg.Token != "" && cfg.Username == "" {
+		cf.DialOptions = append(cf.DialOptions, grpc.WithPerRPCCredentials(tokenCredential(cfg.Token)))
 	}
 	cli, err := etcdcliv3.New(cf)
 	if err != nil {
@@ -56,10 +78,15 @@ func newEtedDiscovery(cfg config.Discovery, owner string) (Discovery, error) {
 	}
 
 	ed := etcdDiscovery{
-		namespace: cfg.Namespace,
-		client:    cli,
-		prefix:    cfg.Prefix,
-		logger:    logger.GetLogger(owner, "ETCD"),
+		namespace:      ownerNamespace(cfg, owner),
+		isolated:       cfg.IsolateOwner,
+		client:         cli,
+		prefix:         cfg.Prefix,
+		requestTimeout: cfg.RequestTimeout.Duration(),
+		logger:         logger.GetLogger(owner, "ETCD"),
+
+		watchMetrics:     newWatchMetrics(metrics.DefaultTallyScope.Scope),
+		heartbeatMetrics: defaultHeartbeatMetrics,
 	}
 
 	ed.logger.Info("new etcd client successfully",
@@ -75,7 +102,17 @@ func (ed *etcdDiscovery) Get(ctx context.Context, key string) ([]byte, error) {
 	return ed.getValue(key, resp)
 }
 
+// withTimeout 为单次请求设置超时时间
+func (ed *etcdDiscovery) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ed.requestTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, ed.requestTimeout)
+}
+
 func (ed *etcdDiscovery) get(ctx context.Context, key string) (*etcdcliv3.GetResponse, error) {
+	ctx, cancel := ed.withTimeout(ctx)
+	defer cancel()
 	resp, err := ed.client.Get(ctx, ed.keyPath(key))
 	if err != nil {
 		return nil, fmt.Errorf("get value failure for key[%s], error:%s", key, err)
@@ -83,8 +120,11 @@ func (ed *etcdDiscovery) get(ctx context.Context, key string) (*etcdcliv3.GetRes
 	return resp, nil
 }
 
-// keyPath return new key path with namespace prefix
+// keyPath return new key path with namespace prefix, isolated keys never escape the owner namespace
 func (ed *etcdDiscovery) keyPath(key string) string {
+	if ed.isolated {
+		key = isolateKey(key)
+	}
 	if len(ed.namespace) > 0 {
 		return filepath.Join(ed.namespace, key)
 	}
@@ -104,6 +144,8 @@ func (ed *etcdDiscovery) getValue(key string, resp *etcdcliv3.GetResponse) ([]by
 }
 
 func (ed *etcdDiscovery) List(ctx context.Context, prefix string) ([]KeyValue, error) {
+	ctx, cancel := ed.withTimeout(ctx)
+	defer cancel()
 	resp, err := ed.client.Get(ctx, ed.keyPath(prefix), etcdcliv3.WithPrefix())
 	if err != nil {
 		return nil, err
@@ -129,11 +171,15 @@ func (ed *etcdDiscovery) parseKey(key string) string {
 }
 
 func (ed *etcdDiscovery) Put(ctx context.Context, key string, val []byte) error {
+	ctx, cancel := ed.withTimeout(ctx)
+	defer cancel()
 	_, err := ed.client.Put(ctx, ed.keyPath(key), string(val))
 	return err
 }
 
 func (ed *etcdDiscovery) Delete(ctx context.Context, key string) error {
+	ctx, cancel := ed.withTimeout(ctx)
+	defer cancel()
 	_, err := ed.client.Delete(ctx, ed.keyPath(key))
 	return err
 }
@@ -145,6 +191,7 @@ func (ed *etcdDiscovery) Close() error {
 func (ed *etcdDiscovery) Heartbeat(ctx context.Context, key string, value []byte, ttl int64) (<-chan Closed, error) {
 	h := newHeartbeat(ed.client, ed.keyPath(key), value, ttl, false)
 	h.withLogger(ed.logger)
+	h.withMetrics(ed.heartbeatMetrics)
 	_, err := h.grantKeepAliveLease(ctx)
 	if err != nil {
 		return nil, err
@@ -160,13 +207,22 @@ func (ed *etcdDiscovery) Heartbeat(ctx context.Context, key string, value []byte
 }
 
 func (ed *etcdDiscovery) Elect(ctx context.Context, key string, value []byte, ttl int64) (bool, <-chan Closed, error) {
+	success, _, ch, err := ed.ElectRevision(ctx, key, value, ttl)
+	return success, ch, err
+}
+
+// ElectRevision 返回选举事务的revision, 即leader key的create revision
+func (ed *etcdDiscovery) ElectRevision(ctx context.Context, key string, value []byte, ttl int64) (bool, int64, <-chan Closed, error) {
 	h := newHeartbeat(ed.client, ed.keyPath(key), value, ttl, true)
 	h.withLogger(ed.logger)
+	h.withMetrics(ed.heartbeatMetrics)
 	success, err := h.grantKeepAliveLease(ctx)
 	if err != nil {
-		return false, nil, err
+		return false, 0, nil, err
 	}
 	if success {
+		// keepalive失败重新写入时会更新h.revision, 先保存本次选举的revision
+		rev := h.revision
 		ch := make(chan Closed)
 		// 后台gorounte keepalive
 		go func() {
@@ -176,9 +232,9 @@ func (ed *etcdDiscovery) Elect(ctx context.Context, key string, value []byte, tt
 			}()
 			h.keepAlive(ctx)
 		}()
-		return success, ch, nil
+		return success, rev, ch, nil
 	}
-	return success, nil, nil
+	return success, 0, nil, nil
 }
 
 func (ed *etcdDiscovery) Watch(ctx context.Context, key string, fetchVal bool) WatchEventChan {
@@ -192,6 +248,8 @@ func (ed *etcdDiscovery) WatchPrefix(ctx context.Context, prefixKey string, fetc
 }
 
 func (ed *etcdDiscovery) Batch(ctx context.Context, batch Batch) (bool, error) {
+	ctx, cancel := ed.withTimeout(ctx)
+	defer cancel()
 	var ops []etcdcliv3.Op
 	for _, kv := range batch.KVs {
 		ops = append(ops, etcdcliv3.OpPut(
@@ -212,32 +270,120 @@ func (ed *etcdDiscovery) NewTransaction() Transaction {
 }
 
 func (ed *etcdDiscovery) Commit(ctx context.Context, txn Transaction) error {
+	result, err := ed.Txn(ctx, txn)
+	if err != nil {
+		return err
+	}
+	if !result.Succeeded {
+		return ErrTxnFailed
+	}
+	return nil
+}
+
+func (ed *etcdDiscovery) Txn(ctx context.Context, txn Transaction) (*TxnResult, error) {
+	ctx, cancel := ed.withTimeout(ctx)
+	defer cancel()
 	t, ok := txn.(*transaction)
 	if !ok {
-		return ErrTxnConvert
+		return nil, ErrTxnConvert
+	}
+	resp, err := ed.client.Txn(ctx).If(t.cmps...).Then(t.then.ops...).Else(t.els.ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	ops := t.then
+	if !resp.Succeeded {
+		ops = t.els
+	}
+	result := &TxnResult{Succeeded: resp.Succeeded}
+	for i, r := range resp.Responses {
+		response := TxnResponse{Type: ops.types[i], Key: ops.keys[i]}
+		switch {
+		case r.GetResponseRange() != nil:
+			if kvs := r.GetResponseRange().Kvs; len(kvs) > 0 {
+				response.Value, response.Rev, response.Found = kvs[0].Value, kvs[0].ModRevision, true
+			}
+		case r.GetResponseDeleteRange() != nil:
+			response.Found = r.GetResponseDeleteRange().Deleted > 0
+		}
+		result.Responses = append(result.Responses, response)
 	}
-	resp, err := ed.client.Txn(ctx).If(t.cmps...).Then(t.ops...).Commit()
-	return TxnErr(resp, err)
+	return result, nil
+}
+
+func (ed *etcdDiscovery) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	return compareAndSwap(ctx, ed, key, old, new)
 }
 
 type transaction struct {
-	ops  []etcdcliv3.Op
 	cmps []etcdcliv3.Cmp
+	then *txnOps
+	els  *txnOps
 	ed   *etcdDiscovery
 }
 
 func newTransaction(ed *etcdDiscovery) Transaction {
-	return &transaction{ed: ed}
+	return &transaction{ed: ed, then: &txnOps{ed: ed}, els: &txnOps{ed: ed}}
 }
 
 func (t *transaction) ModRevisionCmp(key, op string, v interface{}) {
 	t.cmps = append(t.cmps, etcdcliv3.Compare(etcdcliv3.ModRevision(t.ed.keyPath(key)), op, v))
 }
 
+func (t *transaction) CreateRevisionCmp(key, op string, v interface{}) {
+	t.cmps = append(t.cmps, etcdcliv3.Compare(etcdcliv3.CreateRevision(t.ed.keyPath(key)), op, v))
+}
+
+func (t *transaction) VersionCmp(key, op string, v interface{}) {
+	t.cmps = append(t.cmps, etcdcliv3.Compare(etcdcliv3.Version(t.ed.keyPath(key)), op, v))
+}
+
+func (t *transaction) ValueCmp(key, op string, value []byte) {
+	t.cmps = append(t.cmps, etcdcliv3.Compare(etcdcliv3.Value(t.ed.keyPath(key)), op, string(value)))
+}
+
+func (t *transaction) KeyMissing(key string) {
+	t.CreateRevisionCmp(key, "=", 0)
+}
+
 func (t *transaction) Put(key string, value []byte) {
-	t.ops = append(t.ops, etcdcliv3.OpPut(t.ed.keyPath(key), string(value)))
+	t.then.Put(key, value)
 }
 
 func (t *transaction) Delete(key string) {
-	t.ops = append(t.ops, etcdcliv3.OpDelete(t.ed.keyPath(key)))
+	t.then.Delete(key)
+}
+
+func (t *transaction) Get(key string) {
+	t.then.Get(key)
+}
+
+func (t *transaction) Else() TxnOps {
+	return t.els
+}
+
+// txnOps 事务一个分支中的操作, keys和types用于生成TxnResponse
+type txnOps struct {
+	ed    *etcdDiscovery
+	ops   []etcdcliv3.Op
+	keys  []string
+	types []TxnOpType
+}
+
+func (o *txnOps) add(key string, typ TxnOpType, op etcdcliv3.Op) {
+	o.ops = append(o.ops, op)
+	o.keys = append(o.keys, key)
+	o.types = append(o.types, typ)
+}
+
+func (o *txnOps) Put(key string, value []byte) {
+	o.add(key, TxnOpPut, etcdcliv3.OpPut(o.ed.keyPath(key), string(value)))
+}
+
+func (o *txnOps) Delete(key string) {
+	o.add(key, TxnOpDelete, etcdcliv3.OpDelete(o.ed.keyPath(key)))
+}
+
+func (o *txnOps) Get(key string) {
+	o.add(key, TxnOpGet, etcdcliv3.OpGet(o.ed.keyPath(key)))
 }
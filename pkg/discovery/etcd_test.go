@@ -28,6 +28,7 @@ import (
 	"go.etcd.io/etcd/server/v3/embed"
 
 	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/utils"
 )
 
 type ETCDMockCluster struct {
@@ -369,10 +370,79 @@ func (s *EtcdClusterTestSuite) TestTransaction() {
 
 	s.NotNil(TxnErr(nil, fmt.Errorf("err")))
 
+	s.Nil(ed.Put(context.TODO(), "test", []byte("v1")))
+	txn = ed.NewTransaction()
+	txn.KeyMissing("new")
+	txn.VersionCmp("test", "=", 1)
+	txn.CreateRevisionCmp("test", ">", 0)
+	txn.ValueCmp("test", "=", []byte("v1"))
+	txn.Put("new", []byte("n1"))
+	txn.Get("test")
+	txn.Else().Get("new")
+	result, err := ed.Txn(context.TODO(), txn)
+	s.Nil(err)
+	s.True(result.Succeeded)
+	s.Len(result.Responses, 2)
+	value, found := result.Get("test")
+	s.True(found)
+	s.Equal("v1", string(value))
+	s.Greater(result.Responses[1].Rev, int64(0))
+
+	txn = ed.NewTransaction()
+	txn.ValueCmp("missing", "!=", []byte("v1"))
+	txn.Delete("test")
+	txn.Else().Delete("new")
+	txn.Else().Get("new")
+	result, err = ed.Txn(context.TODO(), txn)
+	s.Nil(err)
+	s.False(result.Succeeded)
+	s.Equal(TxnOpDelete, result.Responses[0].Type)
+	s.True(result.Responses[0].Found)
+	_, found = result.Get("new")
+	s.False(found)
+
+	ok, err := ed.CompareAndSwap(context.TODO(), "test", []byte("v1"), []byte("v2"))
+	s.Nil(err)
+	s.True(ok)
+	ok, err = ed.CompareAndSwap(context.TODO(), "test", []byte("v1"), []byte("v3"))
+	s.Nil(err)
+	s.False(ok)
+	ok, err = ed.CompareAndSwap(context.TODO(), "cas", nil, []byte("v1"))
+	s.Nil(err)
+	s.True(ok)
+	_, err = ed.Txn(context.TODO(), &memoryTransaction{})
+	s.ErrorIs(err, ErrTxnConvert)
+
 	err3 := ed.Close()
 	s.Nil(err3)
 }
 
+func (s *EtcdClusterTestSuite) TestIsolateOwner() {
+	cfg := config.Discovery{
+		Namespace:      "/app",
+		Endpoints:      s.Cluster.Endpoints,
+		IsolateOwner:   true,
+		RequestTimeout: utils.Duration(time.Second),
+	}
+	ed, err := newEtedDiscovery(cfg, "owner1")
+	s.Nil(err)
+	defer ed.Close()
+
+	s.Nil(ed.Put(context.TODO(), "../../other/key", []byte("v1")))
+	resp, err := ed.(*etcdDiscovery).client.Get(context.TODO(), "/app/owner1/other/key")
+	s.Nil(err)
+	s.Len(resp.Kvs, 1)
+
+	kvs, err := ed.List(context.TODO(), "/other")
+	s.Nil(err)
+	s.Equal([]KeyValue{{Key: "/other/key", Value: []byte("v1")}}, kvs)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = ed.Get(ctx, "/other/key")
+	s.NotNil(err)
+}
+
 func (s *EtcdClusterTestSuite) TestBatch() {
 	ed, err := newEtedDiscovery(config.Discovery{
 		Namespace: "/test/batch",
@@ -0,0 +1,227 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+const defaultFileRefreshInterval = time.Second // 默认文件变更检查周期1s
+
+func init() {
+	Register(config.DISCOVERYFILE, newFileDiscovery)
+}
+
+var (
+	fileStoresMu sync.Mutex
+	fileStores   = make(map[string]*fileStore)
+)
+
+// fileDiscovery 基于json文件的discovery, Endpoints[0]为文件路径.
+// 没有lease的key持久化到文件中, 文件被外部修改后会重新加载并通知watcher;
+// lease, watch和transaction在进程内实现, 与memoryDiscovery一致.
+type fileDiscovery struct {
+	*memoryDiscovery
+	fs *fileStore
+}
+
+func newFileDiscovery(cfg config.Discovery, owner string) (Discovery, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("file discovery needs a file path endpoint")
+	}
+	log := logger.GetLogger(owner, "FILE")
+	fs, err := openFileStore(strings.TrimPrefix(cfg.Endpoints[0], "file://"), log)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("new file discovery successfully", logger.String("path", fs.path))
+	return &fileDiscovery{
+		memoryDiscovery: newMemoryDiscoveryWithStore(cfg, owner, fs.store, log),
+		fs:              fs,
+	}, nil
+}
+
+func (fd *fileDiscovery) Close() error {
+	fd.fs.release()
+	return nil
+}
+
+// fileStore 同一文件在进程内共享的memoryStore
+type fileStore struct {
+	path   string
+	store  *memoryStore
+	logger *logger.Logger
+
+	refs   int // 受fileStoresMu保护
+	doneCh chan struct{}
+
+	mu   sync.Mutex
+	last []byte // 最近一次读取或写入的文件内容
+}
+
+func openFileStore(path string, log *logger.Logger) (*fileStore, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fileStoresMu.Lock()
+	defer fileStoresMu.Unlock()
+	if fs, ok := fileStores[path]; ok {
+		fs.refs++
+		return fs, nil
+	}
+
+	fs := &fileStore{
+		path:   path,
+		store:  newMemoryStore(),
+		logger: log,
+		refs:   1,
+		doneCh: make(chan struct{}),
+	}
+	if err := fs.reload(); err != nil {
+		return nil, err
+	}
+	fs.store.onChange = fs.save
+	fileStores[path] = fs
+	go fs.refreshLoop()
+	return fs, nil
+}
+
+func (fs *fileStore) release() {
+	fileStoresMu.Lock()
+	defer fileStoresMu.Unlock()
+	if fs.refs == 0 {
+		return
+	}
+	fs.refs--
+	if fs.refs == 0 {
+		delete(fileStores, fs.path)
+		close(fs.doneCh)
+	}
+}
+
+func (fs *fileStore) refreshLoop() {
+	ticker := time.NewTicker(defaultFileRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fs.doneCh:
+			return
+		case <-ticker.C:
+			if err := fs.reload(); err != nil {
+				fs.logger.Error("reload discovery file error", logger.Error(err), logger.String("path", fs.path))
+			}
+		}
+	}
+}
+
+// reload 将文件内容同步到memoryStore, 文件不存在时创建空文件.
+// 读取, 比较和写入期间持有memoryStore的锁, 与进程内的修改及其落盘串行执行,
+// 避免用旧的文件内容覆盖刚写入的数据
+func (fs *fileStore) reload() error {
+	fs.store.mu.Lock()
+	defer fs.store.mu.Unlock()
+
+	data, err := os.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return fs.write(map[string]string{})
+	}
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	unchanged := bytes.Equal(data, fs.last)
+	fs.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	kvs := make(map[string]string)
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &kvs); err != nil {
+			return fmt.Errorf("decode discovery file %s error: %w", fs.path, err)
+		}
+	}
+	fs.mu.Lock()
+	fs.last = data
+	fs.mu.Unlock()
+
+	// 以文件为准: 删除文件中不存在的持久化key, 写入新增或变化的key
+	var ops []memoryOp
+	for _, kv := range fs.store.listLocked("", true) {
+		if _, ok := kvs[kv.key]; !ok && kv.lease == 0 {
+			ops = append(ops, memoryOp{key: kv.key, delete: true})
+		}
+	}
+	for key, value := range kvs {
+		if kv, ok := fs.store.kvs[key]; !ok || kv.lease != 0 || string(kv.value) != value {
+			ops = append(ops, memoryOp{key: key, value: []byte(value)})
+		}
+	}
+	if len(ops) > 0 {
+		fs.store.commitLocked(nil, ops, nil)
+	}
+	return nil
+}
+
+// save 在memoryStore修改后持久化没有lease的key
+func (fs *fileStore) save(kvs map[string]*memoryKeyValue) {
+	persistent := make(map[string]string)
+	for key, kv := range kvs {
+		if kv.lease == 0 {
+			persistent[key] = string(kv.value)
+		}
+	}
+	if err := fs.write(persistent); err != nil {
+		fs.logger.Error("write discovery file error", logger.Error(err), logger.String("path", fs.path))
+	}
+}
+
+// write 先写临时文件再rename, 保证文件内容完整
+func (fs *fileStore) write(kvs map[string]string) error {
+	data, err := json.MarshalIndent(kvs, "", "  ")
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if bytes.Equal(data, fs.last) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(fs.path), 0o755); err != nil {
+		return err
+	}
+	tmp := fs.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fs.path); err != nil {
+		return err
+	}
+	fs.last = data
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+)
+
+func TestFileDiscovery(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "discovery.json")
+	cfg := config.Discovery{Type: config.DISCOVERYFILE, Endpoints: []string{"file://" + path}}
+	factory := NewDiscoveryFactory("nobody")
+
+	_, err := factory.CreateDiscovery(config.Discovery{Type: config.DISCOVERYFILE})
+	assert.NotNil(err)
+
+	ds, err := factory.CreateDiscovery(cfg)
+	assert.Nil(err)
+	assert.Nil(ds.Put(ctx, "/test/key1", []byte("dongjiang")))
+	_, err = ds.Heartbeat(ctx, "/test/node", []byte("node"), 1)
+	assert.Nil(err)
+
+	// 只持久化没有lease的key
+	data, err := os.ReadFile(path)
+	assert.Nil(err)
+	kvs := make(map[string]string)
+	assert.Nil(json.Unmarshal(data, &kvs))
+	assert.Equal(map[string]string{"/test/key1": "dongjiang"}, kvs)
+
+	ch := ds.WatchPrefix(ctx, "/test", true)
+	evt := <-ch
+	assert.Equal(EventTypeAll, evt.Type)
+	assert.Len(evt.KeyValues, 2)
+
+	// 外部修改文件
+	data, _ = json.Marshal(map[string]string{"/test/key2": "kubeservice"})
+	assert.Nil(os.WriteFile(path, data, 0o644))
+	events := map[EventType]string{}
+	for len(events) < 2 {
+		select {
+		case evt = <-ch:
+			events[evt.Type] = evt.KeyValues[0].Key
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event after the file changed")
+		}
+	}
+	assert.Equal(map[EventType]string{EventTypeModify: "/test/key2", EventTypeDelete: "/test/key1"}, events)
+	val, err := ds.Get(ctx, "/test/node")
+	assert.Nil(err)
+	assert.Equal("node", string(val))
+	assert.Nil(ds.Close())
+
+	// 重新打开文件
+	ds, err = factory.CreateDiscovery(cfg)
+	assert.Nil(err)
+	defer ds.Close()
+	list, err := ds.List(ctx, "/test")
+	assert.Nil(err)
+	assert.Equal([]KeyValue{{Key: "/test/key2", Value: []byte("kubeservice")}}, list)
+}
+
+func TestFileDiscoveryReloadRace(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "discovery.json")
+	ds, err := NewDiscoveryFactory("nobody").CreateDiscovery(config.Discovery{Type: config.DISCOVERYFILE, Endpoints: []string{path}})
+	assert.Nil(err)
+	defer ds.Close()
+	fs := ds.(*fileDiscovery).fs
+
+	// 并发reload不能用旧的文件内容覆盖进程内刚写入的key
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				assert.Nil(fs.reload())
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		assert.Nil(ds.Put(ctx, fmt.Sprintf("/race/%d", i), []byte("v")))
+	}
+	close(done)
+	<-stopped
+
+	for i := 0; i < 200; i++ {
+		val, err := ds.Get(ctx, fmt.Sprintf("/race/%d", i))
+		assert.Nil(err, i)
+		assert.Equal("v", string(val))
+	}
+}
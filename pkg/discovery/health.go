@@ -0,0 +1,412 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
+	"github.com/kubeservice-stack/common/pkg/metrics"
+)
+
+const (
+	defaultCheckInterval = 10 * time.Second // 默认健康检查周期10s
+	defaultCheckTimeout  = 3 * time.Second  // 默认单次检查超时3s
+)
+
+type HealthStatus string
+
+// 健康状态
+const (
+	HealthPassing  HealthStatus = "passing"
+	HealthCritical HealthStatus = "critical"
+)
+
+// HealthChecker 健康检查探针, 返回error表示不健康
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// HealthCheckFunc 函数探针
+type HealthCheckFunc func(ctx context.Context) error
+
+func (f HealthCheckFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HTTPCheck GET url, 返回2xx或3xx为健康
+func HTTPCheck(url string) HealthChecker {
+	return HealthCheckFunc(func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("http check %s returns %s", url, resp.Status)
+		}
+		return nil
+	})
+}
+
+// TCPCheck 能建立tcp连接为健康
+func TCPCheck(addr string) HealthChecker {
+	return HealthCheckFunc(func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// HealthRecord 带健康状态注册的value
+type HealthRecord struct {
+	Status HealthStatus `json:"status"`
+	Output string       `json:"output,omitempty"` // 失败检查的错误信息
+	Value  []byte       `json:"value,omitempty"`  // 注册的原始value
+	Since  time.Time    `json:"since"`            // 进入当前状态的时间
+}
+
+// ParseHealthRecord 解码HealthHeartbeat注册的value
+func ParseHealthRecord(data []byte) (*HealthRecord, error) {
+	record := &HealthRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("decode health record error: %w", err)
+	}
+	return record, nil
+}
+
+type namedCheck struct {
+	name    string
+	checker HealthChecker
+}
+
+type HealthOption func(h *HealthHeartbeat)
+
+// WithHealthCheck 增加名为name的检查, 所有检查通过才是passing
+func WithHealthCheck(name string, checker HealthChecker) HealthOption {
+	return func(h *HealthHeartbeat) {
+		h.checks = append(h.checks, namedCheck{name: name, checker: checker})
+	}
+}
+
+// WithCheckInterval 健康检查周期. Defaults to 10s.
+func WithCheckInterval(interval time.Duration) HealthOption {
+	return func(h *HealthHeartbeat) {
+		h.interval = interval
+	}
+}
+
+// WithCheckTimeout 单次检查超时时间. Defaults to 3s.
+func WithCheckTimeout(timeout time.Duration) HealthOption {
+	return func(h *HealthHeartbeat) {
+		h.timeout = timeout
+	}
+}
+
+// WithGracePeriod 持续critical超过grace后删除注册的key, 恢复passing后重新注册. Defaults to 0, 只上报critical不注销.
+func WithGracePeriod(grace time.Duration) HealthOption {
+	return func(h *HealthHeartbeat) {
+		h.grace = grace
+	}
+}
+
+// WithHealthScope 健康检查与注册metrics的scope, metrics按注册的key打tag
+func WithHealthScope(scope tally.Scope) HealthOption {
+	return func(h *HealthHeartbeat) {
+		h.scope = scope
+	}
+}
+
+// HealthHeartbeat 在Discovery.Heartbeat上周期执行健康检查, 以HealthRecord(json)作为注册的value.
+// 状态变化时重新Heartbeat写入新的value, lease丢失时自动重新注册.
+type HealthHeartbeat struct {
+	ds       Discovery
+	key      string
+	value    []byte
+	ttl      int64
+	checks   []namedCheck
+	interval time.Duration
+	timeout  time.Duration
+	grace    time.Duration
+	scope    tally.Scope
+	metrics  *healthMetrics
+	logger   *logger.Logger
+
+	mu         sync.Mutex
+	record     HealthRecord
+	listeners  []func(HealthRecord)
+	registered bool
+	started    bool
+	stopHB     context.CancelFunc // 停止当前heartbeat
+	lost       <-chan Closed      // 当前heartbeat结束
+	stop       context.CancelFunc
+	done       chan struct{}
+}
+
+func NewHealthHeartbeat(ds Discovery, key string, value []byte, ttl int64, opts ...HealthOption) *HealthHeartbeat {
+	h := &HealthHeartbeat{
+		ds:       ds,
+		key:      key,
+		value:    value,
+		ttl:      ttl,
+		interval: defaultCheckInterval,
+		timeout:  defaultCheckTimeout,
+		logger:   logger.GetLogger("pkg/common/discovery", "Health"),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.scope == nil {
+		h.scope = metrics.DefaultTallyScope.Scope
+	}
+	h.metrics = newHealthMetrics(h.scope, key)
+	return h
+}
+
+// Start 检查一次并注册, 之后在后台周期检查, 直到ctx结束或Stop后删除注册的key
+func (h *HealthHeartbeat) Start(ctx context.Context) error {
+	h.mu.Lock()
+	if h.started {
+		h.mu.Unlock()
+		return fmt.Errorf("health heartbeat on key[%s] is already started", h.key)
+	}
+	h.started = true
+	loopCtx, stop := context.WithCancel(ctx)
+	h.stop, h.done = stop, make(chan struct{})
+	h.mu.Unlock()
+
+	err := h.check(loopCtx)
+	go h.loop(loopCtx)
+	return err
+}
+
+// Stop 停止健康检查并删除注册的key
+func (h *HealthHeartbeat) Stop() {
+	h.mu.Lock()
+	stop, done := h.stop, h.done
+	h.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	stop()
+	<-done
+}
+
+// Status 最近一次检查的结果
+func (h *HealthHeartbeat) Status() HealthRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.record
+}
+
+// Registered key当前是否注册在discovery中
+func (h *HealthHeartbeat) Registered() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.registered
+}
+
+// OnStatusChange 健康状态变化后回调
+func (h *HealthHeartbeat) OnStatusChange(fn func(HealthRecord)) {
+	h.mu.Lock()
+	h.listeners = append(h.listeners, fn)
+	h.mu.Unlock()
+}
+
+func (h *HealthHeartbeat) loop(ctx context.Context) {
+	defer close(h.done)
+	defer h.deregister()
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.mu.Lock()
+		lost := h.lost
+		h.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = h.check(ctx)
+		case <-lost:
+			if ctx.Err() != nil {
+				return
+			}
+			h.metrics.leaseLost.Inc(1)
+			h.logger.Error("health heartbeat lease lost, register again", logger.String("key", h.key))
+			h.mu.Lock()
+			h.registered, h.lost = false, nil
+			h.mu.Unlock()
+			_ = h.check(ctx)
+		}
+	}
+}
+
+// check 执行所有检查, 根据结果更新注册的value或注销
+func (h *HealthHeartbeat) check(ctx context.Context) error {
+	status, output := HealthPassing, h.probe(ctx)
+	if output != "" {
+		status = HealthCritical
+	}
+	now := time.Now()
+
+	h.mu.Lock()
+	changed := h.record.Status != status || h.record.Output != output
+	if h.record.Status != status {
+		h.record.Since = now
+	}
+	h.record.Status, h.record.Output, h.record.Value = status, output, h.value
+	record, registered := h.record, h.registered
+	listeners := h.listeners
+	h.mu.Unlock()
+
+	if status == HealthPassing {
+		h.metrics.passing.Update(1)
+	} else {
+		h.metrics.passing.Update(0)
+	}
+	if changed {
+		for _, fn := range listeners {
+			fn(record)
+		}
+	}
+
+	if status == HealthCritical && h.grace > 0 && now.Sub(record.Since) >= h.grace {
+		if registered {
+			h.logger.Error("health check failed longer than grace period, deregister",
+				logger.String("key", h.key), logger.String("output", output))
+			h.deregister()
+		}
+		return nil
+	}
+	if changed || !registered {
+		return h.register(ctx, record)
+	}
+	return nil
+}
+
+func (h *HealthHeartbeat) probe(ctx context.Context) string {
+	var failures []string
+	for _, c := range h.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		err := c.checker.Check(checkCtx)
+		cancel()
+		h.metrics.checks.Inc(1)
+		if err != nil {
+			h.metrics.checkFailures.Inc(1)
+			failures = append(failures, c.name+": "+err.Error())
+		}
+	}
+	return strings.Join(failures, "; ")
+}
+
+// register 结束当前heartbeat后以record重新Heartbeat
+func (h *HealthHeartbeat) register(ctx context.Context, record HealthRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	h.stopHeartbeat()
+
+	hbCtx, stopHB := context.WithCancel(ctx)
+	lost, err := h.ds.Heartbeat(hbCtx, h.key, data, h.ttl)
+	if err != nil {
+		stopHB()
+		h.metrics.registerFailures.Inc(1)
+		h.logger.Error("health heartbeat register error", logger.Error(err), logger.String("key", h.key))
+		return err
+	}
+	h.metrics.registrations.Inc(1)
+	h.mu.Lock()
+	h.stopHB, h.lost, h.registered = stopHB, lost, true
+	h.mu.Unlock()
+	return nil
+}
+
+// stopHeartbeat 结束当前heartbeat并等待keepalive退出
+func (h *HealthHeartbeat) stopHeartbeat() {
+	h.mu.Lock()
+	stopHB, lost := h.stopHB, h.lost
+	h.stopHB, h.lost = nil, nil
+	h.mu.Unlock()
+	if stopHB == nil {
+		return
+	}
+	stopHB()
+	if lost != nil {
+		<-lost
+	}
+}
+
+func (h *HealthHeartbeat) deregister() {
+	h.stopHeartbeat()
+	h.mu.Lock()
+	registered := h.registered
+	h.registered = false
+	h.mu.Unlock()
+	if !registered {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	if err := h.ds.Delete(ctx, h.key); err != nil && !errors.Is(err, ErrNotExist) {
+		h.logger.Error("health heartbeat deregister error", logger.Error(err), logger.String("key", h.key))
+		return
+	}
+	h.metrics.deregistrations.Inc(1)
+}
+
+// healthMetrics 健康检查与注册统计
+type healthMetrics struct {
+	checks           tally.Counter
+	checkFailures    tally.Counter
+	registrations    tally.Counter
+	registerFailures tally.Counter
+	deregistrations  tally.Counter
+	leaseLost        tally.Counter
+	passing          tally.Gauge
+}
+
+func newHealthMetrics(scope tally.Scope, key string) *healthMetrics {
+	scope = scope.SubScope("discovery_health").Tagged(map[string]string{"key": key})
+	return &healthMetrics{
+		checks:           scope.Counter("checks"),
+		checkFailures:    scope.Counter("check_failures"),
+		registrations:    scope.Counter("registrations"),
+		registerFailures: scope.Counter("register_failures"),
+		deregistrations:  scope.Counter("deregistrations"),
+		leaseLost:        scope.Counter("lease_lost"),
+		passing:          scope.Gauge("passing"),
+	}
+}
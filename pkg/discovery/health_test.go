@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+)
+
+func TestHealthCheckers(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	assert.Nil(HTTPCheck(server.URL + "/health").Check(ctx))
+	assert.NotNil(HTTPCheck(server.URL + "/down").Check(ctx))
+	assert.NotNil(HTTPCheck("://bad").Check(ctx))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	addr := ln.Addr().String()
+	assert.Nil(TCPCheck(addr).Check(ctx))
+	ln.Close()
+	assert.NotNil(TCPCheck(addr).Check(ctx))
+}
+
+func TestHealthHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestMemoryDiscovery(t, config.Discovery{})
+	ctx := context.TODO()
+	scope := tally.NewTestScope("", nil)
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+	h := NewHealthHeartbeat(ds, "/health/node1", []byte("10.0.0.1:80"), 1,
+		WithHealthCheck("func", HealthCheckFunc(func(ctx context.Context) error {
+			if healthy.Load() {
+				return nil
+			}
+			return errors.New("unhealthy")
+		})),
+		WithCheckInterval(10*time.Millisecond),
+		WithCheckTimeout(time.Second),
+		WithGracePeriod(100*time.Millisecond),
+		WithHealthScope(scope),
+	)
+	statuses := make(chan HealthStatus, 10)
+	h.OnStatusChange(func(record HealthRecord) { statuses <- record.Status })
+
+	assert.Nil(h.Start(ctx))
+	assert.NotNil(h.Start(ctx))
+	assert.Equal(HealthPassing, <-statuses)
+	assert.True(h.Registered())
+	data, err := ds.Get(ctx, "/health/node1")
+	assert.Nil(err)
+	record, err := ParseHealthRecord(data)
+	assert.Nil(err)
+	assert.Equal(HealthPassing, record.Status)
+	assert.Equal("10.0.0.1:80", string(record.Value))
+
+	// 失败后先上报critical, 超过grace后注销
+	healthy.Store(false)
+	assert.Equal(HealthCritical, <-statuses)
+	assert.Eventually(func() bool {
+		data, err := ds.Get(ctx, "/health/node1")
+		if err != nil {
+			return false
+		}
+		record, err := ParseHealthRecord(data)
+		return err == nil && record.Status == HealthCritical && record.Output == "func: unhealthy"
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(func() bool {
+		_, err := ds.Get(ctx, "/health/node1")
+		return errors.Is(err, ErrNotExist) && !h.Registered()
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(HealthCritical, h.Status().Status)
+
+	// 恢复后重新注册
+	healthy.Store(true)
+	assert.Equal(HealthPassing, <-statuses)
+	assert.Eventually(func() bool {
+		_, err := ds.Get(ctx, "/health/node1")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	h.Stop()
+	h.Stop()
+	_, err = ds.Get(ctx, "/health/node1")
+	assert.ErrorIs(err, ErrNotExist)
+
+	snapshot := scope.Snapshot()
+	assert.Equal(int64(2), snapshot.Counters()["discovery_health.deregistrations+key=/health/node1"].Value())
+	assert.Equal(int64(3), snapshot.Counters()["discovery_health.registrations+key=/health/node1"].Value())
+	assert.Greater(snapshot.Counters()["discovery_health.check_failures+key=/health/node1"].Value(), int64(0))
+	assert.Equal(float64(1), snapshot.Gauges()["discovery_health.passing+key=/health/node1"].Value())
+
+	_, err = ParseHealthRecord([]byte("{"))
+	assert.NotNil(err)
+}
+
+func TestHealthHeartbeat_LeaseLost(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestMemoryDiscovery(t, config.Discovery{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := NewHealthHeartbeat(ds, "/health/node2", nil, 1, WithCheckInterval(time.Hour))
+	assert.Nil(h.Start(ctx))
+	md := ds.(*memoryDiscovery)
+	kv, ok := md.store.get(md.keyPath("/health/node2"))
+	assert.True(ok)
+	md.store.revoke(kv.lease)
+
+	assert.Eventually(func() bool {
+		kv, ok := md.store.get(md.keyPath("/health/node2"))
+		return ok && kv.lease != 0 && h.Registered()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	h.Stop()
+	_, err := ds.Get(context.TODO(), "/health/node2")
+	assert.ErrorIs(err, ErrNotExist)
+}
+
+func TestHealthHeartbeat_MetricsPerKey(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestMemoryDiscovery(t, config.Discovery{})
+	ctx := context.TODO()
+	scope := tally.NewTestScope("", nil)
+
+	failing := HealthCheckFunc(func(ctx context.Context) error { return errors.New("unhealthy") })
+	h1 := NewHealthHeartbeat(ds, "/health/a", nil, 1, WithCheckInterval(time.Hour), WithHealthScope(scope))
+	h2 := NewHealthHeartbeat(ds, "/health/b", nil, 1, WithCheckInterval(time.Hour), WithHealthScope(scope),
+		WithHealthCheck("func", failing))
+	assert.Nil(h1.Start(ctx))
+	assert.Nil(h2.Start(ctx))
+	defer h1.Stop()
+	defer h2.Stop()
+
+	// 每个heartbeat的状态单独上报, 不会互相覆盖
+	gauges := scope.Snapshot().Gauges()
+	assert.Equal(float64(1), gauges["discovery_health.passing+key=/health/a"].Value())
+	assert.Equal(float64(0), gauges["discovery_health.passing+key=/health/b"].Value())
+}
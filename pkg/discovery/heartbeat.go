@@ -21,14 +21,34 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/uber-go/tally"
+
 	"github.com/kubeservice-stack/common/pkg/logger"
+	"github.com/kubeservice-stack/common/pkg/metrics"
 
 	etcd "go.etcd.io/etcd/client/v3"
 )
 
 const defaultTTL = 10 // 默认heartbeat 时间间隔10s
 
-var errKeepaliveStopped = fmt.Errorf("heartbeat keepalive stopped")
+var (
+	errKeepaliveStopped     = fmt.Errorf("heartbeat keepalive stopped")
+	defaultHeartbeatMetrics = newHeartbeatMetrics(metrics.DefaultTallyScope.Scope)
+)
+
+// heartbeatMetrics lease续约统计
+type heartbeatMetrics struct {
+	renewals tally.Counter
+	failures tally.Counter
+}
+
+func newHeartbeatMetrics(scope tally.Scope) *heartbeatMetrics {
+	scope = scope.SubScope("discovery_heartbeat")
+	return &heartbeatMetrics{
+		renewals: scope.Counter("renewals"),
+		failures: scope.Counter("failures"),
+	}
+}
 
 // etcd的heartbeat, 在后台goroutine keepalive执行
 type heartbeat struct {
@@ -38,9 +58,11 @@ type heartbeat struct {
 
 	keepaliveCh <-chan *etcd.LeaseKeepAliveResponse
 	isElect     bool
+	revision    int64 // 最近一次写入成功的事务revision
 
-	ttl    int64
-	logger *logger.Logger
+	ttl     int64
+	logger  *logger.Logger
+	metrics *heartbeatMetrics
 }
 
 func newHeartbeat(client *etcd.Client, key string, value []byte, ttl int64, isElect bool) *heartbeat {
@@ -54,6 +76,7 @@ func newHeartbeat(client *etcd.Client, key string, value []byte, ttl int64, isEl
 		value:   value,
 		ttl:     ttl,
 		logger:  logger.GetLogger("pkg/common/discovery", "HeartBeat"),
+		metrics: defaultHeartbeatMetrics,
 	}
 }
 
@@ -61,6 +84,10 @@ func (h *heartbeat) withLogger(logger *logger.Logger) {
 	h.logger = logger
 }
 
+func (h *heartbeat) withMetrics(metrics *heartbeatMetrics) {
+	h.metrics = metrics
+}
+
 func (h *heartbeat) grantKeepAliveLease(ctx context.Context) (bool, error) {
 	resp, err := h.client.Grant(ctx, h.ttl)
 	if err != nil {
@@ -79,6 +106,7 @@ func (h *heartbeat) grantKeepAliveLease(ctx context.Context) (bool, error) {
 	}
 	response.Responses[0].GetResponse()
 	if response.Succeeded {
+		h.revision = response.Header.Revision
 		h.keepaliveCh, err = h.client.KeepAlive(ctx, resp.ID)
 	}
 	return response.Succeeded, err
@@ -91,6 +119,7 @@ func (h *heartbeat) keepAlive(ctx context.Context) {
 	)
 	for {
 		if err != nil {
+			h.metrics.failures.Inc(1)
 			h.logger.Error("do heartbeat keepalive error, retry.", logger.Error(err), logger.String("key", h.key))
 			time.Sleep(gap)
 			if h.isElect {
@@ -122,6 +151,7 @@ func (h *heartbeat) handleAliveResp(ctx context.Context) error {
 		if aliveResp == nil {
 			return errKeepaliveStopped
 		}
+		h.metrics.renewals.Inc(1)
 	case <-ctx.Done():
 		return errKeepaliveStopped
 	}
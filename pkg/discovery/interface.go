@@ -23,7 +23,10 @@ import (
 	"github.com/kubeservice-stack/common/pkg/config"
 )
 
-var ErrNotExist = fmt.Errorf("discovery is not exist")
+var (
+	ErrNotExist                = fmt.Errorf("discovery is not exist")
+	ErrDiscoveryTypeNotSupport = fmt.Errorf("discovery type not support")
+)
 
 type DiscoveryFactory interface {
 	CreateDiscovery(cfg config.Discovery) (Discovery, error)
@@ -41,10 +44,18 @@ type Discovery interface {
 	WatchPrefix(ctx context.Context, prefixKey string, fetchVal bool) WatchEventChan             // watch 前缀key
 	Batch(ctx context.Context, batch Batch) (bool, error)                                        // 批写入
 	NewTransaction() Transaction                                                                 // 新transaction
-	Commit(ctx context.Context, txn Transaction) error                                           // commit
+	Commit(ctx context.Context, txn Transaction) error                                           // commit, 比较不成立时返回ErrTxnFailed
+	Txn(ctx context.Context, txn Transaction) (*TxnResult, error)                                // 执行事务, 比较不成立时执行Else分支
+	CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error)               // value等于old时写入new, old为nil表示key不存在
 	Close() error                                                                                // close discovery
 }
 
+// RevisionElector 可选接口, 选举成功时在同一个事务中返回leader key的revision.
+// Election使用它作为fencing token, 避免Elect之后再读取key时key已属于其他leader
+type RevisionElector interface {
+	ElectRevision(ctx context.Context, key string, value []byte, ttl int64) (bool, int64, <-chan Closed, error)
+}
+
 type EventType int
 
 // Event类型
@@ -96,6 +107,21 @@ type Closed struct{}
 // Watch Event Chan
 type WatchEventChan <-chan *Event
 
+// Instance 根据配置创建discovery backend
+type Instance func(cfg config.Discovery, owner string) (Discovery, error)
+
+var adapters = make(map[config.DISCOVERYTYPE]Instance)
+
+func Register(name config.DISCOVERYTYPE, adapter Instance) {
+	if adapter == nil {
+		panic("discovery: Register adapter is nil")
+	}
+	if _, ok := adapters[name]; ok {
+		panic("discovery: Register called twice for adapter " + name)
+	}
+	adapters[name] = adapter
+}
+
 // default discovery factory
 type discoveryFactory struct {
 	owner string
@@ -107,11 +133,80 @@ func NewDiscoveryFactory(owner string) DiscoveryFactory {
 
 func (df *discoveryFactory) CreateDiscovery(cfg config.Discovery) (Discovery, error) {
 	// 默认etcd discovery
-	return newEtedDiscovery(cfg, df.owner)
+	if cfg.Type == "" {
+		cfg.Type = config.DISCOVERYETCD
+	}
+	adapter, ok := adapters[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDiscoveryTypeNotSupport, cfg.Type)
+	}
+	return adapter(cfg, df.owner)
 }
 
-type Transaction interface {
-	ModRevisionCmp(key, op string, v interface{})
+// TxnOps 事务分支中的操作, 按顺序执行
+type TxnOps interface {
 	Put(key string, value []byte)
 	Delete(key string)
+	Get(key string) // 读取结果在TxnResult.Responses中
+}
+
+// Transaction 比较op支持 =, !=, <, >; 所有比较成立时执行Then分支(Transaction上的操作), 否则执行Else分支
+type Transaction interface {
+	ModRevisionCmp(key, op string, v interface{})    // 比较key最后修改的revision, key不存在时为0
+	CreateRevisionCmp(key, op string, v interface{}) // 比较key创建时的revision, key不存在时为0
+	VersionCmp(key, op string, v interface{})        // 比较key的修改次数, key不存在时为0
+	ValueCmp(key, op string, value []byte)           // 比较key的value, key不存在时不成立
+	KeyMissing(key string)                           // key不存在
+	TxnOps
+	Else() TxnOps
+}
+
+type TxnOpType int
+
+// 事务操作类型
+const (
+	TxnOpPut TxnOpType = iota
+	TxnOpDelete
+	TxnOpGet
+)
+
+// TxnResponse 事务中一个操作的结果
+type TxnResponse struct {
+	Type  TxnOpType
+	Key   string
+	Value []byte // Get读取的value
+	Rev   int64  // Get读取的key最后修改的revision
+	Found bool   // Get时key是否存在, Delete时是否删除了key
+}
+
+// TxnResult 事务执行结果
+type TxnResult struct {
+	Succeeded bool          // 比较是否成立, 成立时执行了Then分支, 否则执行了Else分支
+	Responses []TxnResponse // 与执行分支中的操作一一对应
+}
+
+// Get 返回事务中读取的key的value
+func (r *TxnResult) Get(key string) ([]byte, bool) {
+	for _, resp := range r.Responses {
+		if resp.Type == TxnOpGet && resp.Key == key {
+			return resp.Value, resp.Found
+		}
+	}
+	return nil, false
+}
+
+// compareAndSwap 基于Transaction实现CompareAndSwap
+func compareAndSwap(ctx context.Context, ds Discovery, key string, old, new []byte) (bool, error) {
+	txn := ds.NewTransaction()
+	if old == nil {
+		txn.KeyMissing(key)
+	} else {
+		txn.ValueCmp(key, "=", old)
+	}
+	txn.Put(key, new)
+	result, err := ds.Txn(ctx, txn)
+	if err != nil {
+		return false, err
+	}
+	return result.Succeeded, nil
 }
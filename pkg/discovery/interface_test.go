@@ -38,6 +38,21 @@ func TestNewRepo(t *testing.T) {
 	assert.NotNil(ds)
 }
 
+func TestCreateDiscovery(t *testing.T) {
+	assert := assert.New(t)
+	factory := NewDiscoveryFactory("nobody")
+
+	ds, err := factory.CreateDiscovery(config.Discovery{Type: config.DISCOVERYMEMORY})
+	assert.Nil(err)
+	assert.IsType(&memoryDiscovery{}, ds)
+
+	_, err = factory.CreateDiscovery(config.Discovery{Type: "unregistered"})
+	assert.ErrorIs(err, ErrDiscoveryTypeNotSupport)
+
+	assert.Panics(func() { Register(config.DISCOVERYMEMORY, newMemoryDiscovery) })
+	assert.Panics(func() { Register("nil", nil) })
+}
+
 func TestEventType_String(t *testing.T) {
 	assert := assert.New(t)
 
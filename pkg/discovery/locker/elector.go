@@ -0,0 +1,32 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locker
+
+import (
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/schedule"
+)
+
+var _ schedule.Elector = (*discovery.Election)(nil)
+
+// NewElector 基于Discovery.Elect的调度器选主, 只有leader执行任务:
+//
+//	s := schedule.NewScheduler(schedule.WithElector(locker.NewElector(ds, "/schedule/leader", []byte(hostname), 10)),
+//		schedule.WithJobStore(store))
+func NewElector(ds discovery.Discovery, key string, value []byte, ttl int64) schedule.Elector {
+	return discovery.NewElection(ds, key, value, ttl)
+}
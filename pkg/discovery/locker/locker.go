@@ -0,0 +1,102 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locker
+
+import (
+	"context"
+	"path"
+	"sync"
+
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/schedule"
+)
+
+var _ schedule.Locker = (*Locker)(nil)
+
+// Locker 按key管理分布式锁, 实现schedule.Locker:
+//
+//	schedule.SetLocker(locker.NewLocker(ds, "/locks/schedule"))
+type Locker struct {
+	ds     discovery.Discovery
+	prefix string
+	opts   []Option
+
+	mu      sync.Mutex
+	mutexes map[string]*lockEntry
+}
+
+// lockEntry pending为正在执行Lock的次数, 没有获得锁且没有其他Lock时删除
+type lockEntry struct {
+	m       *Mutex
+	pending int
+}
+
+// NewLocker 锁的key为 <prefix>/<key>
+func NewLocker(ds discovery.Discovery, prefix string, opts ...Option) *Locker {
+	return &Locker{
+		ds:      ds,
+		prefix:  prefix,
+		opts:    opts,
+		mutexes: make(map[string]*lockEntry),
+	}
+}
+
+func (l *Locker) entryLocked(key string) *lockEntry {
+	e, ok := l.mutexes[key]
+	if !ok {
+		e = &lockEntry{m: NewMutex(l.ds, path.Join(l.prefix, key), l.opts...)}
+		l.mutexes[key] = e
+	}
+	return e
+}
+
+// Lock 尝试获得key的锁, 已被其他节点持有时返回false, 不阻塞
+func (l *Locker) Lock(key string) (bool, error) {
+	l.mu.Lock()
+	e := l.entryLocked(key)
+	e.pending++
+	l.mu.Unlock()
+
+	ok, err := e.m.TryLockContext(context.Background())
+
+	l.mu.Lock()
+	e.pending--
+	if !ok && e.pending == 0 && !e.m.Held() && l.mutexes[key] == e {
+		delete(l.mutexes, key)
+	}
+	l.mu.Unlock()
+	return ok, err
+}
+
+// Unlock 释放key的锁
+func (l *Locker) Unlock(key string) error {
+	l.mu.Lock()
+	e, ok := l.mutexes[key]
+	delete(l.mutexes, key)
+	l.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return e.m.Unlock()
+}
+
+// Mutex 获得key对应的Mutex, 用于阻塞加锁或读取fencing token
+func (l *Locker) Mutex(key string) *Mutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.entryLocked(key).m
+}
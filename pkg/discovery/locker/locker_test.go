@@ -0,0 +1,271 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/schedule"
+)
+
+func newTestDiscovery(t *testing.T) discovery.Discovery {
+	ds, err := discovery.NewDiscoveryFactory("nobody").CreateDiscovery(config.Discovery{Type: config.DISCOVERYMEMORY})
+	assert.Nil(t, err)
+	return ds
+}
+
+func TestMutex(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestDiscovery(t)
+	m1 := NewMutex(ds, "/locks/job", WithTTL(1))
+	m2 := NewMutex(ds, "/locks/job", WithTTL(1))
+	assert.Equal("/locks/job", m1.Key())
+
+	assert.Nil(m1.Lock())
+	assert.True(m1.Held())
+	token1 := m1.Token()
+	assert.True(token1 > 0)
+	assert.False(m2.TryLock())
+	assert.Equal(int64(0), m2.Token())
+
+	// 超过ttl后仍自动续期
+	time.Sleep(1500 * time.Millisecond)
+	assert.False(m2.TryLock())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(m2.LockContext(ctx), context.DeadlineExceeded)
+
+	done := m1.Done()
+	locked := make(chan error, 1)
+	go func() { locked <- m2.Lock() }()
+	assert.Nil(m1.Unlock())
+	<-done
+	assert.False(m1.Held())
+	assert.Nil(<-locked)
+	assert.True(m2.Held())
+	// fencing token 单调递增
+	assert.True(m2.Token() > token1)
+	assert.Nil(m2.Unlock())
+	assert.Nil(m2.Unlock())
+	assert.True(m1.TryLock())
+	assert.Nil(m1.Unlock())
+}
+
+func TestMutex_exclusive(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestDiscovery(t)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		holders int
+		max     int
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := NewMutex(ds, "/locks/exclusive")
+			for j := 0; j < 3; j++ {
+				assert.Nil(m.Lock())
+				mu.Lock()
+				holders++
+				if holders > max {
+					max = holders
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				assert.Nil(m.Unlock())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(1, max)
+}
+
+func TestMutex_sameProcess(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestDiscovery(t)
+	m := NewMutex(ds, "/locks/local", WithTTL(1))
+
+	assert.Nil(m.Lock())
+	token := m.Token()
+	// 同一个Mutex被其他goroutine持有时TryLock失败, Lock阻塞而不是返回错误
+	tried := make(chan bool, 1)
+	go func() { tried <- m.TryLock() }()
+	assert.False(<-tried)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(m.LockContext(ctx), context.DeadlineExceeded)
+	assert.True(m.Held())
+	assert.Equal(token, m.Token())
+
+	locked := make(chan error, 1)
+	go func() { locked <- m.Lock() }()
+	select {
+	case <-locked:
+		assert.Fail("lock acquired while held by another goroutine")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Nil(m.Unlock())
+	assert.Nil(<-locked)
+	assert.True(m.Held())
+	assert.True(m.Token() > token)
+	assert.Nil(m.Unlock())
+	assert.False(m.Held())
+}
+
+func TestLocker_sameProcess(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestDiscovery(t)
+	l := NewLocker(ds, "/locks/schedule", WithTTL(1))
+
+	var (
+		wg       sync.WaitGroup
+		acquired int32
+	)
+	results := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := l.Lock("task")
+			assert.Nil(err)
+			results <- ok
+		}()
+	}
+	wg.Wait()
+	close(results)
+	for ok := range results {
+		if ok {
+			acquired++
+		}
+	}
+	assert.Equal(int32(1), acquired)
+	assert.Nil(l.Unlock("task"))
+	ok, err := l.Lock("task")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Nil(l.Unlock("task"))
+	assert.Len(l.mutexes, 0)
+}
+
+func TestLocker_contended(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestDiscovery(t)
+	l1 := NewLocker(ds, "/locks/schedule", WithTTL(1))
+	l2 := NewLocker(ds, "/locks/schedule", WithTTL(1))
+
+	ok, err := l1.Lock("task")
+	assert.Nil(err)
+	assert.True(ok)
+	// 没有获得锁时不保留key对应的Mutex
+	for i := 0; i < 10; i++ {
+		ok, err = l2.Lock("task")
+		assert.Nil(err)
+		assert.False(ok)
+	}
+	assert.Len(l2.mutexes, 0)
+	assert.Len(l1.mutexes, 1)
+	assert.Nil(l1.Unlock("task"))
+	assert.Len(l1.mutexes, 0)
+}
+
+func TestLocker(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestDiscovery(t)
+	l1 := NewLocker(ds, "/locks/schedule", WithTTL(1))
+	l2 := NewLocker(ds, "/locks/schedule", WithTTL(1))
+
+	ok, err := l1.Lock("task")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal("/locks/schedule/task", l1.Mutex("task").Key())
+	assert.True(l1.Mutex("task").Token() > 0)
+
+	ok, err = l2.Lock("task")
+	assert.Nil(err)
+	assert.False(ok)
+	ok, err = l2.Lock("other")
+	assert.Nil(err)
+	assert.True(ok)
+
+	assert.Nil(l1.Unlock("task"))
+	assert.Nil(l1.Unlock("unknown"))
+	ok, err = l2.Lock("task")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Nil(l2.Unlock("task"))
+	assert.Nil(l2.Unlock("other"))
+}
+
+func TestElector(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestDiscovery(t)
+	store := schedule.NewMemoryJobStore()
+
+	var mu sync.Mutex
+	runs := map[string]int{}
+	newReplica := func(name string) *schedule.Scheduler {
+		sched := schedule.NewScheduler(schedule.WithJobStore(store),
+			schedule.WithElector(NewElector(ds, "/schedule/leader", []byte(name), 1)))
+		assert.Nil(sched.Every(1).Second().Name("job").DoFunc(func(context.Context) error {
+			mu.Lock()
+			runs[name]++
+			mu.Unlock()
+			return nil
+		}))
+		return sched
+	}
+
+	s1, s2 := newReplica("s1"), newReplica("s2")
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	done1 := s1.StartContext(ctx1)
+	time.Sleep(200 * time.Millisecond)
+	done2 := s2.StartContext(ctx2)
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(s1.IsLeader())
+	assert.False(s2.IsLeader())
+
+	cancel1()
+	<-done1
+	time.Sleep(2500 * time.Millisecond)
+	assert.True(s2.IsLeader())
+	cancel2()
+	<-done2
+	s1.Wait()
+	s2.Wait()
+
+	val, err := ds.Get(context.Background(), "/schedule/leader")
+	assert.ErrorIs(err, discovery.ErrNotExist, string(val))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(runs["s1"] >= 1, runs)
+	assert.True(runs["s2"] >= 1, runs)
+}
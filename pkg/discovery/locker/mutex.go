@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package locker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/lock"
+)
+
+const defaultTTL = 10 // 默认lock lease 10s
+
+var (
+	_ lock.Locker = (*Mutex)(nil)
+
+	holderSeq uint64
+)
+
+type Option func(m *Mutex)
+
+// WithTTL lock lease时长(秒), 持有者异常退出后最多ttl后释放. Defaults to 10s.
+func WithTTL(ttl int64) Option {
+	return func(m *Mutex) {
+		m.ttl = ttl
+	}
+}
+
+// Mutex 基于Discovery lease的分布式互斥锁, 实现lock.Locker.
+// 持有期间后台自动续期lease; 进程内同样互斥, 已持有时Lock阻塞, TryLock返回false.
+type Mutex struct {
+	key      string
+	ttl      int64
+	holder   []byte
+	local    chan struct{} // 进程内互斥, 容量为1
+	election *discovery.Election
+}
+
+// NewMutex 在key上创建分布式锁, 不同进程使用相同key互斥
+func NewMutex(ds discovery.Discovery, key string, opts ...Option) *Mutex {
+	m := &Mutex{
+		key:    key,
+		ttl:    defaultTTL,
+		holder: newHolder(),
+		local:  make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.election = discovery.NewElection(ds, key, m.holder, m.ttl)
+	return m
+}
+
+// newHolder 生成全局唯一的持有者标识: hostname/pid/纳秒时间/序号
+func newHolder() []byte {
+	hostname, _ := os.Hostname()
+	return []byte(fmt.Sprintf("%s/%d/%d/%d", hostname, os.Getpid(), time.Now().UnixNano(), atomic.AddUint64(&holderSeq, 1)))
+}
+
+// Lock 阻塞直到获得锁
+func (m *Mutex) Lock() error {
+	return m.LockContext(context.Background())
+}
+
+// LockContext 阻塞直到获得锁或ctx结束
+func (m *Mutex) LockContext(ctx context.Context) error {
+	select {
+	case m.local <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := m.election.Campaign(ctx); err != nil {
+		<-m.local
+		return err
+	}
+	return nil
+}
+
+// TryLock 尝试获得锁, 不阻塞
+func (m *Mutex) TryLock() bool {
+	ok, err := m.TryLockContext(context.Background())
+	return err == nil && ok
+}
+
+// TryLockContext 尝试获得锁, 不等待其他持有者释放
+func (m *Mutex) TryLockContext(ctx context.Context) (bool, error) {
+	select {
+	case m.local <- struct{}{}:
+	default:
+		return false, nil
+	}
+	ok, err := m.election.TryCampaign(ctx)
+	if err != nil || !ok {
+		<-m.local
+	}
+	return ok, err
+}
+
+// Unlock 释放锁, 未持有时直接返回
+func (m *Mutex) Unlock() error {
+	return m.UnlockContext(context.Background())
+}
+
+func (m *Mutex) UnlockContext(ctx context.Context) error {
+	err := m.election.Resign(ctx)
+	// lease过期失去锁后也要释放进程内互斥
+	select {
+	case <-m.local:
+	default:
+	}
+	return err
+}
+
+// Token 获得锁时的fencing token, 每次获得锁单调递增; 未持有锁时为0.
+// 写共享资源时带上token, 资源方拒绝比已见过的token更小的请求, 避免lease过期后旧持有者的写入.
+func (m *Mutex) Token() int64 {
+	return m.election.Rev()
+}
+
+// Held 当前是否持有锁
+func (m *Mutex) Held() bool {
+	return m.election.IsLeader()
+}
+
+// Done 失去锁(lease过期)或Unlock后关闭
+func (m *Mutex) Done() <-chan struct{} {
+	return m.election.LeaderContext().Done()
+}
+
+// Key 锁对应的discovery key
+func (m *Mutex) Key() string {
+	return m.key
+}
@@ -0,0 +1,733 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+func init() {
+	Register(config.DISCOVERYMEMORY, newMemoryDiscovery)
+}
+
+var (
+	memoryStoresMu sync.Mutex
+	memoryStores   = make(map[string]*memoryStore)
+)
+
+// memoryDiscovery 进程内的discovery, 与etcd语义一致(revision, lease, watch, transaction), 主要用于单元测试.
+// Endpoints相同的memoryDiscovery共享同一份数据, 用于模拟多个节点; Endpoints为空时数据独享.
+type memoryDiscovery struct {
+	namespace string
+	isolated  bool
+	prefix    string
+	store     *memoryStore
+	logger    *logger.Logger
+	metrics   *heartbeatMetrics
+}
+
+func newMemoryDiscovery(cfg config.Discovery, owner string) (Discovery, error) {
+	return newMemoryDiscoveryWithStore(cfg, owner, sharedMemoryStore(cfg.Endpoints), logger.GetLogger(owner, "MEMORY")), nil
+}
+
+func newMemoryDiscoveryWithStore(cfg config.Discovery, owner string, store *memoryStore, log *logger.Logger) *memoryDiscovery {
+	return &memoryDiscovery{
+		namespace: ownerNamespace(cfg, owner),
+		isolated:  cfg.IsolateOwner,
+		prefix:    cfg.Prefix,
+		store:     store,
+		logger:    log,
+		metrics:   defaultHeartbeatMetrics,
+	}
+}
+
+func sharedMemoryStore(endpoints []string) *memoryStore {
+	if len(endpoints) == 0 {
+		return newMemoryStore()
+	}
+	name := strings.Join(endpoints, ",")
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	store, ok := memoryStores[name]
+	if !ok {
+		store = newMemoryStore()
+		memoryStores[name] = store
+	}
+	return store
+}
+
+// keyPath return new key path with prefix and namespace
+func (md *memoryDiscovery) keyPath(key string) string {
+	if md.isolated {
+		key = isolateKey(key)
+	}
+	if len(md.namespace) > 0 {
+		key = filepath.Join(md.namespace, key)
+	}
+	return md.prefix + key
+}
+
+// parseKey parses the key, removes the prefix and namespace
+func (md *memoryDiscovery) parseKey(key string) string {
+	key = strings.TrimPrefix(key, md.prefix)
+	if len(md.namespace) == 0 {
+		return key
+	}
+	return strings.Replace(key, md.namespace, "", 1)
+}
+
+func (md *memoryDiscovery) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	kv, ok := md.store.get(md.keyPath(key))
+	if !ok {
+		return nil, ErrNotExist
+	}
+	if len(kv.value) == 0 {
+		return nil, fmt.Errorf("key[%s]'s value is empty", key)
+	}
+	return kv.value, nil
+}
+
+func (md *memoryDiscovery) List(ctx context.Context, prefix string) ([]KeyValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var result []KeyValue
+	for _, kv := range md.store.list(md.keyPath(prefix), true) {
+		if len(kv.value) > 0 {
+			result = append(result, KeyValue{Key: md.parseKey(kv.key), Value: kv.value})
+		}
+	}
+	return result, nil
+}
+
+func (md *memoryDiscovery) Put(ctx context.Context, key string, val []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	md.store.put(md.keyPath(key), val, 0)
+	return nil
+}
+
+func (md *memoryDiscovery) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	md.store.delete(md.keyPath(key))
+	return nil
+}
+
+func (md *memoryDiscovery) Heartbeat(ctx context.Context, key string, value []byte, ttl int64) (<-chan Closed, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ttl = leaseTTL(ttl)
+	lease := md.store.grant(time.Duration(ttl) * time.Second)
+	md.store.put(md.keyPath(key), value, lease)
+	return md.keepAlive(ctx, lease, ttl), nil
+}
+
+func (md *memoryDiscovery) Elect(ctx context.Context, key string, value []byte, ttl int64) (bool, <-chan Closed, error) {
+	success, _, lost, err := md.ElectRevision(ctx, key, value, ttl)
+	return success, lost, err
+}
+
+// ElectRevision 写入leader key并在同一事务中读取其revision
+func (md *memoryDiscovery) ElectRevision(ctx context.Context, key string, value []byte, ttl int64) (bool, int64, <-chan Closed, error) {
+	if err := ctx.Err(); err != nil {
+		return false, 0, nil, err
+	}
+	ttl = leaseTTL(ttl)
+	lease := md.store.grant(time.Duration(ttl) * time.Second)
+	path := md.keyPath(key)
+	success, responses := md.store.commit([]memoryCmp{keyMissing(path)},
+		[]memoryOp{{key: path, value: value, lease: lease}, {key: path, get: true}}, nil)
+	if !success {
+		md.store.revoke(lease)
+		return false, 0, nil, nil
+	}
+	return true, responses[1].Rev, md.keepAlive(ctx, lease, ttl), nil
+}
+
+// keepAlive 后台刷新lease, ctx结束后停止刷新, lease在ttl后过期
+func (md *memoryDiscovery) keepAlive(ctx context.Context, lease int64, ttl int64) <-chan Closed {
+	ch := make(chan Closed)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(time.Duration(ttl) * time.Second / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !md.store.keepAlive(lease) {
+					md.metrics.failures.Inc(1)
+					md.logger.Error("memory lease expired, stop keepalive", logger.Int64("lease", lease))
+					return
+				}
+				md.metrics.renewals.Inc(1)
+			}
+		}
+	}()
+	return ch
+}
+
+func (md *memoryDiscovery) Watch(ctx context.Context, key string, fetchVal bool) WatchEventChan {
+	return md.watch(ctx, md.keyPath(key), false)
+}
+
+func (md *memoryDiscovery) WatchPrefix(ctx context.Context, prefixKey string, fetchVal bool) WatchEventChan {
+	return md.watch(ctx, md.keyPath(prefixKey), true)
+}
+
+func (md *memoryDiscovery) watch(ctx context.Context, key string, prefix bool) WatchEventChan {
+	eventCh := make(chan *Event)
+	w, kvs := md.store.watch(key, prefix)
+	go func() {
+		defer close(eventCh)
+		defer md.store.unwatch(w)
+
+		evtAll := &Event{Type: EventTypeAll}
+		for _, kv := range kvs {
+			evtAll.KeyValues = append(evtAll.KeyValues, EventKeyValue{Key: md.parseKey(kv.key), Value: kv.value, Rev: kv.modRevision})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case eventCh <- evtAll:
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.notify:
+			}
+			for _, e := range w.pop() {
+				evt := &Event{
+					Type:      e.typ,
+					KeyValues: []EventKeyValue{{Key: md.parseKey(e.key), Value: e.value, Rev: e.revision}},
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case eventCh <- evt:
+				}
+			}
+		}
+	}()
+	return eventCh
+}
+
+func (md *memoryDiscovery) Batch(ctx context.Context, batch Batch) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	var ops []memoryOp
+	for _, kv := range batch.KVs {
+		ops = append(ops, memoryOp{key: md.keyPath(kv.Key), value: kv.Value})
+	}
+	return md.store.txn(nil, ops), nil
+}
+
+func (md *memoryDiscovery) NewTransaction() Transaction {
+	return &memoryTransaction{memoryTxnOps: memoryTxnOps{md: md}, els: &memoryTxnOps{md: md}}
+}
+
+func (md *memoryDiscovery) Commit(ctx context.Context, txn Transaction) error {
+	result, err := md.Txn(ctx, txn)
+	if err != nil {
+		return err
+	}
+	if !result.Succeeded {
+		return ErrTxnFailed
+	}
+	return nil
+}
+
+func (md *memoryDiscovery) Txn(ctx context.Context, txn Transaction) (*TxnResult, error) {
+	t, ok := txn.(*memoryTransaction)
+	if !ok {
+		return nil, ErrTxnConvert
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	succeeded, responses := md.store.commit(t.cmps, t.ops, t.els.ops)
+	for i := range responses {
+		responses[i].Key = md.parseKey(responses[i].Key)
+	}
+	return &TxnResult{Succeeded: succeeded, Responses: responses}, nil
+}
+
+func (md *memoryDiscovery) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	return compareAndSwap(ctx, md, key, old, new)
+}
+
+func (md *memoryDiscovery) Close() error {
+	return nil
+}
+
+func leaseTTL(ttl int64) int64 {
+	if ttl <= 0 {
+		return defaultTTL
+	}
+	return ttl
+}
+
+type memoryTransaction struct {
+	memoryTxnOps
+	cmps []memoryCmp
+	els  *memoryTxnOps
+}
+
+func (t *memoryTransaction) ModRevisionCmp(key, op string, v interface{}) {
+	t.revisionCmp(key, op, v, func(kv *memoryKeyValue) int64 { return kv.modRevision })
+}
+
+func (t *memoryTransaction) CreateRevisionCmp(key, op string, v interface{}) {
+	t.revisionCmp(key, op, v, func(kv *memoryKeyValue) int64 { return kv.createRevision })
+}
+
+func (t *memoryTransaction) VersionCmp(key, op string, v interface{}) {
+	t.revisionCmp(key, op, v, func(kv *memoryKeyValue) int64 { return kv.version })
+}
+
+// revisionCmp key不存在时field为0
+func (t *memoryTransaction) revisionCmp(key, op string, v interface{}, field func(kv *memoryKeyValue) int64) {
+	path := t.md.keyPath(key)
+	t.cmps = append(t.cmps, func(kvs map[string]*memoryKeyValue) bool {
+		var rev int64
+		if kv, ok := kvs[path]; ok {
+			rev = field(kv)
+		}
+		return compareRevision(rev, op, v)
+	})
+}
+
+func (t *memoryTransaction) ValueCmp(key, op string, value []byte) {
+	path := t.md.keyPath(key)
+	t.cmps = append(t.cmps, func(kvs map[string]*memoryKeyValue) bool {
+		kv, ok := kvs[path]
+		return ok && compareValue(kv.value, op, value)
+	})
+}
+
+func (t *memoryTransaction) KeyMissing(key string) {
+	t.cmps = append(t.cmps, keyMissing(t.md.keyPath(key)))
+}
+
+func (t *memoryTransaction) Else() TxnOps {
+	return t.els
+}
+
+// memoryTxnOps 事务一个分支中的操作
+type memoryTxnOps struct {
+	md  *memoryDiscovery
+	ops []memoryOp
+}
+
+func (o *memoryTxnOps) Put(key string, value []byte) {
+	o.ops = append(o.ops, memoryOp{key: o.md.keyPath(key), value: value})
+}
+
+func (o *memoryTxnOps) Delete(key string) {
+	o.ops = append(o.ops, memoryOp{key: o.md.keyPath(key), delete: true})
+}
+
+func (o *memoryTxnOps) Get(key string) {
+	o.ops = append(o.ops, memoryOp{key: o.md.keyPath(key), get: true})
+}
+
+// compareValue 按字节序比较value, 支持 =, !=, <, >
+func compareValue(value []byte, op string, target []byte) bool {
+	switch c := bytes.Compare(value, target); op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case ">":
+		return c > 0
+	default:
+		return false
+	}
+}
+
+// compareRevision 与etcd Compare一致, 支持 =, !=, <, >
+func compareRevision(rev int64, op string, v interface{}) bool {
+	target, ok := toRevision(v)
+	if !ok {
+		return false
+	}
+	switch op {
+	case "=":
+		return rev == target
+	case "!=":
+		return rev != target
+	case "<":
+		return rev < target
+	case ">":
+		return rev > target
+	default:
+		return false
+	}
+}
+
+func toRevision(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int64:
+		return val, true
+	case int:
+		return int64(val), true
+	case int32:
+		return int64(val), true
+	case uint:
+		return int64(val), true
+	case uint32:
+		return int64(val), true
+	case uint64:
+		return int64(val), true
+	default:
+		return 0, false
+	}
+}
+
+func keyMissing(key string) memoryCmp {
+	return func(kvs map[string]*memoryKeyValue) bool {
+		_, ok := kvs[key]
+		return !ok
+	}
+}
+
+type memoryKeyValue struct {
+	key            string
+	value          []byte
+	createRevision int64
+	modRevision    int64
+	version        int64
+	lease          int64
+}
+
+type memoryLease struct {
+	ttl      time.Duration
+	deadline time.Time
+	keys     map[string]struct{}
+	timer    *time.Timer
+}
+
+type memoryEvent struct {
+	typ      EventType
+	key      string
+	value    []byte
+	revision int64
+}
+
+// memoryCmp 在事务中检查当前数据
+type memoryCmp func(kvs map[string]*memoryKeyValue) bool
+
+type memoryOp struct {
+	key    string
+	value  []byte
+	lease  int64
+	delete bool
+	get    bool
+}
+
+// memoryStore 带revision和lease的kv存储, 每次修改递增revision并通知watcher
+type memoryStore struct {
+	mu       sync.Mutex
+	revision int64
+	kvs      map[string]*memoryKeyValue
+	leaseID  int64
+	leases   map[int64]*memoryLease
+	watchers map[*memoryWatcher]struct{}
+
+	// onChange 在持有锁时于每次修改后调用
+	onChange func(kvs map[string]*memoryKeyValue)
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		kvs:      make(map[string]*memoryKeyValue),
+		leases:   make(map[int64]*memoryLease),
+		watchers: make(map[*memoryWatcher]struct{}),
+	}
+}
+
+func (ms *memoryStore) get(key string) (memoryKeyValue, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	kv, ok := ms.kvs[key]
+	if !ok {
+		return memoryKeyValue{}, false
+	}
+	return *kv, true
+}
+
+// list 返回key或以key为前缀的数据, 按key排序
+func (ms *memoryStore) list(key string, prefix bool) []memoryKeyValue {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.listLocked(key, prefix)
+}
+
+func (ms *memoryStore) listLocked(key string, prefix bool) []memoryKeyValue {
+	var result []memoryKeyValue
+	for k, kv := range ms.kvs {
+		if k == key || (prefix && strings.HasPrefix(k, key)) {
+			result = append(result, *kv)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].key < result[j].key
+	})
+	return result
+}
+
+func (ms *memoryStore) put(key string, value []byte, lease int64) {
+	ms.txn(nil, []memoryOp{{key: key, value: value, lease: lease}})
+}
+
+func (ms *memoryStore) delete(key string) {
+	ms.txn(nil, []memoryOp{{key: key, delete: true}})
+}
+
+// txn 所有cmps成立时原子地执行ops, 所有ops共用一个revision
+func (ms *memoryStore) txn(cmps []memoryCmp, ops []memoryOp) bool {
+	succeeded, _ := ms.commit(cmps, ops, nil)
+	return succeeded
+}
+
+// commit 所有cmps成立时原子地执行then, 否则执行els; 返回每个op的结果, Key为store中的key
+func (ms *memoryStore) commit(cmps []memoryCmp, then, els []memoryOp) (bool, []TxnResponse) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.commitLocked(cmps, then, els)
+}
+
+func (ms *memoryStore) commitLocked(cmps []memoryCmp, then, els []memoryOp) (bool, []TxnResponse) {
+	succeeded := true
+	for _, cmp := range cmps {
+		if !cmp(ms.kvs) {
+			succeeded = false
+			break
+		}
+	}
+	ops := then
+	if !succeeded {
+		ops = els
+	}
+	for _, op := range ops {
+		if !op.get {
+			ms.revision++
+			break
+		}
+	}
+
+	changed := false
+	responses := make([]TxnResponse, 0, len(ops))
+	for _, op := range ops {
+		resp := TxnResponse{Key: op.key}
+		switch {
+		case op.get:
+			resp.Type = TxnOpGet
+			if kv, ok := ms.kvs[op.key]; ok {
+				resp.Value, resp.Rev, resp.Found = kv.value, kv.modRevision, true
+			}
+		case op.delete:
+			resp.Type = TxnOpDelete
+			resp.Found = ms.deleteLocked(op.key)
+			changed = resp.Found || changed
+		default:
+			resp.Type = TxnOpPut
+			ms.putLocked(op)
+			changed = true
+		}
+		responses = append(responses, resp)
+	}
+	if changed && ms.onChange != nil {
+		ms.onChange(ms.kvs)
+	}
+	return succeeded, responses
+}
+
+func (ms *memoryStore) putLocked(op memoryOp) {
+	kv, ok := ms.kvs[op.key]
+	if !ok {
+		kv = &memoryKeyValue{key: op.key, createRevision: ms.revision}
+		ms.kvs[op.key] = kv
+	}
+	if kv.lease != op.lease {
+		if l, ok := ms.leases[kv.lease]; ok {
+			delete(l.keys, op.key)
+		}
+		if l, ok := ms.leases[op.lease]; ok {
+			l.keys[op.key] = struct{}{}
+		}
+	}
+	kv.value = op.value
+	kv.modRevision = ms.revision
+	kv.version++
+	kv.lease = op.lease
+	ms.publishLocked(memoryEvent{typ: EventTypeModify, key: op.key, value: op.value, revision: ms.revision})
+}
+
+func (ms *memoryStore) deleteLocked(key string) bool {
+	kv, ok := ms.kvs[key]
+	if !ok {
+		return false
+	}
+	if l, ok := ms.leases[kv.lease]; ok {
+		delete(l.keys, key)
+	}
+	delete(ms.kvs, key)
+	ms.publishLocked(memoryEvent{typ: EventTypeDelete, key: key, revision: ms.revision})
+	return true
+}
+
+func (ms *memoryStore) publishLocked(evt memoryEvent) {
+	for w := range ms.watchers {
+		if w.match(evt.key) {
+			w.push(evt)
+		}
+	}
+}
+
+// grant 创建lease, ttl内没有keepAlive则lease过期, 绑定的key被删除
+func (ms *memoryStore) grant(ttl time.Duration) int64 {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.leaseID++
+	id := ms.leaseID
+	ms.leases[id] = &memoryLease{
+		ttl:      ttl,
+		deadline: time.Now().Add(ttl),
+		keys:     make(map[string]struct{}),
+		timer:    time.AfterFunc(ttl, func() { ms.expire(id) }),
+	}
+	return id
+}
+
+func (ms *memoryStore) keepAlive(id int64) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	l, ok := ms.leases[id]
+	if !ok {
+		return false
+	}
+	l.deadline = time.Now().Add(l.ttl)
+	l.timer.Reset(l.ttl)
+	return true
+}
+
+// expire 在lease的timer触发时调用, 已被keepAlive续期的lease不会被删除
+func (ms *memoryStore) expire(id int64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if l, ok := ms.leases[id]; ok && time.Now().After(l.deadline) {
+		ms.revokeLocked(id)
+	}
+}
+
+func (ms *memoryStore) revoke(id int64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.revokeLocked(id)
+}
+
+func (ms *memoryStore) revokeLocked(id int64) {
+	l, ok := ms.leases[id]
+	if !ok {
+		return
+	}
+	l.timer.Stop()
+	delete(ms.leases, id)
+	if len(l.keys) == 0 {
+		return
+	}
+	ms.revision++
+	for key := range l.keys {
+		ms.deleteLocked(key)
+	}
+	if ms.onChange != nil {
+		ms.onChange(ms.kvs)
+	}
+}
+
+// watch 注册watcher并返回当前数据, 保证之后的修改都会通知到watcher
+func (ms *memoryStore) watch(key string, prefix bool) (*memoryWatcher, []memoryKeyValue) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	w := &memoryWatcher{key: key, prefix: prefix, notify: make(chan struct{}, 1)}
+	ms.watchers[w] = struct{}{}
+	return w, ms.listLocked(key, prefix)
+}
+
+func (ms *memoryStore) unwatch(w *memoryWatcher) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.watchers, w)
+}
+
+// memoryWatcher 缓存未消费的event, 写入不阻塞memoryStore
+type memoryWatcher struct {
+	key    string
+	prefix bool
+
+	mu      sync.Mutex
+	pending []memoryEvent
+	notify  chan struct{}
+}
+
+func (w *memoryWatcher) match(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+func (w *memoryWatcher) push(evt memoryEvent) {
+	w.mu.Lock()
+	w.pending = append(w.pending, evt)
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memoryWatcher) pop() []memoryEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending := w.pending
+	w.pending = nil
+	return pending
+}
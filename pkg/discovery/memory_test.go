@@ -0,0 +1,269 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+)
+
+func newTestMemoryDiscovery(t *testing.T, cfg config.Discovery) Discovery {
+	cfg.Type = config.DISCOVERYMEMORY
+	ds, err := NewDiscoveryFactory("nobody").CreateDiscovery(cfg)
+	assert.Nil(t, err)
+	return ds
+}
+
+func TestMemoryDiscovery_WriteRead(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	ds := newTestMemoryDiscovery(t, config.Discovery{Namespace: "/test/list", Prefix: "test-"})
+	defer ds.Close()
+
+	_, err := ds.Get(ctx, "/test/key1")
+	assert.ErrorIs(err, ErrNotExist)
+
+	assert.Nil(ds.Put(ctx, "/test/key1", []byte("dongjiang")))
+	assert.Nil(ds.Put(ctx, "/test/key2", []byte("dongjiang")))
+	// put 空
+	assert.Nil(ds.Put(ctx, "/test/key3", []byte{}))
+
+	val, err := ds.Get(ctx, "/test/key1")
+	assert.Nil(err)
+	assert.Equal("dongjiang", string(val))
+	_, err = ds.Get(ctx, "/test/key3")
+	assert.NotNil(err)
+
+	list, err := ds.List(ctx, "/test")
+	assert.Nil(err)
+	assert.Equal([]KeyValue{{Key: "/test/key1", Value: []byte("dongjiang")}, {Key: "/test/key2", Value: []byte("dongjiang")}}, list)
+
+	assert.Nil(ds.Delete(ctx, "/test/key1"))
+	_, err = ds.Get(ctx, "/test/key1")
+	assert.ErrorIs(err, ErrNotExist)
+
+	ok, err := ds.Batch(ctx, Batch{KVs: []KeyValue{{Key: "/b/1", Value: []byte("1")}, {Key: "/b/2", Value: []byte("2")}}})
+	assert.Nil(err)
+	assert.True(ok)
+	list, err = ds.List(ctx, "/b")
+	assert.Nil(err)
+	assert.Len(list, 2)
+}
+
+func TestMemoryDiscovery_Watch(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ds := newTestMemoryDiscovery(t, config.Discovery{Namespace: "/ns"})
+	defer ds.Close()
+
+	assert.Nil(ds.Put(ctx, "/watch/a", []byte("1")))
+	ch := ds.WatchPrefix(ctx, "/watch", true)
+	keyCh := ds.Watch(ctx, "/watch/b", true)
+
+	evt := <-ch
+	assert.Equal(EventTypeAll, evt.Type)
+	assert.Len(evt.KeyValues, 1)
+	assert.Equal("/watch/a", evt.KeyValues[0].Key)
+	evt = <-keyCh
+	assert.Equal(EventTypeAll, evt.Type)
+	assert.Len(evt.KeyValues, 0)
+
+	assert.Nil(ds.Put(ctx, "/watch/b", []byte("2")))
+	assert.Nil(ds.Delete(ctx, "/watch/a"))
+
+	evt = <-ch
+	assert.Equal(EventTypeModify, evt.Type)
+	assert.Equal(EventKeyValue{Key: "/watch/b", Value: []byte("2"), Rev: 2}, evt.KeyValues[0])
+	evt = <-ch
+	assert.Equal(EventTypeDelete, evt.Type)
+	assert.Equal("/watch/a", evt.KeyValues[0].Key)
+	assert.Equal(int64(3), evt.KeyValues[0].Rev)
+
+	evt = <-keyCh
+	assert.Equal(EventTypeModify, evt.Type)
+	assert.Equal("/watch/b", evt.KeyValues[0].Key)
+
+	cancel()
+	for range ch {
+	}
+	for range keyCh {
+	}
+}
+
+func TestMemoryDiscovery_Heartbeat(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestMemoryDiscovery(t, config.Discovery{})
+	defer ds.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	closed, err := ds.Heartbeat(ctx, "/test/heartbeat", []byte("dongjiang"), 1)
+	assert.Nil(err)
+
+	// keepalive 刷新lease
+	time.Sleep(1500 * time.Millisecond)
+	val, err := ds.Get(context.TODO(), "/test/heartbeat")
+	assert.Nil(err)
+	assert.Equal("dongjiang", string(val))
+
+	cancel()
+	<-closed
+	time.Sleep(1500 * time.Millisecond)
+	_, err = ds.Get(context.TODO(), "/test/heartbeat")
+	assert.ErrorIs(err, ErrNotExist)
+
+	_, err = ds.Heartbeat(ctx, "/test/heartbeat", []byte("dongjiang"), 1)
+	assert.ErrorIs(err, context.Canceled)
+}
+
+func TestMemoryDiscovery_Elect(t *testing.T) {
+	assert := assert.New(t)
+	cfg := config.Discovery{Endpoints: []string{"memory://TestMemoryDiscovery_Elect"}}
+	node1 := newTestMemoryDiscovery(t, cfg)
+	node2 := newTestMemoryDiscovery(t, cfg)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ok, closed, err := node1.Elect(ctx1, "/master", []byte("node1"), 1)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.NotNil(closed)
+
+	ok, closed2, err := node2.Elect(context.TODO(), "/master", []byte("node2"), 1)
+	assert.Nil(err)
+	assert.False(ok)
+	assert.Nil(closed2)
+
+	ok, rev, closed3, err := node2.(RevisionElector).ElectRevision(context.TODO(), "/master2", []byte("node2"), 1)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.NotNil(closed3)
+	kv, _ := node2.(*memoryDiscovery).store.get("/master2")
+	assert.Equal(kv.modRevision, rev)
+	ok, rev, _, err = node1.(RevisionElector).ElectRevision(context.TODO(), "/master2", []byte("node1"), 1)
+	assert.Nil(err)
+	assert.False(ok)
+	assert.Equal(int64(0), rev)
+
+	val, err := node2.Get(context.TODO(), "/master")
+	assert.Nil(err)
+	assert.Equal("node1", string(val))
+
+	// node1 停止keepalive, lease过期后node2成为master
+	cancel1()
+	<-closed
+	assert.Eventually(func() bool {
+		ok, _, err := node2.Elect(context.TODO(), "/master", []byte("node2"), 1)
+		return err == nil && ok
+	}, 3*time.Second, 100*time.Millisecond)
+	val, err = node1.Get(context.TODO(), "/master")
+	assert.Nil(err)
+	assert.Equal("node2", string(val))
+}
+
+func TestMemoryDiscovery_Transaction(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	ds := newTestMemoryDiscovery(t, config.Discovery{})
+
+	txn := ds.NewTransaction()
+	txn.ModRevisionCmp("/txn/key", "=", 0)
+	txn.Put("/txn/key", []byte("v1"))
+	txn.Put("/txn/other", []byte("v1"))
+	assert.Nil(ds.Commit(ctx, txn))
+
+	// key已存在, revision不再为0
+	txn = ds.NewTransaction()
+	txn.ModRevisionCmp("/txn/key", "=", 0)
+	txn.Put("/txn/key", []byte("v2"))
+	assert.ErrorIs(ds.Commit(ctx, txn), ErrTxnFailed)
+
+	txn = ds.NewTransaction()
+	txn.ModRevisionCmp("/txn/key", "=", int64(1))
+	txn.Put("/txn/key", []byte("v2"))
+	txn.Delete("/txn/other")
+	assert.Nil(ds.Commit(ctx, txn))
+
+	val, err := ds.Get(ctx, "/txn/key")
+	assert.Nil(err)
+	assert.Equal("v2", string(val))
+	_, err = ds.Get(ctx, "/txn/other")
+	assert.ErrorIs(err, ErrNotExist)
+
+	assert.ErrorIs(ds.Commit(ctx, &transaction{}), ErrTxnConvert)
+
+	txn = ds.NewTransaction()
+	txn.KeyMissing("/txn/new")
+	txn.VersionCmp("/txn/key", "=", 2)
+	txn.CreateRevisionCmp("/txn/key", ">", 0)
+	txn.ValueCmp("/txn/key", "=", []byte("v2"))
+	txn.Put("/txn/new", []byte("n1"))
+	txn.Get("/txn/new")
+	txn.Else().Get("/txn/key")
+	result, err := ds.Txn(ctx, txn)
+	assert.Nil(err)
+	assert.True(result.Succeeded)
+	assert.Len(result.Responses, 2)
+	assert.Equal(TxnOpPut, result.Responses[0].Type)
+	value, found := result.Get("/txn/new")
+	assert.True(found)
+	assert.Equal("n1", string(value))
+
+	// 比较不成立时执行Else分支, Commit返回ErrTxnFailed
+	txn = ds.NewTransaction()
+	txn.ValueCmp("/txn/missing", "!=", []byte("v1"))
+	txn.Delete("/txn/key")
+	txn.Else().Get("/txn/key")
+	txn.Else().Delete("/txn/new")
+	result, err = ds.Txn(ctx, txn)
+	assert.Nil(err)
+	assert.False(result.Succeeded)
+	value, found = result.Get("/txn/key")
+	assert.True(found)
+	assert.Equal("v2", string(value))
+	assert.Greater(result.Responses[0].Rev, int64(0))
+	assert.Equal(TxnOpDelete, result.Responses[1].Type)
+	assert.True(result.Responses[1].Found)
+	_, found = (&TxnResult{}).Get("/txn/key")
+	assert.False(found)
+
+	ok, err := ds.CompareAndSwap(ctx, "/txn/key", []byte("v2"), []byte("v3"))
+	assert.Nil(err)
+	assert.True(ok)
+	ok, err = ds.CompareAndSwap(ctx, "/txn/key", []byte("v2"), []byte("v4"))
+	assert.Nil(err)
+	assert.False(ok)
+	ok, err = ds.CompareAndSwap(ctx, "/txn/key", nil, []byte("v4"))
+	assert.Nil(err)
+	assert.False(ok)
+	val, err = ds.Get(ctx, "/txn/key")
+	assert.Nil(err)
+	assert.Equal("v3", string(val))
+
+	assert.True(compareRevision(2, "!=", 1))
+	assert.True(compareRevision(2, ">", uint64(1)))
+	assert.True(compareRevision(1, "<", int32(2)))
+	assert.False(compareRevision(1, "=", "1"))
+	assert.False(compareRevision(1, "~", 1))
+	assert.True(compareValue([]byte("a"), "<", []byte("b")))
+	assert.True(compareValue([]byte("b"), ">", []byte("a")))
+	assert.True(compareValue([]byte("a"), "!=", nil))
+	assert.False(compareValue([]byte("a"), "~", []byte("a")))
+}
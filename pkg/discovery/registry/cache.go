@@ -0,0 +1,131 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+// ServiceCache 通过WatchPrefix维护的服务实例本地缓存
+type ServiceCache struct {
+	name   string
+	logger *logger.Logger
+
+	mu        sync.RWMutex
+	instances map[string]*ServiceInstance // key: discovery key
+	sorted    []*ServiceInstance
+	listeners []func([]*ServiceInstance)
+
+	ready  chan struct{}
+	doneCh chan struct{}
+}
+
+// Watch 创建服务name的实例缓存, ctx结束后停止更新
+func (r *Registry) Watch(ctx context.Context, name string) *ServiceCache {
+	c := &ServiceCache{
+		name:      name,
+		logger:    r.logger,
+		instances: make(map[string]*ServiceInstance),
+		ready:     make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+	go c.run(r.ds.WatchPrefix(ctx, r.ServicePath(name), true))
+	return c
+}
+
+func (c *ServiceCache) run(events discovery.WatchEventChan) {
+	defer close(c.doneCh)
+	for evt := range events {
+		if evt.Err != nil {
+			c.logger.Error("watch service instances error", logger.Error(evt.Err), logger.String("service", c.name))
+			continue
+		}
+		c.apply(evt)
+	}
+}
+
+func (c *ServiceCache) apply(evt *discovery.Event) {
+	c.mu.Lock()
+	switch evt.Type {
+	case discovery.EventTypeAll:
+		c.instances = make(map[string]*ServiceInstance)
+		fallthrough
+	case discovery.EventTypeModify:
+		for _, kv := range evt.KeyValues {
+			ins, err := Unmarshal(kv.Value)
+			if err != nil {
+				c.logger.Error("skip invalid service instance", logger.Error(err), logger.String("key", kv.Key))
+				continue
+			}
+			c.instances[kv.Key] = ins
+		}
+	case discovery.EventTypeDelete:
+		for _, kv := range evt.KeyValues {
+			delete(c.instances, kv.Key)
+		}
+	}
+	sorted := make([]*ServiceInstance, 0, len(c.instances))
+	for _, ins := range c.instances {
+		sorted = append(sorted, ins)
+	}
+	sortInstances(sorted)
+	c.sorted = sorted
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	select {
+	case <-c.ready:
+	default:
+		close(c.ready)
+	}
+	for _, fn := range listeners {
+		fn(sorted)
+	}
+}
+
+// Ready 收到第一次全量数据后关闭
+func (c *ServiceCache) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Done watch结束后关闭
+func (c *ServiceCache) Done() <-chan struct{} {
+	return c.doneCh
+}
+
+// Instances 当前所有实例, 按ID排序, 调用方不能修改
+func (c *ServiceCache) Instances() []*ServiceInstance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sorted
+}
+
+// OnChange 实例变化后回调fn
+func (c *ServiceCache) OnChange(fn func(instances []*ServiceInstance)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// Pick 使用picker从当前实例中选择一个, key用于一致性hash. 返回的done在请求结束后调用.
+func (c *ServiceCache) Pick(picker Picker, key string) (*ServiceInstance, func(), error) {
+	return picker.Pick(c.Instances(), key)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultReplicas = 160 // 一致性hash每个实例的虚拟节点数
+
+// Picker 客户端负载均衡, 从实例中选择一个.
+// done 在请求结束后调用, least-loaded 依赖它统计进行中的请求.
+type Picker interface {
+	Pick(instances []*ServiceInstance, key string) (ins *ServiceInstance, done func(), err error)
+}
+
+func noop() {}
+
+type roundRobinPicker struct {
+	next uint64
+}
+
+// NewRoundRobinPicker 轮询
+func NewRoundRobinPicker() Picker {
+	return &roundRobinPicker{}
+}
+
+func (p *roundRobinPicker) Pick(instances []*ServiceInstance, _ string) (*ServiceInstance, func(), error) {
+	if len(instances) == 0 {
+		return nil, noop, ErrNoInstance
+	}
+	n := atomic.AddUint64(&p.next, 1) - 1
+	return instances[n%uint64(len(instances))], noop, nil
+}
+
+type weightedPicker struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+// NewWeightedPicker 平滑加权轮询(同nginx), Weight<=0按1计算
+func NewWeightedPicker() Picker {
+	return &weightedPicker{current: make(map[string]int)}
+}
+
+func (p *weightedPicker) Pick(instances []*ServiceInstance, _ string) (*ServiceInstance, func(), error) {
+	if len(instances) == 0 {
+		return nil, noop, ErrNoInstance
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var (
+		total int
+		best  *ServiceInstance
+	)
+	alive := make(map[string]int, len(instances))
+	for _, ins := range instances {
+		weight := ins.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		alive[ins.ID] = p.current[ins.ID] + weight
+		if best == nil || alive[ins.ID] > alive[best.ID] {
+			best = ins
+		}
+	}
+	alive[best.ID] -= total
+	// 丢弃已下线实例的状态
+	p.current = alive
+	return best, noop, nil
+}
+
+type leastLoadedPicker struct {
+	mu       sync.Mutex
+	inflight map[string]int64
+	next     int
+}
+
+// NewLeastLoadedPicker 选择进行中请求最少的实例, 相同时轮询
+func NewLeastLoadedPicker() Picker {
+	return &leastLoadedPicker{inflight: make(map[string]int64)}
+}
+
+func (p *leastLoadedPicker) Pick(instances []*ServiceInstance, _ string) (*ServiceInstance, func(), error) {
+	if len(instances) == 0 {
+		return nil, noop, ErrNoInstance
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next++
+	var best *ServiceInstance
+	for i := range instances {
+		ins := instances[(p.next+i)%len(instances)]
+		if best == nil || p.inflight[ins.ID] < p.inflight[best.ID] {
+			best = ins
+		}
+	}
+	id := best.ID
+	p.inflight[id]++
+	var once sync.Once
+	return best, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.inflight[id]--; p.inflight[id] <= 0 {
+				delete(p.inflight, id)
+			}
+		})
+	}, nil
+}
+
+type hashRing struct {
+	signature string
+	hashes    []uint32
+	owners    map[uint32]int // 实例在instances中的下标
+}
+
+type consistentHashPicker struct {
+	replicas int
+
+	mu   sync.Mutex
+	ring *hashRing
+}
+
+// NewConsistentHashPicker 一致性hash, 相同key在实例不变时总是选择同一实例; replicas<=0时使用默认虚拟节点数
+func NewConsistentHashPicker(replicas int) Picker {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	return &consistentHashPicker{replicas: replicas}
+}
+
+func (p *consistentHashPicker) Pick(instances []*ServiceInstance, key string) (*ServiceInstance, func(), error) {
+	if len(instances) == 0 {
+		return nil, noop, ErrNoInstance
+	}
+	ring := p.getRing(instances)
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= h })
+	if i == len(ring.hashes) {
+		i = 0
+	}
+	return instances[ring.owners[ring.hashes[i]]], noop, nil
+}
+
+// getRing 实例ID不变时复用hash环
+func (p *consistentHashPicker) getRing(instances []*ServiceInstance) *hashRing {
+	var sb strings.Builder
+	for _, ins := range instances {
+		sb.WriteString(ins.ID)
+		sb.WriteByte('\n')
+	}
+	signature := sb.String()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ring != nil && p.ring.signature == signature {
+		return p.ring
+	}
+	ring := &hashRing{signature: signature, owners: make(map[uint32]int)}
+	for idx, ins := range instances {
+		for i := 0; i < p.replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(ins.ID + "#" + strconv.Itoa(i)))
+			if _, ok := ring.owners[h]; ok {
+				continue
+			}
+			ring.owners[h] = idx
+			ring.hashes = append(ring.hashes, h)
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	p.ring = ring
+	return ring
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testInstances(weights ...int) []*ServiceInstance {
+	var instances []*ServiceInstance
+	for i, w := range weights {
+		instances = append(instances, &ServiceInstance{ID: strconv.Itoa(i), Name: "svc", Addr: "127.0.0.1:" + strconv.Itoa(8080+i), Weight: w})
+	}
+	return instances
+}
+
+func TestPickers_noInstance(t *testing.T) {
+	assert := assert.New(t)
+	for _, p := range []Picker{NewRoundRobinPicker(), NewWeightedPicker(), NewLeastLoadedPicker(), NewConsistentHashPicker(0)} {
+		_, done, err := p.Pick(nil, "key")
+		assert.ErrorIs(err, ErrNoInstance)
+		done()
+	}
+}
+
+func TestRoundRobinPicker(t *testing.T) {
+	assert := assert.New(t)
+	instances := testInstances(0, 0, 0)
+	p := NewRoundRobinPicker()
+	var got []string
+	for i := 0; i < 6; i++ {
+		ins, _, err := p.Pick(instances, "")
+		assert.Nil(err)
+		got = append(got, ins.ID)
+	}
+	assert.Equal([]string{"0", "1", "2", "0", "1", "2"}, got)
+}
+
+func TestWeightedPicker(t *testing.T) {
+	assert := assert.New(t)
+	instances := testInstances(5, 1, 1)
+	p := NewWeightedPicker()
+	var got []string
+	for i := 0; i < 7; i++ {
+		ins, _, err := p.Pick(instances, "")
+		assert.Nil(err)
+		got = append(got, ins.ID)
+	}
+	// 平滑加权轮询
+	assert.Equal([]string{"0", "0", "1", "0", "2", "0", "0"}, got)
+
+	counts := map[string]int{}
+	for i := 0; i < 70; i++ {
+		ins, _, _ := p.Pick(instances[1:], "")
+		counts[ins.ID]++
+	}
+	assert.Equal(map[string]int{"1": 35, "2": 35}, counts)
+}
+
+func TestLeastLoadedPicker(t *testing.T) {
+	assert := assert.New(t)
+	instances := testInstances(0, 0)
+	p := NewLeastLoadedPicker()
+
+	a, doneA, err := p.Pick(instances, "")
+	assert.Nil(err)
+	b, doneB, err := p.Pick(instances, "")
+	assert.Nil(err)
+	assert.NotEqual(a.ID, b.ID)
+
+	doneA()
+	doneA()
+	c, _, err := p.Pick(instances, "")
+	assert.Nil(err)
+	assert.Equal(a.ID, c.ID)
+	d, _, err := p.Pick(instances, "")
+	assert.Nil(err)
+	assert.NotNil(d)
+	doneB()
+}
+
+func TestConsistentHashPicker(t *testing.T) {
+	assert := assert.New(t)
+	instances := testInstances(0, 0, 0, 0)
+	p := NewConsistentHashPicker(0)
+
+	picked := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		ins, _, err := p.Pick(instances, key)
+		assert.Nil(err)
+		picked[key] = ins.ID
+		counts[ins.ID]++
+		again, _, _ := p.Pick(instances, key)
+		assert.Equal(ins.ID, again.ID)
+	}
+	assert.Len(counts, 4)
+
+	// 删除一个实例, 只有该实例上的key会迁移
+	moved := 0
+	for key, id := range picked {
+		ins, _, err := p.Pick(instances[:3], key)
+		assert.Nil(err)
+		if id != "3" {
+			assert.Equal(id, ins.ID)
+		} else {
+			moved++
+		}
+	}
+	assert.Equal(counts["3"], moved)
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+const (
+	defaultRoot          = "/services"            // 默认服务注册根路径
+	defaultTTL           = 10                     // 默认heartbeat ttl 10s
+	defaultRetryInterval = 500 * time.Millisecond // 默认心跳断开后重新注册的间隔
+)
+
+var (
+	ErrInvalidInstance   = errors.New("registry: instance needs a name, an id and an address")
+	ErrNotRegistered     = errors.New("registry: instance is not registered")
+	ErrNoInstance        = errors.New("registry: no available instance")
+	ErrRegistryClosed    = errors.New("registry: registry is closed")
+	ErrAlreadyRegistered = errors.New("registry: instance is already registered")
+)
+
+// ServiceInstance 注册在discovery中的一个服务实例
+type ServiceInstance struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Addr     string            `json:"addr"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Weight   int               `json:"weight,omitempty"`
+	Version  string            `json:"version,omitempty"`
+}
+
+func (ins *ServiceInstance) validate() error {
+	if ins == nil || ins.Name == "" || ins.ID == "" || ins.Addr == "" {
+		return ErrInvalidInstance
+	}
+	return nil
+}
+
+// Marshal 编码为discovery中存储的value
+func (ins *ServiceInstance) Marshal() ([]byte, error) {
+	return json.Marshal(ins)
+}
+
+// Unmarshal 从discovery中存储的value解码实例
+func Unmarshal(data []byte) (*ServiceInstance, error) {
+	ins := &ServiceInstance{}
+	if err := json.Unmarshal(data, ins); err != nil {
+		return nil, fmt.Errorf("registry: decode instance error: %w", err)
+	}
+	return ins, nil
+}
+
+type Option func(r *Registry)
+
+// WithRoot 服务注册根路径, 实例的key为 <root>/<name>/<id>. Defaults to /services.
+func WithRoot(root string) Option {
+	return func(r *Registry) {
+		r.root = root
+	}
+}
+
+// WithTTL 实例heartbeat ttl, 单位秒. Defaults to 10s.
+func WithTTL(ttl int64) Option {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+// Registry 基于Discovery.Heartbeat的服务注册
+type Registry struct {
+	ds     discovery.Discovery
+	root   string
+	ttl    int64
+	logger *logger.Logger
+
+	mu         sync.Mutex
+	registered map[string]context.CancelFunc
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+func NewRegistry(ds discovery.Discovery, opts ...Option) *Registry {
+	r := &Registry{
+		ds:         ds,
+		root:       defaultRoot,
+		ttl:        defaultTTL,
+		logger:     logger.GetLogger("pkg/common/discovery", "registry"),
+		registered: make(map[string]context.CancelFunc),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// ServicePath 服务所有实例的key前缀
+func (r *Registry) ServicePath(name string) string {
+	return path.Join(r.root, name) + "/"
+}
+
+func (r *Registry) instancePath(ins *ServiceInstance) string {
+	return path.Join(r.root, ins.Name, ins.ID)
+}
+
+// Register 写入实例并保持心跳, 心跳断开后自动重新注册, 直到Deregister或ctx结束
+func (r *Registry) Register(ctx context.Context, ins *ServiceInstance) error {
+	if err := ins.validate(); err != nil {
+		return err
+	}
+	value, err := ins.Marshal()
+	if err != nil {
+		return err
+	}
+	key := r.instancePath(ins)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrRegistryClosed
+	}
+	if _, ok := r.registered[key]; ok {
+		return ErrAlreadyRegistered
+	}
+	hbCtx, cancel := context.WithCancel(ctx)
+	closed, err := r.ds.Heartbeat(hbCtx, key, value, r.ttl)
+	if err != nil {
+		cancel()
+		return err
+	}
+	r.registered[key] = cancel
+	r.wg.Add(1)
+	go r.keepRegistered(hbCtx, key, value, closed)
+	return nil
+}
+
+func (r *Registry) keepRegistered(ctx context.Context, key string, value []byte, closed <-chan discovery.Closed) {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		r.logger.Error("service instance heartbeat closed, register again", logger.String("key", key))
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(defaultRetryInterval):
+			}
+			ch, err := r.ds.Heartbeat(ctx, key, value, r.ttl)
+			if err == nil {
+				closed = ch
+				break
+			}
+			r.logger.Error("register service instance error, retry", logger.Error(err), logger.String("key", key))
+		}
+	}
+}
+
+// Deregister 停止心跳并删除实例
+func (r *Registry) Deregister(ctx context.Context, ins *ServiceInstance) error {
+	if err := ins.validate(); err != nil {
+		return err
+	}
+	key := r.instancePath(ins)
+	r.mu.Lock()
+	cancel, ok := r.registered[key]
+	delete(r.registered, key)
+	r.mu.Unlock()
+	if !ok {
+		return ErrNotRegistered
+	}
+	cancel()
+	return r.ds.Delete(ctx, key)
+}
+
+// GetService 读取服务当前所有实例, 按ID排序
+func (r *Registry) GetService(ctx context.Context, name string) ([]*ServiceInstance, error) {
+	kvs, err := r.ds.List(ctx, r.ServicePath(name))
+	if err != nil {
+		return nil, err
+	}
+	var result []*ServiceInstance
+	for _, kv := range kvs {
+		ins, err := Unmarshal(kv.Value)
+		if err != nil {
+			r.logger.Error("skip invalid service instance", logger.Error(err), logger.String("key", kv.Key))
+			continue
+		}
+		result = append(result, ins)
+	}
+	sortInstances(result)
+	return result, nil
+}
+
+// Close 注销所有通过该Registry注册的实例
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	registered := r.registered
+	r.registered = make(map[string]context.CancelFunc)
+	r.mu.Unlock()
+
+	var errs []error
+	for key, cancel := range registered {
+		cancel()
+		ctx, done := context.WithTimeout(context.Background(), time.Duration(r.ttl)*time.Second)
+		if err := r.ds.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+		done()
+	}
+	r.wg.Wait()
+	return errors.Join(errs...)
+}
+
+func sortInstances(instances []*ServiceInstance) {
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+}
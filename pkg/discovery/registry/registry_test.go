@@ -0,0 +1,139 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/discovery"
+)
+
+func newTestDiscovery(t *testing.T) discovery.Discovery {
+	ds, err := discovery.NewDiscoveryFactory("nobody").CreateDiscovery(config.Discovery{Type: config.DISCOVERYMEMORY})
+	assert.Nil(t, err)
+	return ds
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDiscovery(t)
+	r := NewRegistry(ds, WithRoot("/kss"), WithTTL(1))
+
+	assert.ErrorIs(r.Register(ctx, &ServiceInstance{Name: "user"}), ErrInvalidInstance)
+
+	ins1 := &ServiceInstance{ID: "1", Name: "user", Addr: "127.0.0.1:8080", Weight: 2, Version: "v1", Metadata: map[string]string{"zone": "a"}}
+	ins2 := &ServiceInstance{ID: "2", Name: "user", Addr: "127.0.0.1:8081"}
+	assert.Nil(r.Register(ctx, ins1))
+	assert.ErrorIs(r.Register(ctx, ins1), ErrAlreadyRegistered)
+
+	cache := r.Watch(ctx, "user")
+	<-cache.Ready()
+	assert.Equal([]*ServiceInstance{ins1}, cache.Instances())
+
+	changed := make(chan []*ServiceInstance, 10)
+	cache.OnChange(func(instances []*ServiceInstance) { changed <- instances })
+	assert.Nil(r.Register(ctx, ins2))
+	assert.Equal([]*ServiceInstance{ins1, ins2}, <-changed)
+
+	instances, err := r.GetService(ctx, "user")
+	assert.Nil(err)
+	assert.Equal([]*ServiceInstance{ins1, ins2}, instances)
+	assert.Equal("/kss/user/", r.ServicePath("user"))
+
+	// 超过ttl后心跳仍保持注册
+	time.Sleep(1500 * time.Millisecond)
+	instances, err = r.GetService(ctx, "user")
+	assert.Nil(err)
+	assert.Len(instances, 2)
+
+	assert.Nil(r.Deregister(ctx, ins1))
+	assert.Equal([]*ServiceInstance{ins2}, <-changed)
+	assert.ErrorIs(r.Deregister(ctx, ins1), ErrNotRegistered)
+
+	ins, done, err := cache.Pick(NewRoundRobinPicker(), "")
+	assert.Nil(err)
+	assert.Equal(ins2, ins)
+	done()
+
+	assert.Nil(r.Close())
+	assert.Equal([]*ServiceInstance{}, <-changed)
+	assert.ErrorIs(r.Register(ctx, ins1), ErrRegistryClosed)
+
+	cancel()
+	<-cache.Done()
+}
+
+// closableDiscovery 可以手动关闭heartbeat, 模拟lease丢失
+type closableDiscovery struct {
+	discovery.Discovery
+	heartbeats chan chan discovery.Closed
+}
+
+func (d *closableDiscovery) Heartbeat(ctx context.Context, key string, value []byte, ttl int64) (<-chan discovery.Closed, error) {
+	if _, err := d.Discovery.Heartbeat(ctx, key, value, ttl); err != nil {
+		return nil, err
+	}
+	ch := make(chan discovery.Closed)
+	d.heartbeats <- ch
+	return ch, nil
+}
+
+func TestRegistry_reregister(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := &closableDiscovery{Discovery: newTestDiscovery(t), heartbeats: make(chan chan discovery.Closed, 10)}
+	r := NewRegistry(ds)
+
+	ins := &ServiceInstance{ID: "1", Name: "order", Addr: "127.0.0.1:8080"}
+	assert.Nil(r.Register(ctx, ins))
+	hb := <-ds.heartbeats
+
+	// 心跳断开后重新注册
+	assert.Nil(ds.Delete(ctx, "/services/order/1"))
+	close(hb)
+	select {
+	case <-ds.heartbeats:
+	case <-time.After(5 * time.Second):
+		t.Fatal("instance is not registered again")
+	}
+	instances, err := r.GetService(ctx, "order")
+	assert.Nil(err)
+	assert.Equal([]*ServiceInstance{ins}, instances)
+	assert.Nil(r.Close())
+}
+
+func TestUnmarshal(t *testing.T) {
+	assert := assert.New(t)
+	ins := &ServiceInstance{ID: "1", Name: "user", Addr: "127.0.0.1:8080", Weight: 3}
+	data, err := ins.Marshal()
+	assert.Nil(err)
+	assert.Equal(`{"id":"1","name":"user","addr":"127.0.0.1:8080","weight":3}`, string(data))
+	got, err := Unmarshal(data)
+	assert.Nil(err)
+	assert.Equal(ins, got)
+
+	_, err = Unmarshal([]byte("{"))
+	assert.NotNil(err)
+}
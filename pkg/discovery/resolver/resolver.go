@@ -0,0 +1,196 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/attributes"
+	grpcresolver "google.golang.org/grpc/resolver"
+
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/discovery/registry"
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+const (
+	Scheme      = "kss"       // 默认scheme, target形如 kss:///<service>
+	defaultRoot = "/services" // 与registry默认根路径一致
+)
+
+type instanceKey struct{}
+
+// instanceAttr 按值比较, 实例未变化时grpc不会重建连接
+type instanceAttr struct {
+	*registry.ServiceInstance
+}
+
+func (a instanceAttr) Equal(o interface{}) bool {
+	other, ok := o.(instanceAttr)
+	return ok && reflect.DeepEqual(a.ServiceInstance, other.ServiceInstance)
+}
+
+// Instance 获得resolver地址对应的服务实例, value不是ServiceInstance时返回false
+func Instance(addr grpcresolver.Address) (*registry.ServiceInstance, bool) {
+	attr, ok := addr.Attributes.Value(instanceKey{}).(instanceAttr)
+	if !ok {
+		return nil, false
+	}
+	return attr.ServiceInstance, true
+}
+
+type Option func(b *builder)
+
+// WithScheme Defaults to kss.
+func WithScheme(scheme string) Option {
+	return func(b *builder) {
+		b.scheme = scheme
+	}
+}
+
+// WithRoot target endpoint对应的key前缀为 <root>/<endpoint>/, root为空时前缀为 /<endpoint>.
+// Defaults to /services, 与registry一致.
+func WithRoot(root string) Option {
+	return func(b *builder) {
+		b.root = root
+	}
+}
+
+type builder struct {
+	ds     discovery.Discovery
+	scheme string
+	root   string
+	logger *logger.Logger
+}
+
+// NewBuilder 基于Discovery.WatchPrefix的grpc resolver.Builder.
+// value为registry.ServiceInstance时使用其Addr并通过Instance获得实例, 否则value即为地址.
+//
+//	conn, err := grpc.NewClient("kss:///user", grpc.WithResolvers(resolver.NewBuilder(ds)),
+//		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"round_robin":{}}]}`), ...)
+func NewBuilder(ds discovery.Discovery, opts ...Option) grpcresolver.Builder {
+	b := &builder{
+		ds:     ds,
+		scheme: Scheme,
+		root:   defaultRoot,
+		logger: logger.GetLogger("pkg/common/discovery", "resolver"),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *builder) Scheme() string {
+	return b.scheme
+}
+
+func (b *builder) Build(target grpcresolver.Target, cc grpcresolver.ClientConn, _ grpcresolver.BuildOptions) (grpcresolver.Resolver, error) {
+	endpoint := strings.TrimPrefix(target.Endpoint(), "/")
+	prefix := "/" + endpoint
+	if b.root != "" {
+		prefix = path.Join(b.root, endpoint) + "/"
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &kssResolver{
+		cc:        cc,
+		cancel:    cancel,
+		logger:    b.logger,
+		addresses: make(map[string]grpcresolver.Address),
+	}
+	r.wg.Add(1)
+	go r.watch(b.ds.WatchPrefix(ctx, prefix, true))
+	return r, nil
+}
+
+type kssResolver struct {
+	cc        grpcresolver.ClientConn
+	cancel    context.CancelFunc
+	logger    *logger.Logger
+	addresses map[string]grpcresolver.Address // key: discovery key
+	wg        sync.WaitGroup
+}
+
+func (r *kssResolver) watch(events discovery.WatchEventChan) {
+	defer r.wg.Done()
+	for evt := range events {
+		if evt.Err != nil {
+			r.cc.ReportError(evt.Err)
+			continue
+		}
+		switch evt.Type {
+		case discovery.EventTypeAll:
+			r.addresses = make(map[string]grpcresolver.Address)
+			fallthrough
+		case discovery.EventTypeModify:
+			for _, kv := range evt.KeyValues {
+				if addr, ok := r.toAddress(kv); ok {
+					r.addresses[kv.Key] = addr
+				} else {
+					delete(r.addresses, kv.Key)
+				}
+			}
+		case discovery.EventTypeDelete:
+			for _, kv := range evt.KeyValues {
+				delete(r.addresses, kv.Key)
+			}
+		}
+		if err := r.cc.UpdateState(grpcresolver.State{Addresses: r.sortedAddresses()}); err != nil {
+			r.logger.Error("update resolver state error", logger.Error(err))
+		}
+	}
+}
+
+func (r *kssResolver) toAddress(kv discovery.EventKeyValue) (grpcresolver.Address, bool) {
+	if len(kv.Value) == 0 {
+		return grpcresolver.Address{}, false
+	}
+	ins, err := registry.Unmarshal(kv.Value)
+	if err != nil || ins.Addr == "" {
+		return grpcresolver.Address{Addr: strings.TrimSpace(string(kv.Value))}, true
+	}
+	return grpcresolver.Address{
+		Addr:       ins.Addr,
+		Attributes: attributes.New(instanceKey{}, instanceAttr{ins}),
+	}, true
+}
+
+func (r *kssResolver) sortedAddresses() []grpcresolver.Address {
+	keys := make([]string, 0, len(r.addresses))
+	for key := range r.addresses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]grpcresolver.Address, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, r.addresses[key])
+	}
+	return result
+}
+
+// ResolveNow watch会推送所有变化, 无需主动解析
+func (r *kssResolver) ResolveNow(grpcresolver.ResolveNowOptions) {}
+
+func (r *kssResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
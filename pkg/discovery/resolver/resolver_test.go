@@ -0,0 +1,148 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	grpcresolver "google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/discovery"
+	"github.com/kubeservice-stack/common/pkg/discovery/registry"
+)
+
+type fakeClientConn struct {
+	states chan grpcresolver.State
+}
+
+func (cc *fakeClientConn) UpdateState(state grpcresolver.State) error {
+	cc.states <- state
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(error) {}
+
+func (cc *fakeClientConn) NewAddress([]grpcresolver.Address) {}
+
+func (cc *fakeClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return nil
+}
+
+func newTestDiscovery(t *testing.T) discovery.Discovery {
+	ds, err := discovery.NewDiscoveryFactory("nobody").CreateDiscovery(config.Discovery{Type: config.DISCOVERYMEMORY})
+	assert.Nil(t, err)
+	return ds
+}
+
+func TestResolver(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+	ds := newTestDiscovery(t)
+	ins := &registry.ServiceInstance{ID: "1", Name: "user", Addr: "127.0.0.1:8080", Weight: 2}
+	data, err := ins.Marshal()
+	assert.Nil(err)
+	assert.Nil(ds.Put(ctx, "/services/user/1", data))
+
+	b := NewBuilder(ds)
+	assert.Equal(Scheme, b.Scheme())
+	cc := &fakeClientConn{states: make(chan grpcresolver.State, 10)}
+	r, err := b.Build(grpcresolver.Target{URL: url.URL{Scheme: Scheme, Path: "/user"}}, cc, grpcresolver.BuildOptions{})
+	assert.Nil(err)
+	defer r.Close()
+	r.ResolveNow(grpcresolver.ResolveNowOptions{})
+
+	state := <-cc.states
+	assert.Len(state.Addresses, 1)
+	assert.Equal("127.0.0.1:8080", state.Addresses[0].Addr)
+	got, ok := Instance(state.Addresses[0])
+	assert.True(ok)
+	assert.Equal(ins, got)
+
+	// 非ServiceInstance的value作为地址
+	assert.Nil(ds.Put(ctx, "/services/user/2", []byte("127.0.0.1:8081")))
+	state = <-cc.states
+	assert.Len(state.Addresses, 2)
+	assert.Equal("127.0.0.1:8081", state.Addresses[1].Addr)
+	_, ok = Instance(state.Addresses[1])
+	assert.False(ok)
+
+	assert.Nil(ds.Delete(ctx, "/services/user/1"))
+	state = <-cc.states
+	assert.Equal([]grpcresolver.Address{{Addr: "127.0.0.1:8081"}}, state.Addresses)
+
+	// 未变化的实例属性相等
+	same := instanceAttr{&registry.ServiceInstance{ID: "1", Addr: "a"}}
+	assert.True(same.Equal(instanceAttr{&registry.ServiceInstance{ID: "1", Addr: "a"}}))
+	assert.False(same.Equal(instanceAttr{&registry.ServiceInstance{ID: "2", Addr: "a"}}))
+	assert.False(same.Equal("a"))
+}
+
+func TestResolver_WithRoot(t *testing.T) {
+	assert := assert.New(t)
+	ds := newTestDiscovery(t)
+	assert.Nil(ds.Put(context.TODO(), "/raw/endpoints/a", []byte("127.0.0.1:9090")))
+
+	b := NewBuilder(ds, WithScheme("etcd"), WithRoot(""))
+	assert.Equal("etcd", b.Scheme())
+	cc := &fakeClientConn{states: make(chan grpcresolver.State, 10)}
+	r, err := b.Build(grpcresolver.Target{URL: url.URL{Scheme: "etcd", Path: "/raw/endpoints/"}}, cc, grpcresolver.BuildOptions{})
+	assert.Nil(err)
+	defer r.Close()
+	state := <-cc.states
+	assert.Equal([]grpcresolver.Address{{Addr: "127.0.0.1:9090"}}, state.Addresses)
+}
+
+func TestResolver_grpc(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	ds := newTestDiscovery(t)
+	reg := registry.NewRegistry(ds)
+	defer reg.Close()
+	assert.Nil(reg.Register(ctx, &registry.ServiceInstance{ID: "1", Name: "health", Addr: lis.Addr().String()}))
+
+	conn, err := grpc.NewClient("kss:///health",
+		grpc.WithResolvers(NewBuilder(ds)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"round_robin":{}}]}`),
+	)
+	assert.Nil(err)
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	assert.Nil(err)
+	assert.Equal(healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+)
+
+// newTLSConfig 根据CA/证书/私钥生成tls配置, 没有配置TLS时返回nil
+func newTLSConfig(cfg config.Discovery) (*tls.Config, error) {
+	if !cfg.TLSEnabled() {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify, // #nosec G402 -- 由配置显式开启
+	}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read discovery ca file error: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in discovery ca file %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load discovery client certificate error: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// ownerNamespace IsolateOwner时每个owner使用独立的namespace <namespace>/<owner>
+func ownerNamespace(cfg config.Discovery, owner string) string {
+	if !cfg.IsolateOwner {
+		return cfg.Namespace
+	}
+	return filepath.Join(cfg.Namespace, filepath.Join("/", owner))
+}
+
+// isolateKey 去掉key中的"..", 保证key不会越过owner的namespace
+func isolateKey(key string) string {
+	return filepath.Join("/", key)
+}
+
+// tokenCredential 在每个grpc请求的metadata中携带etcd auth token
+type tokenCredential string
+
+func (t tokenCredential) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"token": string(t)}, nil
+}
+
+func (t tokenCredential) RequireTransportSecurity() bool {
+	return false
+}
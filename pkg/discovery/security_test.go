@@ -0,0 +1,131 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+)
+
+// writeTestCert 生成自签名证书和私钥
+func writeTestCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "discovery"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	assert := assert.New(t)
+	certFile, keyFile := writeTestCert(t)
+
+	tlsCfg, err := newTLSConfig(config.Discovery{})
+	assert.Nil(err)
+	assert.Nil(tlsCfg)
+
+	tlsCfg, err = newTLSConfig(config.Discovery{CAFile: certFile, CertFile: certFile, KeyFile: keyFile})
+	assert.Nil(err)
+	assert.NotNil(tlsCfg.RootCAs)
+	assert.Len(tlsCfg.Certificates, 1)
+
+	_, err = newTLSConfig(config.Discovery{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.NotNil(err)
+	_, err = newTLSConfig(config.Discovery{CAFile: keyFile})
+	assert.NotNil(err)
+	_, err = newTLSConfig(config.Discovery{CertFile: certFile})
+	assert.NotNil(err)
+
+	_, err = NewDiscoveryFactory("nobody").CreateDiscovery(config.Discovery{
+		Type:      config.DISCOVERYCONSUL,
+		Endpoints: []string{"127.0.0.1:8500"},
+		CAFile:    keyFile,
+	})
+	assert.NotNil(err)
+}
+
+func TestOwnerIsolation(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.TODO()
+
+	assert.Equal("app", ownerNamespace(config.Discovery{Namespace: "app"}, "svc"))
+	assert.Equal("app/svc", ownerNamespace(config.Discovery{Namespace: "app", IsolateOwner: true}, "svc"))
+	assert.Equal("app/svc", ownerNamespace(config.Discovery{Namespace: "app", IsolateOwner: true}, "../svc"))
+	assert.Equal("/a/b", isolateKey("../../a/b"))
+
+	factory1, factory2 := NewDiscoveryFactory("owner1"), NewDiscoveryFactory("owner2")
+	cfg := config.Discovery{Type: config.DISCOVERYMEMORY, Endpoints: []string{t.Name()}, Namespace: "app", IsolateOwner: true}
+	ds1, err := factory1.CreateDiscovery(cfg)
+	assert.Nil(err)
+	ds2, err := factory2.CreateDiscovery(cfg)
+	assert.Nil(err)
+
+	assert.Nil(ds1.Put(ctx, "/key", []byte("v1")))
+	// 不能通过".."访问其他owner的key
+	assert.Nil(ds2.Put(ctx, "../owner1/key", []byte("v2")))
+	val, err := ds1.Get(ctx, "/key")
+	assert.Nil(err)
+	assert.Equal("v1", string(val))
+	val, err = ds2.Get(ctx, "/owner1/key")
+	assert.Nil(err)
+	assert.Equal("v2", string(val))
+	kvs, err := ds1.List(ctx, "/")
+	assert.Nil(err)
+	assert.Equal([]KeyValue{{Key: "/key", Value: []byte("v1")}}, kvs)
+}
+
+func TestTokenCredential(t *testing.T) {
+	assert := assert.New(t)
+	md, err := tokenCredential("secret").GetRequestMetadata(context.TODO())
+	assert.Nil(err)
+	assert.Equal(map[string]string{"token": "secret"}, md)
+	assert.False(tokenCredential("secret").RequireTransportSecurity())
+}
@@ -18,14 +18,20 @@ package discovery
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/uber-go/tally"
 	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	etcdcliv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
 )
 
 const (
 	defaultRetryInterval = 100 * time.Millisecond // 默认watch周期100ms
+	maxRetryInterval     = 5 * time.Second        // watch重连最大退避时间
 )
 
 type watcher struct {
@@ -34,6 +40,7 @@ type watcher struct {
 	key      string
 	fetchVal bool
 	opts     []etcdcliv3.OpOption
+	rev      int64 // 最后处理的revision, 0表示需要全量同步
 
 	EventC WatchEventChan
 }
@@ -53,54 +60,143 @@ func newWatcher(ctx context.Context, cli *etcdDiscovery, key string, fetchVal bo
 	return w
 }
 
+// watch 首次全量同步后从最后处理的revision+1继续watch:
+// 连接断开时带指数退避重连且不重放已处理的event; revision被compact时重新全量同步, 发送EventTypeAll
 func (w *watcher) watch(eventCh chan<- *Event) {
 	defer close(eventCh)
 
-	cli := w.cli.client
-	var evtAll *Event
-	var resp *etcdcliv3.GetResponse
-	// etcdcliv3.Watch 可能会抛出ErrCompacted错误
+	backoff := newBackoff()
 	for {
-		for {
-			var err error
-			if resp, err = cli.Get(w.ctx, w.key, w.opts...); err == nil {
-				evtAll = w.packAllEvents(resp.Kvs)
-				break
+		if w.rev == 0 {
+			evtAll, err := w.resync()
+			if err != nil {
+				w.cli.watchMetrics.errors.Inc(1)
+				if !backoff.wait(w.ctx) {
+					return
+				}
+				continue
 			}
-			select {
-			case <-w.ctx.Done():
+			if !w.send(eventCh, evtAll) {
 				return
-			case <-time.After(defaultRetryInterval):
 			}
 		}
-		select {
-		case <-w.ctx.Done():
+
+		if !w.watchFrom(eventCh, backoff) {
 			return
-		case eventCh <- evtAll:
 		}
+		if w.ctx.Err() != nil {
+			return
+		}
+		w.cli.watchMetrics.reconnects.Inc(1)
+		if !backoff.wait(w.ctx) {
+			return
+		}
+	}
+}
 
-		opts := append(w.opts, etcdcliv3.WithRev(resp.Header.Revision+1))
-		wchc := cli.Watch(w.ctx, w.key, opts...)
-		if wchc == nil {
-			continue
+// resync 读取全量数据并记录revision
+func (w *watcher) resync() (*Event, error) {
+	resp, err := w.cli.client.Get(w.ctx, w.key, w.opts...)
+	if err != nil {
+		return nil, err
+	}
+	w.rev = resp.Header.Revision
+	w.cli.watchMetrics.resyncs.Inc(1)
+	return w.packAllEvents(resp.Kvs), nil
+}
+
+// watchFrom 从w.rev+1开始watch直到watch channel关闭, 返回false表示ctx结束
+func (w *watcher) watchFrom(eventCh chan<- *Event, backoff *backoff) bool {
+	ctx, cancel := context.WithCancel(etcdcliv3.WithRequireLeader(w.ctx))
+	defer cancel()
+
+	opts := append(append([]etcdcliv3.OpOption{}, w.opts...), etcdcliv3.WithRev(w.rev+1), etcdcliv3.WithProgressNotify())
+	for watchResp := range w.cli.client.Watch(ctx, w.key, opts...) {
+		if watchResp.CompactRevision != 0 || errors.Is(watchResp.Err(), rpctypes.ErrCompacted) {
+			// 需要的revision已被compact, 重新全量同步
+			w.cli.logger.Warn("watch revision compacted, resync",
+				logger.String("key", w.key), logger.Int64("rev", w.rev), logger.Int64("compactRev", watchResp.CompactRevision))
+			w.rev = 0
+			return true
 		}
-		for watchResp := range wchc {
-			if err := watchResp.Err(); err != nil {
-				select {
-				case <-w.ctx.Done():
-					return
-				case eventCh <- &Event{Err: err}:
-				}
-				continue
+		if err := watchResp.Err(); err != nil {
+			w.cli.watchMetrics.errors.Inc(1)
+			if !w.send(eventCh, &Event{Err: err}) {
+				return false
 			}
-			for _, event := range watchResp.Events {
-				select {
-				case <-w.ctx.Done():
-					return
-				case eventCh <- w.packWatchEvent(event):
-				}
+			return true
+		}
+		backoff.reset()
+		if watchResp.IsProgressNotify() && watchResp.Header.Revision > w.rev {
+			w.rev = watchResp.Header.Revision
+			continue
+		}
+		for _, event := range watchResp.Events {
+			if !w.send(eventCh, w.packWatchEvent(event)) {
+				return false
 			}
+			w.rev = event.Kv.ModRevision
+		}
+	}
+	return w.ctx.Err() == nil
+}
+
+func (w *watcher) send(eventCh chan<- *Event, evt *Event) bool {
+	select {
+	case <-w.ctx.Done():
+		return false
+	case eventCh <- evt:
+		if evt.Err == nil {
+			w.cli.watchMetrics.events.Inc(1)
 		}
+		return true
+	}
+}
+
+// backoff 指数退避, 从defaultRetryInterval到maxRetryInterval
+type backoff struct {
+	interval time.Duration
+}
+
+func newBackoff() *backoff {
+	return &backoff{interval: defaultRetryInterval}
+}
+
+func (b *backoff) reset() {
+	b.interval = defaultRetryInterval
+}
+
+// wait 等待当前退避时间并翻倍, ctx结束返回false
+func (b *backoff) wait(ctx context.Context) bool {
+	timer := time.NewTimer(b.interval)
+	defer timer.Stop()
+	b.interval *= 2
+	if b.interval > maxRetryInterval {
+		b.interval = maxRetryInterval
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// watchMetrics watch统计: event数, 错误数, 重连次数, 全量同步次数
+type watchMetrics struct {
+	events     tally.Counter
+	errors     tally.Counter
+	reconnects tally.Counter
+	resyncs    tally.Counter
+}
+
+func newWatchMetrics(scope tally.Scope) *watchMetrics {
+	scope = scope.SubScope("discovery_watch")
+	return &watchMetrics{
+		events:     scope.Counter("events"),
+		errors:     scope.Counter("errors"),
+		reconnects: scope.Counter("reconnects"),
+		resyncs:    scope.Counter("resyncs"),
 	}
 }
 
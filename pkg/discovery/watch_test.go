@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+	etcdcliv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+)
+
+func nextWatchEvent(t *testing.T, ch WatchEventChan) *Event {
+	select {
+	case evt := <-ch:
+		return evt
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait watch event timeout")
+		return nil
+	}
+}
+
+func TestWatcher_ResumeAndResync(t *testing.T) {
+	assert := assert.New(t)
+	cluster := StartEtcdMockCluster(t, "http://localhost:8704")
+	defer cluster.Terminate(t)
+
+	ds, err := newEtedDiscovery(config.Discovery{Endpoints: cluster.Endpoints}, "nobody")
+	assert.Nil(err)
+	defer ds.Close()
+	ed := ds.(*etcdDiscovery)
+	scope := tally.NewTestScope("", nil)
+	ed.watchMetrics = newWatchMetrics(scope)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(ed.Put(ctx, "/watch/a", []byte("1")))
+	assert.Nil(ed.Put(ctx, "/watch/b", []byte("2")))
+	assert.Nil(ed.Put(ctx, "/watch/a", []byte("3")))
+	resp, err := ed.client.Get(ctx, ed.keyPath("/watch/"), etcdcliv3.WithPrefix())
+	assert.Nil(err)
+	_, err = ed.client.Compact(ctx, resp.Header.Revision)
+	assert.Nil(err)
+
+	// 从已被compact的revision开始, 需要全量同步
+	w := &watcher{
+		ctx:      ctx,
+		cli:      ed,
+		key:      ed.keyPath("/watch/"),
+		fetchVal: true,
+		opts:     []etcdcliv3.OpOption{etcdcliv3.WithPrefix()},
+		rev:      1,
+	}
+	ch := make(chan *Event)
+	go w.watch(ch)
+
+	evt := nextWatchEvent(t, ch)
+	assert.Nil(evt.Err)
+	assert.Equal(EventTypeAll, evt.Type)
+	assert.Len(evt.KeyValues, 2)
+
+	assert.Nil(ed.Put(ctx, "/watch/c", []byte("4")))
+	evt = nextWatchEvent(t, ch)
+	assert.Equal(EventTypeModify, evt.Type)
+	assert.Equal("/watch/c", evt.KeyValues[0].Key)
+
+	counters := scope.Snapshot().Counters()
+	assert.Equal(int64(1), counters["discovery_watch.resyncs+"].Value())
+	assert.Equal(int64(2), counters["discovery_watch.events+"].Value())
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+	b := newBackoff()
+	for i := 0; i < 10; i++ {
+		b.interval *= 2
+		if b.interval > maxRetryInterval {
+			b.interval = maxRetryInterval
+		}
+	}
+	assert.Equal(maxRetryInterval, b.interval)
+	b.reset()
+	assert.Equal(defaultRetryInterval, b.interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(b.wait(ctx))
+	assert.Equal(2*defaultRetryInterval, b.interval)
+}
@@ -97,6 +97,11 @@ func NewDBConn(cfg config.DBConfig) (*DBConn, error) {
 	}, nil
 }
 
+// DB returns the underlying gorm.DB
+func (g *DBConn) DB() *gorm.DB {
+	return g.db
+}
+
 func (g *DBConn) Close() error {
 	db, err := g.db.DB()
 	if err != nil {
@@ -0,0 +1,209 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+// TaskInfo 管理接口返回的任务信息
+type TaskInfo struct {
+	Name         string    `json:"name"`
+	Spec         string    `json:"spec"`
+	Tags         []string  `json:"tags"`
+	NextRun      time.Time `json:"next_run"`
+	Paused       bool      `json:"paused"`
+	Running      bool      `json:"running"`
+	Runs         uint64    `json:"runs"`
+	Failures     uint64    `json:"failures"`
+	LastRun      time.Time `json:"last_run,omitempty"`
+	LastDuration string    `json:"last_duration,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	History      []RunInfo `json:"history,omitempty"`
+}
+
+// RunInfo 管理接口返回的执行记录
+type RunInfo struct {
+	Start    time.Time `json:"start"`
+	Duration string    `json:"duration"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func newTaskInfo(s *Scheduler, j *Task, withHistory bool) TaskInfo {
+	h := j.History()
+	// 下一次执行时间由调度goroutine在s.mu内修改, 加锁读取快照
+	s.mu.Lock()
+	info := TaskInfo{
+		Name:    j.GetName(),
+		Spec:    j.Spec(),
+		Tags:    append([]string(nil), j.Tags()...),
+		NextRun: j.nextRun,
+	}
+	s.mu.Unlock()
+	info.Paused = j.Paused()
+	info.Running = j.Running()
+	info.Runs = h.Runs
+	info.Failures = h.Failures
+	info.LastRun = h.LastRun
+	if h.Runs > 0 {
+		info.LastDuration = h.LastDuration.String()
+	}
+	if h.LastErr != nil {
+		info.LastError = h.LastErr.Error()
+	}
+	if withHistory {
+		info.History = make([]RunInfo, 0, len(h.Records))
+		for _, r := range h.Records {
+			run := RunInfo{Start: r.Start, Duration: r.Duration.String(), Attempts: r.Attempts}
+			if r.Err != nil {
+				run.Error = r.Err.Error()
+			}
+			info.History = append(info.History, run)
+		}
+	}
+	return info
+}
+
+// adminHandler 调度器的HTTP管理接口
+type adminHandler struct {
+	sched  *Scheduler
+	mux    *http.ServeMux
+	logger *logger.Logger
+}
+
+// NewAdminHandler returns a http.Handler to inspect and control the jobs of s:
+//
+//	GET  /tasks                  list the jobs, filtered by ?tag=
+//	GET  /tasks/{name}           a job with its recent runs
+//	POST /tasks/{name}/pause     pause the job
+//	POST /tasks/{name}/resume    resume the job
+//	POST /tasks/{name}/trigger   run the job now
+//
+// Jobs without Name are named after their function, e.g. "github.com/x/pkg.job";
+// escape the name with url.PathEscape so that "/" is sent as "%2F".
+// With WithElector the controls answer 503 on a replica that is not the leader,
+// pause and resume are saved to the JobStore, and triggered runs are cancelled with the leader context.
+//
+// Mount it under a prefix with http.StripPrefix:
+//
+//	http.Handle("/schedule/", http.StripPrefix("/schedule", schedule.NewAdminHandler(s)))
+func NewAdminHandler(s *Scheduler) http.Handler {
+	h := &adminHandler{
+		sched:  s,
+		mux:    http.NewServeMux(),
+		logger: s.logger,
+	}
+	h.mux.HandleFunc("GET /tasks", h.list)
+	h.mux.HandleFunc("GET /tasks/{name}", h.get)
+	h.mux.HandleFunc("POST /tasks/{name}/pause", h.control(func(ctx context.Context, j *Task) error {
+		j.Pause()
+		return s.persist(ctx, j)
+	}))
+	h.mux.HandleFunc("POST /tasks/{name}/resume", h.control(func(ctx context.Context, j *Task) error {
+		j.Resume()
+		return s.persist(ctx, j)
+	}))
+	h.mux.HandleFunc("POST /tasks/{name}/trigger", h.control(func(_ context.Context, j *Task) error {
+		s.dispatch(s.runContext(), j, time.Now())
+		return nil
+	}))
+	return h
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *adminHandler) list(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	infos := []TaskInfo{}
+	for _, j := range h.sched.Tasks() {
+		if tag != "" && !hasTag(j, tag) {
+			continue
+		}
+		infos = append(infos, newTaskInfo(h.sched, j, false))
+	}
+	h.writeJSON(w, http.StatusOK, infos)
+}
+
+func (h *adminHandler) get(w http.ResponseWriter, r *http.Request) {
+	tasks := h.sched.TasksByName(r.PathValue("name"))
+	if len(tasks) == 0 {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, newTaskInfo(h.sched, tasks[0], true))
+}
+
+// control 对名称匹配的所有任务执行操作, 只有leader可以操作
+func (h *adminHandler) control(fn func(context.Context, *Task) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.sched.IsLeader() {
+			http.Error(w, "scheduler is not the leader", http.StatusServiceUnavailable)
+			return
+		}
+		tasks := h.sched.TasksByName(r.PathValue("name"))
+		if len(tasks) == 0 {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		infos := make([]TaskInfo, 0, len(tasks))
+		for _, j := range tasks {
+			if err := fn(r.Context(), j); err != nil {
+				h.logger.Warn("save job to store failed", logger.String("job", j.GetName()), logger.Error(err))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			infos = append(infos, newTaskInfo(h.sched, j, false))
+		}
+		h.writeJSON(w, http.StatusOK, infos)
+	}
+}
+
+func (h *adminHandler) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Warn("write schedule admin response failed", logger.Error(err))
+	}
+}
+
+func hasTag(j *Task, tag string) bool {
+	for _, t := range j.Tags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// TasksByName returns the jobs with the name, see Task.Name
+func (s *Scheduler) TasksByName(name string) []*Task {
+	var tasks []*Task
+	for _, j := range s.Tasks() {
+		if j.GetName() == name {
+			tasks = append(tasks, j)
+		}
+	}
+	return tasks
+}
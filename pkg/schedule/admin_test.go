@@ -0,0 +1,236 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AdminHandler(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+	runs := make(chan struct{}, 10)
+	task := sched.Every(5).Minutes().Name("report")
+	task.Tag("daily")
+	assert.Nil(task.DoFunc(func(context.Context) error {
+		runs <- struct{}{}
+		return nil
+	}))
+	assert.Nil(sched.Cron("@hourly").Name("cleanup").DoFunc(func(context.Context) error { return nil }))
+
+	server := httptest.NewServer(http.StripPrefix("/schedule", NewAdminHandler(sched)))
+	defer server.Close()
+
+	do := func(method, path string, v interface{}) int {
+		req, err := http.NewRequest(method, server.URL+"/schedule"+path, nil)
+		assert.Nil(err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(err)
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			assert.Nil(json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	var infos []TaskInfo
+	assert.Equal(http.StatusOK, do(http.MethodGet, "/tasks", &infos))
+	assert.Len(infos, 2)
+	assert.Equal("report", infos[0].Name)
+	assert.Equal("every 5 minutes", infos[0].Spec)
+	assert.Equal([]string{"daily"}, infos[0].Tags)
+	assert.Equal("@hourly", infos[1].Spec)
+
+	assert.Equal(http.StatusOK, do(http.MethodGet, "/tasks?tag=daily", &infos))
+	assert.Len(infos, 1)
+
+	assert.Equal(http.StatusOK, do(http.MethodPost, "/tasks/report/pause", &infos))
+	assert.True(infos[0].Paused)
+	assert.True(task.Paused())
+	assert.Equal(http.StatusOK, do(http.MethodPost, "/tasks/report/resume", &infos))
+	assert.False(task.Paused())
+
+	assert.Equal(http.StatusOK, do(http.MethodPost, "/tasks/report/trigger", nil))
+	<-runs
+	sched.Wait()
+
+	var info TaskInfo
+	assert.Equal(http.StatusOK, do(http.MethodGet, "/tasks/report", &info))
+	assert.Equal(uint64(1), info.Runs)
+	assert.Len(info.History, 1)
+	assert.NotEmpty(info.LastDuration)
+
+	assert.Equal(http.StatusNotFound, do(http.MethodGet, "/tasks/missing", nil))
+	assert.Equal(http.StatusNotFound, do(http.MethodPost, "/tasks/missing/trigger", nil))
+	assert.Equal(http.StatusMethodNotAllowed, do(http.MethodGet, "/tasks/report/pause", nil))
+}
+
+func Test_TaskPause(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+	runs := 0
+	task := sched.Every(1).Hour()
+	assert.Nil(task.DoFunc(func(context.Context) error {
+		runs++
+		return nil
+	}))
+
+	past := time.Now().Add(-time.Second)
+	task.Pause().From(&past)
+	sched.RunPending()
+	sched.Wait()
+	assert.Equal(0, runs)
+	assert.True(task.NextScheduledTime().After(time.Now()))
+
+	task.Resume().From(&past)
+	sched.RunPending()
+	sched.Wait()
+	assert.Equal(1, runs)
+}
+
+func Test_AdminHandlerWhileRunning(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+	task := sched.Every(5).Milliseconds().Name("fast")
+	task.Tag("fast")
+	assert.Nil(task.DoFunc(func(context.Context) error { return nil }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sched.StartContext(ctx)
+	defer func() {
+		cancel()
+		<-done
+		sched.Wait()
+	}()
+
+	server := httptest.NewServer(NewAdminHandler(sched))
+	defer server.Close()
+	// 调度goroutine修改下一次执行时间的同时读取任务信息, 在-race下不应报告数据竞争
+	for i := 0; i < 20; i++ {
+		resp, err := http.Get(server.URL + "/tasks")
+		assert.Nil(err)
+		var infos []TaskInfo
+		assert.Nil(json.NewDecoder(resp.Body).Decode(&infos))
+		resp.Body.Close()
+		assert.Len(infos, 1)
+		assert.Equal([]string{"fast"}, infos[0].Tags)
+		assert.False(infos[0].NextRun.IsZero())
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func adminDo(t *testing.T, server *httptest.Server, method, path string, v interface{}) int {
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	assert.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+func Test_AdminHandlerFunctionName(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+	assert.Nil(sched.Every(1).Hour().Do(functionNameC))
+	server := httptest.NewServer(NewAdminHandler(sched))
+	defer server.Close()
+
+	var infos []TaskInfo
+	assert.Equal(http.StatusOK, adminDo(t, server, http.MethodGet, "/tasks", &infos))
+	assert.Len(infos, 1)
+	name := infos[0].Name
+	assert.Contains(name, "/")
+
+	// 默认名称包含"/", 需要url.PathEscape
+	assert.Equal(http.StatusNotFound, adminDo(t, server, http.MethodGet, "/tasks/"+name, nil))
+	var info TaskInfo
+	assert.Equal(http.StatusOK, adminDo(t, server, http.MethodGet, "/tasks/"+url.PathEscape(name), &info))
+	assert.Equal(name, info.Name)
+	assert.Equal(http.StatusOK, adminDo(t, server, http.MethodPost, "/tasks/"+url.PathEscape(name)+"/pause", nil))
+}
+
+func Test_AdminHandlerLeader(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store := NewMemoryJobStore()
+	elector := NewMemoryElection().NewElector()
+	sched := NewScheduler(WithJobStore(store), WithElector(elector))
+	assert.Nil(sched.Every(1).Hour().Name("job").DoFunc(func(context.Context) error { return nil }))
+	server := httptest.NewServer(NewAdminHandler(sched))
+	defer server.Close()
+
+	// 不是leader时拒绝操作
+	assert.Equal(http.StatusOK, adminDo(t, server, http.MethodGet, "/tasks/job", nil))
+	assert.Equal(http.StatusServiceUnavailable, adminDo(t, server, http.MethodPost, "/tasks/job/trigger", nil))
+	assert.Equal(http.StatusServiceUnavailable, adminDo(t, server, http.MethodPost, "/tasks/job/pause", nil))
+
+	// 暂停和恢复保存到JobStore, 重启或切换leader后保持
+	assert.Nil(elector.Campaign(ctx))
+	sched.leading.Store(true)
+	assert.Equal(http.StatusOK, adminDo(t, server, http.MethodPost, "/tasks/job/pause", nil))
+	record, err := store.Get(ctx, "job")
+	assert.Nil(err)
+	assert.True(record.Paused)
+	assert.Equal(http.StatusOK, adminDo(t, server, http.MethodPost, "/tasks/job/resume", nil))
+	record, err = store.Get(ctx, "job")
+	assert.Nil(err)
+	assert.False(record.Paused)
+}
+
+func Test_AdminHandlerTriggerContext(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+	started := make(chan struct{}, 1)
+	stopped := make(chan error, 1)
+	assert.Nil(sched.Every(1).Hour().Name("job").DoFunc(func(ctx context.Context) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return nil
+	}))
+	server := httptest.NewServer(NewAdminHandler(sched))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sched.StartContext(ctx)
+	// 等待调度goroutine记录调度的context
+	assert.Eventually(func() bool { return sched.runContext() != context.Background() }, time.Second, time.Millisecond)
+	assert.Equal(http.StatusOK, adminDo(t, server, http.MethodPost, "/tasks/job/trigger", nil))
+	<-started
+
+	// 手动触发的执行随调度器停止取消
+	cancel()
+	<-done
+	select {
+	case err := <-stopped:
+		assert.Equal(context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("triggered run not cancelled on stop")
+	}
+	sched.Wait()
+}
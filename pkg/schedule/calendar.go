@@ -0,0 +1,164 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCalendarDays 按日历顺延时最多查找的天数
+const maxCalendarDays = 3660
+
+// Calendar 任务日历, 决定某一天是否允许执行任务, 见Task.Calendar
+type Calendar interface {
+	// Allowed 返回t所在的日期(按t的时区)是否允许执行
+	Allowed(t time.Time) bool
+}
+
+// CalendarFunc 函数形式的Calendar
+type CalendarFunc func(t time.Time) bool
+
+// Allowed implements Calendar
+func (f CalendarFunc) Allowed(t time.Time) bool {
+	return f(t)
+}
+
+// date 不含时区的日期
+type date struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+func dateOf(t time.Time) date {
+	y, m, d := t.Date()
+	return date{y, m, d}
+}
+
+// yearlyHoliday 每年固定日期的假日, 从from年到until年(0表示不限)
+type yearlyHoliday struct {
+	month time.Month
+	day   int
+	from  int
+	until int
+	name  string
+	// except 被EXDATE排除的日期
+	except map[date]bool
+}
+
+// HolidayCalendar 排除指定日期的日历, 可以通过LoadICalendar从iCal文件加载
+type HolidayCalendar struct {
+	mu     sync.RWMutex
+	dates  map[date]string
+	yearly []yearlyHoliday
+}
+
+// NewHolidayCalendar creates a calendar excluding the given dates
+func NewHolidayCalendar(dates ...time.Time) *HolidayCalendar {
+	c := &HolidayCalendar{dates: make(map[date]string)}
+	return c.Exclude("", dates...)
+}
+
+// Exclude 排除指定日期, name是假日名称
+func (c *HolidayCalendar) Exclude(name string, dates ...time.Time) *HolidayCalendar {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range dates {
+		c.dates[dateOf(t)] = name
+	}
+	return c
+}
+
+// ExcludeYearly 每年排除指定的月日, 例如元旦
+func (c *HolidayCalendar) ExcludeYearly(name string, month time.Month, day int) *HolidayCalendar {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.yearly = append(c.yearly, yearlyHoliday{month: month, day: day, name: name})
+	return c
+}
+
+// Holiday returns the name of the holiday on the date of t and whether the date is excluded
+func (c *HolidayCalendar) Holiday(t time.Time) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	d := dateOf(t)
+	if name, ok := c.dates[d]; ok {
+		return name, true
+	}
+	for _, h := range c.yearly {
+		if h.month == d.month && h.day == d.day && d.year >= h.from && (h.until == 0 || d.year <= h.until) && !h.except[d] {
+			return h.name, true
+		}
+	}
+	return "", false
+}
+
+// Allowed implements Calendar, excluded dates are not allowed
+func (c *HolidayCalendar) Allowed(t time.Time) bool {
+	_, excluded := c.Holiday(t)
+	return !excluded
+}
+
+// Len returns the number of excluded dates, yearly holidays are counted once
+func (c *HolidayCalendar) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.dates) + len(c.yearly)
+}
+
+// businessCalendar 周一到周五且不是假日
+type businessCalendar struct {
+	holidays Calendar
+}
+
+// BusinessDays returns a calendar allowing Monday to Friday except the days excluded by holidays,
+// holidays can be nil
+func BusinessDays(holidays Calendar) Calendar {
+	return businessCalendar{holidays: holidays}
+}
+
+func (c businessCalendar) Allowed(t time.Time) bool {
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	return c.holidays == nil || c.holidays.Allowed(t)
+}
+
+// lastBusinessDayCalendar 每月最后一个工作日
+type lastBusinessDayCalendar struct {
+	businessCalendar
+}
+
+// LastBusinessDayOfMonth returns a calendar allowing only the last business day of each month,
+// holidays can be nil
+func LastBusinessDayOfMonth(holidays Calendar) Calendar {
+	return lastBusinessDayCalendar{businessCalendar{holidays: holidays}}
+}
+
+func (c lastBusinessDayCalendar) Allowed(t time.Time) bool {
+	if !c.businessCalendar.Allowed(t) {
+		return false
+	}
+	// 本月之后的日期中不能再有工作日
+	for next := t.AddDate(0, 0, 1); next.Month() == t.Month(); next = next.AddDate(0, 0, 1) {
+		if c.businessCalendar.Allowed(next) {
+			return false
+		}
+	}
+	return true
+}
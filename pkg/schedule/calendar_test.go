@@ -0,0 +1,101 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func utcDate(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func Test_HolidayCalendar(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewHolidayCalendar(utcDate(2024, 3, 29, 0)).
+		Exclude("Labour Day", utcDate(2024, 5, 1, 0)).
+		ExcludeYearly("New Year's Day", time.January, 1)
+	assert.Equal(3, c.Len())
+
+	assert.False(c.Allowed(utcDate(2024, 3, 29, 18)))
+	assert.True(c.Allowed(utcDate(2024, 3, 28, 18)))
+	name, ok := c.Holiday(utcDate(2024, 5, 1, 9))
+	assert.True(ok)
+	assert.Equal("Labour Day", name)
+	name, ok = c.Holiday(utcDate(2031, 1, 1, 0))
+	assert.True(ok)
+	assert.Equal("New Year's Day", name)
+	_, ok = c.Holiday(utcDate(2031, 1, 2, 0))
+	assert.False(ok)
+
+	business := BusinessDays(c)
+	assert.True(business.Allowed(utcDate(2024, 3, 28, 0)))
+	assert.False(business.Allowed(utcDate(2024, 3, 29, 0)))
+	assert.False(business.Allowed(utcDate(2024, 3, 30, 0)))
+	assert.False(business.Allowed(utcDate(2024, 3, 31, 0)))
+	assert.True(BusinessDays(nil).Allowed(utcDate(2024, 3, 29, 0)))
+
+	// 2024年3月31日是周日, 29日是假日
+	last := LastBusinessDayOfMonth(c)
+	assert.True(last.Allowed(utcDate(2024, 3, 28, 23)))
+	assert.False(last.Allowed(utcDate(2024, 3, 27, 0)))
+	assert.False(last.Allowed(utcDate(2024, 3, 29, 0)))
+	assert.True(LastBusinessDayOfMonth(nil).Allowed(utcDate(2024, 3, 29, 0)))
+	assert.True(last.Allowed(utcDate(2024, 4, 30, 0)))
+}
+
+func Test_TaskCalendar(t *testing.T) {
+	assert := assert.New(t)
+	holidays := NewHolidayCalendar(utcDate(2024, 3, 29, 0))
+
+	daily := NewTask(1).Day().Loc(time.UTC).Calendar(BusinessDays(holidays))
+	assert.Equal(utcDate(2024, 3, 28, 18), daily.allowedFrom(utcDate(2024, 3, 28, 18)))
+	assert.Equal(utcDate(2024, 4, 1, 18), daily.allowedFrom(utcDate(2024, 3, 29, 18)))
+	assert.Equal(utcDate(2024, 4, 1, 18), daily.nextAfter(utcDate(2024, 3, 28, 18)))
+
+	monthly := NewTask(1).Day().Loc(time.UTC).Calendar(LastBusinessDayOfMonth(holidays))
+	assert.Equal(utcDate(2024, 3, 28, 18), monthly.allowedFrom(utcDate(2024, 3, 1, 18)))
+	assert.Equal(utcDate(2024, 4, 30, 18), monthly.nextAfter(utcDate(2024, 3, 28, 18)))
+
+	// 周末的执行顺延到周一零点之后的第一个调度时间
+	hourly := NewTask(5).Hours().Loc(time.UTC).Calendar(BusinessDays(nil))
+	assert.Equal(utcDate(2024, 3, 29, 22), hourly.allowedFrom(utcDate(2024, 3, 29, 22)))
+	assert.Equal(utcDate(2024, 4, 1, 0), hourly.nextAfter(utcDate(2024, 3, 29, 22)))
+
+	cron := NewTask(1).Loc(time.UTC).Calendar(CalendarFunc(func(t time.Time) bool {
+		return t.Weekday() == time.Wednesday
+	}))
+	spec, err := parseCron("0 9 * * *")
+	assert.Nil(err)
+	cron.cron = spec
+	assert.Equal(utcDate(2024, 4, 3, 9), cron.nextAfter(utcDate(2024, 3, 29, 9)))
+
+	never := NewTask(1).Day().Calendar(CalendarFunc(func(time.Time) bool { return false }))
+	assert.True(errors.Is(never.scheduleNextRun(), ErrCalendarNeverAllows))
+	assert.True(never.nextAfter(utcDate(2024, 3, 29, 9)).IsZero())
+
+	sched := NewScheduler()
+	task := sched.Every(1).Day().At("18:00").Calendar(BusinessDays(holidays))
+	assert.Nil(task.Do(func() {}))
+	assert.True(BusinessDays(holidays).Allowed(task.NextScheduledTime()))
+	assert.Equal(18, task.NextScheduledTime().Hour())
+}
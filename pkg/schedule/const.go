@@ -21,6 +21,8 @@ import (
 	"time"
 )
 
+// MAXJOBNUM was the max number of jobs of a Scheduler.
+// Deprecated: the Scheduler keeps the jobs in a heap and no longer limits the number of jobs.
 const MAXJOBNUM = 1000
 
 var (
@@ -29,6 +31,16 @@ var (
 	ErrNotAFunction         = errors.New("only functions can be schedule into the job queue")
 	ErrPeriodNotSpecified   = errors.New("unspecified job period")
 	ErrParameterCannotBeNil = errors.New("nil parameters cannot be used with reflection")
+	ErrCronFormat           = errors.New("cron expression format error")
+	ErrCronNeverFires       = errors.New("cron expression never fires")
+	ErrTaskPanic            = errors.New("task panicked")
+	ErrJobNotFound          = errors.New("job not found in store")
+	ErrDuplicateTaskName    = errors.New("duplicate task name with a job store")
+	ErrStaleFence           = errors.New("job record saved by a newer leader")
+	ErrWorkflowCycle        = errors.New("workflow graph contains a cycle")
+	ErrStepFunc             = errors.New("workflow step must be a *Step or TaskFunc")
+	ErrCalendarNeverAllows  = errors.New("calendar never allows the task to run")
+	ErrICalFormat           = errors.New("icalendar format error")
 )
 
 type timeUnit int
@@ -39,10 +51,23 @@ const (
 	hours
 	days
 	weeks
+	milliseconds
 )
 
+var unitNames = map[timeUnit]string{
+	seconds: "seconds",
+	minutes: "minutes",
+	hours:   "hours",
+	days:    "days",
+	weeks:   "weeks",
+
+	milliseconds: "milliseconds",
+}
+
 func (t timeUnit) String() time.Duration {
 	switch t {
+	case milliseconds:
+		return time.Millisecond
 	case seconds:
 		return time.Second
 	case minutes:
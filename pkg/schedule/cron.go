@@ -0,0 +1,266 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec 解析后的cron表达式, 每个字段用bit位表示允许的取值
+type cronSpec struct {
+	expr                                  string // 原始表达式
+	second, minute, hour, dom, month, dow uint64
+	domStar, dowStar                      bool           // 日期和星期字段是否为"*"
+	every                                 time.Duration  // @every 固定间隔
+	loc                                   *time.Location // TZ=前缀指定的时区
+}
+
+type cronField struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	secondField = cronField{0, 59, nil}
+	minuteField = cronField{0, 59, nil}
+	hourField   = cronField{0, 23, nil}
+	domField    = cronField{1, 31, nil}
+	monthField  = cronField{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{0, 6, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
+// parseCron 解析标准5字段(分 时 日 月 周)或6字段(秒 分 时 日 月 周)cron表达式,
+// 支持 * ? , - / 以及月份和星期的英文缩写, @hourly等描述符, "@every 1h30m" 和 "TZ=Asia/Shanghai " 前缀
+func parseCron(expr string) (*cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	spec := &cronSpec{expr: expr}
+	if strings.HasPrefix(expr, "TZ=") || strings.HasPrefix(expr, "CRON_TZ=") {
+		i := strings.IndexAny(expr, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrCronFormat, expr)
+		}
+		loc, err := time.LoadLocation(expr[strings.Index(expr, "=")+1 : i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCronFormat, err)
+		}
+		spec.loc = loc
+		expr = strings.TrimSpace(expr[i:])
+	}
+
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrCronFormat, expr)
+		}
+		spec.every = d
+		return spec, nil
+	}
+	if descriptor, ok := cronDescriptors[strings.ToLower(expr)]; ok {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: %q needs 5 or 6 fields", ErrCronFormat, expr)
+	}
+
+	var err error
+	if spec.second, err = secondField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if spec.minute, err = minuteField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = hourField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if spec.dom, err = domField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if spec.month, err = monthField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	// 周日可以写作0或7
+	if spec.dow, err = (cronField{0, 7, dowField.names}).parse(fields[5]); err != nil {
+		return nil, err
+	}
+	if spec.dow&(1<<7) != 0 {
+		spec.dow = spec.dow&^(1<<7) | 1
+	}
+	spec.domStar = isCronStar(fields[3])
+	spec.dowStar = isCronStar(fields[5])
+	return spec, nil
+}
+
+func isCronStar(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parse 解析一个字段, 返回取值的bit位
+func (f cronField) parse(field string) (uint64, error) {
+	var bitset uint64
+	for _, part := range strings.Split(field, ",") {
+		bits, err := f.parsePart(part)
+		if err != nil {
+			return 0, err
+		}
+		bitset |= bits
+	}
+	return bitset, nil
+}
+
+func (f cronField) parsePart(part string) (uint64, error) {
+	rangePart, step := part, uint(1)
+	if i := strings.Index(part, "/"); i >= 0 {
+		n, err := strconv.ParseUint(part[i+1:], 10, 8)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("%w: bad step in %q", ErrCronFormat, part)
+		}
+		rangePart, step = part[:i], uint(n)
+	}
+
+	var start, end uint
+	switch {
+	case isCronStar(rangePart):
+		start, end = f.min, f.max
+	case strings.Contains(rangePart, "-"):
+		bounds := strings.SplitN(rangePart, "-", 2)
+		var err error
+		if start, err = f.value(bounds[0]); err != nil {
+			return 0, err
+		}
+		if end, err = f.value(bounds[1]); err != nil {
+			return 0, err
+		}
+	default:
+		v, err := f.value(rangePart)
+		if err != nil {
+			return 0, err
+		}
+		start, end = v, v
+		// "5/10" 表示从5开始到最大值
+		if step > 1 {
+			end = f.max
+		}
+	}
+	if start > end {
+		return 0, fmt.Errorf("%w: bad range %q", ErrCronFormat, part)
+	}
+
+	var bitset uint64
+	for v := start; v <= end; v += step {
+		bitset |= 1 << v
+	}
+	return bitset, nil
+}
+
+func (f cronField) value(s string) (uint, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || uint(v) < f.min || uint(v) > f.max {
+		return 0, fmt.Errorf("%w: value %q out of range [%d, %d]", ErrCronFormat, s, f.min, f.max)
+	}
+	return uint(v), nil
+}
+
+// next 返回t之后(不含t)第一个满足表达式的时间, 没有满足的时间时返回零值
+func (c *cronSpec) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Truncate(time.Second).Add(c.every)
+	}
+	if c.loc != nil {
+		return c.nextIn(t.In(c.loc)).In(t.Location())
+	}
+	return c.nextIn(t)
+}
+
+func (c *cronSpec) nextIn(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Second).Add(time.Second)
+	// 最多查找5年, 例如2月30日永远不会满足
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for c.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !c.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for c.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+	for c.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	for c.second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+	return t
+}
+
+// dayMatches 日期和星期都有限制时满足其一即可, 与标准cron一致
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
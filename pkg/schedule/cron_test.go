@@ -0,0 +1,117 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseCron(t *testing.T) {
+	assert := assert.New(t)
+	loc := time.UTC
+	base := time.Date(2024, 3, 15, 10, 7, 30, 0, loc) // Friday
+
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/5 9-17 * * 1-5", time.Date(2024, 3, 15, 10, 10, 0, 0, loc)},
+		{"0 0 * * *", time.Date(2024, 3, 16, 0, 0, 0, 0, loc)},
+		{"30 * * * * *", time.Date(2024, 3, 15, 10, 8, 30, 0, loc)},
+		{"0 9 * * mon", time.Date(2024, 3, 18, 9, 0, 0, 0, loc)},
+		{"0 9 * * 7", time.Date(2024, 3, 17, 9, 0, 0, 0, loc)},
+		{"0 0 1 jan-mar ?", time.Date(2025, 1, 1, 0, 0, 0, 0, loc)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, loc)},
+		{"0 12 1,20 * 1", time.Date(2024, 3, 18, 12, 0, 0, 0, loc)},
+		{"5/20 * * * *", time.Date(2024, 3, 15, 10, 25, 0, 0, loc)},
+		{"@hourly", time.Date(2024, 3, 15, 11, 0, 0, 0, loc)},
+		{"@daily", time.Date(2024, 3, 16, 0, 0, 0, 0, loc)},
+		{"@weekly", time.Date(2024, 3, 17, 0, 0, 0, 0, loc)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, loc)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, loc)},
+		{"@every 1h30m", time.Date(2024, 3, 15, 11, 37, 30, 0, loc)},
+	}
+	for _, c := range cases {
+		spec, err := parseCron(c.expr)
+		assert.Nil(err, c.expr)
+		assert.Equal(c.want, spec.next(base), c.expr)
+	}
+
+	for _, expr := range []string{"", "* * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "@every x", "@every -1s", "TZ=Nowhere/City * * * * *"} {
+		_, err := parseCron(expr)
+		assert.True(errors.Is(err, ErrCronFormat), expr)
+	}
+
+	spec, err := parseCron("0 0 30 2 *")
+	assert.Nil(err)
+	assert.True(spec.next(base).IsZero())
+}
+
+func Test_CronTimezone(t *testing.T) {
+	assert := assert.New(t)
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	assert.Nil(err)
+	base := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC) // 08:00 in Shanghai
+
+	spec, err := parseCron("TZ=Asia/Shanghai 0 9 * * *")
+	assert.Nil(err)
+	next := spec.next(base)
+	assert.Equal(time.Date(2024, 3, 15, 1, 0, 0, 0, time.UTC), next)
+	assert.Equal(time.UTC, next.Location())
+
+	sched := NewScheduler()
+	task := sched.Cron("0 9 * * *").Loc(shanghai)
+	assert.Nil(task.Do(func() {}))
+	next = task.NextScheduledTime().In(shanghai)
+	assert.Equal(9, next.Hour())
+	assert.Equal(0, next.Minute())
+}
+
+func Test_SchedulerCron(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+
+	task := sched.Cron("@every 1h")
+	task.Tag("cron")
+	assert.Nil(task.Do(func() {}))
+	assert.WithinDuration(time.Now().Add(time.Hour), task.NextScheduledTime(), 2*time.Second)
+
+	next, tm := sched.NextRun()
+	assert.Equal(task, next)
+	assert.Equal(task.NextScheduledTime(), tm)
+
+	bad := sched.Cron("* * *")
+	assert.True(errors.Is(bad.Err(), ErrCronFormat))
+	assert.True(errors.Is(bad.Do(func() {}), ErrCronFormat))
+
+	never := sched.Cron("0 0 30 2 *")
+	assert.True(errors.Is(never.scheduleNextRun(), ErrCronNeverFires))
+
+	sched.RemoveByTag("cron")
+	sched.RemoveByRef(bad)
+	sched.RemoveByRef(never)
+	assert.Equal(0, sched.Len())
+
+	task = Cron("*/5 * * * * *")
+	assert.Nil(task.Do(func() {}))
+	assert.Equal(0, task.NextScheduledTime().Second()%5)
+	Clear()
+}
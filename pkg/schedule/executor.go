@@ -0,0 +1,321 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/workpool"
+)
+
+// OverlapPolicy 上一次执行尚未结束时, 本次调度的处理策略
+type OverlapPolicy int
+
+const (
+	// OverlapAllow 允许并发执行, 默认策略
+	OverlapAllow OverlapPolicy = iota
+	// OverlapSkip 跳过本次执行
+	OverlapSkip
+	// OverlapQueue 排队, 上一次结束后立即补执行一次, 多次排队合并为一次
+	OverlapQueue
+)
+
+const (
+	defaultConcurrency = 32
+	poolIdleTimeout    = time.Minute
+	maxRetryBackoff    = time.Minute
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// TaskFunc 类型化的任务函数, ctx在超时或调度器停止时取消
+type TaskFunc func(ctx context.Context) error
+
+// SchedulerOption 调度器配置项
+type SchedulerOption func(*Scheduler)
+
+// WithPool 使用指定协程池执行任务, 调度器不会停止该协程池
+func WithPool(pool workpool.Pool) SchedulerOption {
+	return func(s *Scheduler) {
+		s.pool = pool
+	}
+}
+
+// WithConcurrency 设置默认协程池的最大并发数
+func WithConcurrency(n int) SchedulerOption {
+	return func(s *Scheduler) {
+		if n > 0 {
+			s.concurrency = n
+		}
+	}
+}
+
+// getPoolLocked 首次执行时才创建默认协程池, 避免包初始化时启动goroutine; 调用时持有poolMu
+func (s *Scheduler) getPoolLocked() workpool.Pool {
+	if s.pool == nil {
+		s.pool = workpool.NewDefaultPool("schedule", s.concurrency, poolIdleTimeout)
+		s.ownPool = true
+	}
+	return s.pool
+}
+
+// detachPoolLocked 最后一个StartContext结束且运行队列已提交完时取下默认协程池, 由调用方停止; 调用时持有poolMu
+func (s *Scheduler) detachPoolLocked() workpool.Pool {
+	if !s.stopPool || s.starts > 0 || !s.ownPool {
+		return nil
+	}
+	pool := s.pool
+	s.pool, s.ownPool, s.stopPool = nil, false, false
+	return pool
+}
+
+// releasePool StartContext结束时调用, 最后一个StartContext结束后停止默认协程池.
+// 运行队列中还有任务时由drain提交完后停止, 之后的执行重新创建协程池
+func (s *Scheduler) releasePool() {
+	s.poolMu.Lock()
+	s.starts--
+	s.stopPool = s.starts == 0
+	var pool workpool.Pool
+	if !s.draining {
+		pool = s.detachPoolLocked()
+	}
+	s.poolMu.Unlock()
+	// 已取下的协程池不会再有提交, 在后台等待已提交的任务执行完
+	if pool != nil {
+		go pool.Stop()
+	}
+}
+
+// submit 把任务放入不限长度的运行队列, 由drain goroutine提交到协程池, 调度goroutine不会被繁忙的worker阻塞
+func (s *Scheduler) submit(task workpool.Task) {
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	s.runq = append(s.runq, task)
+	if !s.draining {
+		s.draining = true
+		go s.drain()
+	}
+}
+
+// drain 依次把运行队列中的任务提交到协程池, worker繁忙时在这里等待, 队列为空时退出
+func (s *Scheduler) drain() {
+	for {
+		s.poolMu.Lock()
+		if len(s.runq) == 0 {
+			s.draining = false
+			pool := s.detachPoolLocked()
+			s.poolMu.Unlock()
+			if pool != nil {
+				pool.Stop()
+			}
+			return
+		}
+		task := s.runq[0]
+		s.runq[0] = nil
+		s.runq = s.runq[1:]
+		pool := s.getPoolLocked()
+		s.poolMu.Unlock()
+		pool.Submit(task)
+	}
+}
+
+// dispatch 按重叠策略把任务提交到协程池
+// scheduled为计划执行时间, 用于统计实际开始执行的延迟
+func (s *Scheduler) dispatch(ctx context.Context, j *Task, scheduled time.Time) {
+	s.dispatchN(ctx, j, 1, scheduled)
+}
+
+// dispatchN 提交任务并在同一个worker中依次执行n次
+func (s *Scheduler) dispatchN(ctx context.Context, j *Task, n int, scheduled time.Time) {
+	if n <= 0 || !j.acquire(s) {
+		return
+	}
+	s.running.Add(1)
+	s.submit(func() {
+		defer s.running.Done()
+		// 排队期间调度器已停止或失去leader, 不再开始执行
+		if ctx.Err() != nil {
+			j.abandon()
+			return
+		}
+		for i := 1; i < n; i++ {
+			j.runWithTimeout(ctx, scheduled)
+		}
+		j.execute(ctx, scheduled)
+	})
+}
+
+// Wait 等待所有已提交的任务执行结束
+func (s *Scheduler) Wait() {
+	s.running.Wait()
+}
+
+// acquire 按重叠策略判断本次能否执行
+func (j *Task) acquire(s *Scheduler) bool {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+	if j.metrics == nil {
+		j.metrics = newTaskMetrics(s.metricsScope(), j.GetName())
+	}
+	if j.running > 0 {
+		switch j.overlap {
+		case OverlapSkip:
+			return false
+		case OverlapQueue:
+			j.queued = true
+			return false
+		}
+	}
+	j.running++
+	return true
+}
+
+// abandon 放弃已acquire但未开始的执行, 排队的执行一并跳过
+func (j *Task) abandon() {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+	j.queued = false
+	j.running--
+}
+
+// release 结束一次执行, 有排队时返回true继续执行
+func (j *Task) release() bool {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+	if j.queued {
+		j.queued = false
+		return true
+	}
+	j.running--
+	return false
+}
+
+// execute 带超时执行任务, 直到排队的执行全部完成
+func (j *Task) execute(ctx context.Context, scheduled time.Time) {
+	for {
+		j.runWithTimeout(ctx, scheduled)
+		if !j.release() {
+			return
+		}
+	}
+}
+
+func (j *Task) runWithTimeout(ctx context.Context, scheduled time.Time) {
+	if j.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.timeout)
+		defer cancel()
+	}
+	_ = j.run(ctx, scheduled)
+}
+
+// Running returns whether the task is executing now
+func (j *Task) Running() bool {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+	return j.running > 0
+}
+
+// Timeout sets the deadline of each run, the context passed to the task is cancelled when exceeded
+func (j *Task) Timeout(d time.Duration) *Task {
+	j.timeout = d
+	return j
+}
+
+// Overlap sets the policy applied when the previous run is still executing
+func (j *Task) Overlap(policy OverlapPolicy) *Task {
+	j.overlap = policy
+	return j
+}
+
+// invokeWithRetry 执行任务, 失败时按退避时间重试, 返回执行次数和最后一次的错误
+func (j *Task) invokeWithRetry(ctx context.Context) (int, error) {
+	return retryCall(ctx, j.retries, j.backoff, j.invoke)
+}
+
+// retryCall 执行fn, 失败时按指数退避重试retries次, panic不重试
+func retryCall(ctx context.Context, retries int, backoff time.Duration, fn TaskFunc) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		var perr *panicError
+		if err == nil || errors.As(err, &perr) || attempt > retries {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// invoke 执行一次任务, panic转换为panicError
+func (j *Task) invoke(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r}
+		}
+	}()
+
+	if j.fn != nil {
+		return j.fn(ctx)
+	}
+	fn := j.funcs[j.taskFunc]
+	result, err := callTaskFuncWithParams(fn, withContext(ctx, fn, j.fparams[j.taskFunc]))
+	if err != nil {
+		return err
+	}
+	// 最后一个返回值是error时作为执行结果
+	if n := len(result); n > 0 && result[n-1].Type() == errorType && !result[n-1].IsNil() {
+		return result[n-1].Interface().(error)
+	}
+	return nil
+}
+
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrTaskPanic, e.value)
+}
+
+func (e *panicError) Unwrap() error {
+	return ErrTaskPanic
+}
+
+// withContext 任务函数第一个参数是context.Context时注入ctx
+func withContext(ctx context.Context, fn interface{}, params []interface{}) []interface{} {
+	typ := reflect.TypeOf(fn)
+	if typ == nil || typ.Kind() != reflect.Func || typ.NumIn() != len(params)+1 || typ.In(0) != contextType {
+		return params
+	}
+	return append([]interface{}{ctx}, params...)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/workpool"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TaskContextAndTimeout(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler(WithConcurrency(2))
+
+	cancelled := make(chan error, 1)
+	task := sched.Every(1).Second().Timeout(50 * time.Millisecond)
+	assert.Nil(task.Do(func(ctx context.Context, name string) {
+		assert.Equal("a", name)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+	}, "a"))
+
+	sched.RunAll()
+	select {
+	case err := <-cancelled:
+		assert.Equal(context.DeadlineExceeded, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("task context not cancelled by timeout")
+	}
+	sched.Wait()
+	assert.False(task.Running())
+}
+
+func Test_TaskOverlap(t *testing.T) {
+	assert := assert.New(t)
+	pool := workpool.NewDefaultPool("schedule_test", 8, time.Second)
+	defer pool.Stop()
+	sched := NewScheduler(WithPool(pool))
+
+	run := func(policy OverlapPolicy) int32 {
+		var count int32
+		release := make(chan struct{})
+		task := sched.Every(1).Second().Overlap(policy)
+		assert.Nil(task.Do(func() {
+			atomic.AddInt32(&count, 1)
+			<-release
+		}))
+		for i := 0; i < 3; i++ {
+			sched.RunAll()
+		}
+		time.Sleep(100 * time.Millisecond)
+		assert.True(task.Running())
+		close(release)
+		sched.Wait()
+		sched.RemoveByRef(task)
+		return atomic.LoadInt32(&count)
+	}
+
+	assert.Equal(int32(3), run(OverlapAllow))
+	assert.Equal(int32(1), run(OverlapSkip))
+	assert.Equal(int32(2), run(OverlapQueue))
+}
+
+func Test_StartContext(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+
+	started := make(chan struct{}, 1)
+	stopped := make(chan error, 1)
+	assert.Nil(sched.Every(1).Second().Do(func(ctx context.Context) {
+		started <- struct{}{}
+		<-ctx.Done()
+		stopped <- ctx.Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sched.StartContext(ctx)
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("task not started")
+	}
+	cancel()
+	<-done
+	assert.Equal(context.Canceled, <-stopped)
+	sched.Wait()
+}
+
+func Test_DispatchBusyPool(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler(WithConcurrency(1))
+
+	var runs int32
+	for i := 0; i < 20; i++ {
+		assert.Nil(sched.Every(10).Milliseconds().Name(fmt.Sprintf("slow-%d", i)).Do(func() {
+			atomic.AddInt32(&runs, 1)
+			time.Sleep(100 * time.Millisecond)
+		}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sched.StartContext(ctx)
+	time.Sleep(50 * time.Millisecond)
+	// worker繁忙时调度goroutine不阻塞, 能及时退出
+	start := time.Now()
+	cancel()
+	<-done
+	assert.Less(time.Since(start), 500*time.Millisecond)
+
+	// 排队中尚未开始的执行在停止后不再开始
+	sched.Wait()
+	assert.Less(time.Since(start), time.Second)
+	assert.Greater(atomic.LoadInt32(&runs), int32(0))
+	assert.Eventually(func() bool {
+		sched.poolMu.Lock()
+		defer sched.poolMu.Unlock()
+		return sched.pool == nil && !sched.draining
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_DispatchManyDue(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler(WithConcurrency(2))
+
+	// 同时到期的任务数远大于协程池容量, 每个任务都执行
+	var runs int32
+	for i := 0; i < 100; i++ {
+		task := sched.Every(1).Hour().Name(fmt.Sprintf("due-%d", i))
+		assert.Nil(task.Do(func() {
+			atomic.AddInt32(&runs, 1)
+			time.Sleep(time.Millisecond)
+		}))
+		task.From(&time.Time{})
+	}
+	sched.RunPending()
+	sched.Wait()
+	assert.Equal(int32(100), atomic.LoadInt32(&runs))
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import "time"
+
+// historySize 每个任务保留的最近执行记录数
+const historySize = 10
+
+// RunRecord 一次执行的记录
+type RunRecord struct {
+	Start    time.Time     // 开始时间
+	Duration time.Duration // 耗时, 包括重试等待
+	Attempts int           // 执行次数, 1表示没有重试
+	Err      error         // 最后一次执行的错误
+}
+
+// TaskHistory 任务的执行统计
+type TaskHistory struct {
+	Task         *Task         // 任务
+	Runs         uint64        // 执行次数
+	Failures     uint64        // 失败次数
+	LastRun      time.Time     // 最近一次开始时间
+	LastDuration time.Duration // 最近一次耗时
+	LastErr      error         // 最近一次的错误
+	Records      []RunRecord   // 最近的执行记录, 按时间从旧到新
+}
+
+// record 记录一次执行
+func (j *Task) record(r RunRecord) {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+	h := &j.history
+	h.Runs++
+	if r.Err != nil {
+		h.Failures++
+	}
+	h.LastRun = r.Start
+	h.LastDuration = r.Duration
+	h.LastErr = r.Err
+	if len(h.Records) >= historySize {
+		h.Records = append(h.Records[:0], h.Records[1:]...)
+	}
+	h.Records = append(h.Records, r)
+}
+
+// History returns the run statistics and recent records of the task
+func (j *Task) History() TaskHistory {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+	h := j.history
+	h.Task = j
+	h.Records = append([]RunRecord(nil), j.history.Records...)
+	return h
+}
+
+// History returns the run history of all the scheduled tasks
+func (s *Scheduler) History() []TaskHistory {
+	tasks := s.Tasks()
+	histories := make([]TaskHistory, 0, len(tasks))
+	for _, j := range tasks {
+		histories = append(histories, j.History())
+	}
+	return histories
+}
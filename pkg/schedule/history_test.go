@@ -0,0 +1,121 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TaskDoFuncRetry(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+	errBoom := errors.New("boom")
+
+	calls := 0
+	var failed error
+	task := sched.Every(1).Minute().Retry(2, 10*time.Millisecond).OnError(func(_ *Task, err error) {
+		failed = err
+	})
+	assert.Nil(task.DoFunc(func(ctx context.Context) error {
+		calls++
+		return errBoom
+	}))
+	sched.RunAll()
+	sched.Wait()
+	assert.Equal(3, calls)
+	assert.Equal(errBoom, failed)
+
+	h := task.History()
+	assert.Equal(task, h.Task)
+	assert.Equal(uint64(1), h.Runs)
+	assert.Equal(uint64(1), h.Failures)
+	assert.Equal(errBoom, h.LastErr)
+	assert.True(h.LastDuration >= 30*time.Millisecond)
+	assert.Len(h.Records, 1)
+	assert.Equal(3, h.Records[0].Attempts)
+
+	calls = 0
+	succeeded := 0
+	task.OnSuccess(func(*Task) { succeeded++ })
+	assert.Nil(task.DoFunc(func(ctx context.Context) error {
+		if calls++; calls < 2 {
+			return errBoom
+		}
+		return nil
+	}))
+	sched.RunAll()
+	sched.Wait()
+	assert.Equal(1, succeeded)
+	h = task.History()
+	assert.Equal(uint64(2), h.Runs)
+	assert.Equal(uint64(1), h.Failures)
+	assert.Nil(h.LastErr)
+	assert.Equal(2, h.Records[1].Attempts)
+
+	assert.Equal(ErrNotAFunction, task.DoFunc(nil))
+}
+
+func Test_TaskPanicAndErrorResult(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+
+	var recovered interface{}
+	calls := 0
+	panicking := sched.Every(1).Minute().Retry(3, time.Millisecond).OnPanic(func(_ *Task, v interface{}) {
+		recovered = v
+	})
+	assert.Nil(panicking.DoFunc(func(ctx context.Context) error {
+		calls++
+		panic("oops")
+	}))
+
+	// Do的最后一个返回值为error时记录为失败
+	errResult := errors.New("result")
+	reflected := sched.Every(1).Minute()
+	assert.Nil(reflected.Do(func(s string) error { return errResult }, "a"))
+
+	sched.RunAll()
+	sched.Wait()
+	assert.Equal("oops", recovered)
+	assert.Equal(1, calls)
+	assert.True(errors.Is(panicking.History().LastErr, ErrTaskPanic))
+	assert.Equal(errResult, reflected.History().LastErr)
+
+	histories := sched.History()
+	assert.Len(histories, 2)
+	assert.Equal(panicking, histories[0].Task)
+	for _, h := range histories {
+		assert.Equal(uint64(1), h.Failures)
+	}
+}
+
+func Test_TaskHistorySize(t *testing.T) {
+	assert := assert.New(t)
+	task := NewTask(1)
+	for i := 0; i < historySize+5; i++ {
+		task.record(RunRecord{Attempts: i})
+	}
+	h := task.History()
+	assert.Equal(uint64(historySize+5), h.Runs)
+	assert.Len(h.Records, historySize)
+	assert.Equal(5, h.Records[0].Attempts)
+}
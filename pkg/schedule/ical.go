@@ -0,0 +1,280 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var icalDuration = regexp.MustCompile(`^P(\d+)([DW])`)
+
+// LoadICalendar loads the holidays of an iCalendar (RFC 5545) file, see ParseICalendar
+func LoadICalendar(path string) (*HolidayCalendar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseICalendar(f)
+}
+
+// ParseICalendar 解析iCalendar(RFC 5545)格式的假日列表, 每个VEVENT的日期都被排除.
+// 支持DTSTART/DTEND/DURATION描述的单日或多日事件、按年重复的RRULE(FREQ=YEARLY, 可带UNTIL或COUNT)、
+// EXDATE和STATUS:CANCELLED; 日期按文件中的日期部分计算, 不做时区换算
+func ParseICalendar(r io.Reader) (*HolidayCalendar, error) {
+	lines, err := unfoldICal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewHolidayCalendar()
+	var event *icalEvent
+	found, depth := false, 0
+	for n, line := range lines {
+		name, params, value, ok := splitICalLine(line)
+		if !ok {
+			return nil, fmt.Errorf("%w: line %d: %q", ErrICalFormat, n+1, line)
+		}
+		switch {
+		case name == "BEGIN" && value == "VCALENDAR":
+			found = true
+		case name == "BEGIN" && value == "VEVENT" && event == nil:
+			event = &icalEvent{}
+		case name == "END" && value == "VEVENT" && depth == 0 && event != nil:
+			if err := event.addTo(c); err != nil {
+				return nil, err
+			}
+			event = nil
+		case event == nil:
+		// VEVENT中嵌套的组件, 例如VALARM
+		case name == "BEGIN":
+			depth++
+		case name == "END":
+			depth--
+		case depth == 0:
+			if err := event.set(name, params, value); err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrICalFormat, n+1, err)
+			}
+		}
+	}
+	if !found || event != nil {
+		return nil, fmt.Errorf("%w: missing VCALENDAR or END:VEVENT", ErrICalFormat)
+	}
+	return c, nil
+}
+
+// unfoldICal 读取内容行, 以空格或制表符开头的行是上一行的折行
+func unfoldICal(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+		case (line[0] == ' ' || line[0] == '\t') && len(lines) > 0:
+			lines[len(lines)-1] += line[1:]
+		default:
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// splitICalLine 拆分 name;param=value:value 形式的内容行, 参数值可以用双引号包含冒号
+func splitICalLine(line string) (string, map[string]string, string, bool) {
+	quoted, colon := false, -1
+	for i := 0; i < len(line) && colon < 0; i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				colon = i
+			}
+		}
+	}
+	if colon <= 0 {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string, len(parts)-1)
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+// icalEvent 一个VEVENT中与日期有关的属性
+type icalEvent struct {
+	summary   string
+	start     time.Time
+	end       time.Time
+	allDayEnd bool
+	days      int
+	rrule     string
+	exdates   []time.Time
+	cancelled bool
+}
+
+func (e *icalEvent) set(name string, params map[string]string, value string) error {
+	var err error
+	switch name {
+	case "SUMMARY":
+		e.summary = unescapeICalText(value)
+	case "DTSTART":
+		e.start, _, err = parseICalDate(value)
+	case "DTEND":
+		e.end, e.allDayEnd, err = parseICalDate(value)
+	case "DURATION":
+		if m := icalDuration.FindStringSubmatch(value); m != nil {
+			e.days, _ = strconv.Atoi(m[1])
+			if m[2] == "W" {
+				e.days *= 7
+			}
+		}
+	case "RRULE":
+		e.rrule = value
+	case "EXDATE":
+		for _, v := range strings.Split(value, ",") {
+			t, _, err := parseICalDate(v)
+			if err != nil {
+				return err
+			}
+			e.exdates = append(e.exdates, t)
+		}
+	case "STATUS":
+		e.cancelled = strings.EqualFold(value, "CANCELLED")
+	}
+	return err
+}
+
+// addTo 把事件覆盖的日期加入日历
+func (e *icalEvent) addTo(c *HolidayCalendar) error {
+	if e.cancelled {
+		return nil
+	}
+	if e.start.IsZero() {
+		return fmt.Errorf("%w: VEVENT without DTSTART", ErrICalFormat)
+	}
+
+	// 全天事件的DTEND不包含在内
+	days := max(e.days, 1)
+	if !e.end.IsZero() {
+		days = int(e.end.Sub(e.start).Hours() / 24)
+		if !e.allDayEnd {
+			days++
+		}
+		days = max(days, 1)
+	}
+
+	if e.rrule == "" {
+		for i := 0; i < days; i++ {
+			c.Exclude(e.summary, e.start.AddDate(0, 0, i))
+		}
+		return nil
+	}
+
+	years, err := e.yearlyRange()
+	if err != nil {
+		return err
+	}
+	except := make(map[date]bool, len(e.exdates))
+	for _, t := range e.exdates {
+		except[dateOf(t)] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < days; i++ {
+		d := e.start.AddDate(0, 0, i)
+		// 跨年的多日事件, 后一年的日期的截止年份相应顺延
+		shift := d.Year() - e.start.Year()
+		h := yearlyHoliday{month: d.Month(), day: d.Day(), from: d.Year(), name: e.summary, except: except}
+		if years > 0 {
+			h.until = e.start.Year() + years - 1 + shift
+		}
+		c.yearly = append(c.yearly, h)
+	}
+	return nil
+}
+
+// yearlyRange 解析RRULE, 只支持FREQ=YEARLY, 返回重复的年数, 0表示不限
+func (e *icalEvent) yearlyRange() (int, error) {
+	years := 0
+	for _, part := range strings.Split(e.rrule, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			if !strings.EqualFold(v, "YEARLY") {
+				return 0, fmt.Errorf("%w: unsupported RRULE %s", ErrICalFormat, e.rrule)
+			}
+		case "INTERVAL":
+			if v != "1" {
+				return 0, fmt.Errorf("%w: unsupported RRULE %s", ErrICalFormat, e.rrule)
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%w: invalid RRULE %s", ErrICalFormat, e.rrule)
+			}
+			years = n
+		case "UNTIL":
+			until, _, err := parseICalDate(v)
+			if err != nil {
+				return 0, err
+			}
+			years = until.Year() - e.start.Year() + 1
+			if until.Month() < e.start.Month() || (until.Month() == e.start.Month() && until.Day() < e.start.Day()) {
+				years--
+			}
+			if years <= 0 {
+				return 0, fmt.Errorf("%w: invalid RRULE %s", ErrICalFormat, e.rrule)
+			}
+		case "WKST":
+		default:
+			return 0, fmt.Errorf("%w: unsupported RRULE %s", ErrICalFormat, e.rrule)
+		}
+	}
+	return years, nil
+}
+
+// parseICalDate 解析DATE或DATE-TIME值的日期部分, 第二个返回值表示日期是否不包含在事件内,
+// 即值是DATE或零点的DATE-TIME
+func parseICalDate(value string) (time.Time, bool, error) {
+	if len(value) < 8 {
+		return time.Time{}, false, fmt.Errorf("%w: invalid date %q", ErrICalFormat, value)
+	}
+	t, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: invalid date %q", ErrICalFormat, value)
+	}
+	return t, len(value) == 8 || strings.HasPrefix(value[8:], "T000000"), nil
+}
+
+// unescapeICalText 还原TEXT值中的转义字符
+func unescapeICalText(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
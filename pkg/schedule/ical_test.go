@@ -0,0 +1,153 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testHolidays = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//kubeservice//holidays//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:new-year\r\n" +
+	"DTSTART;VALUE=DATE:20240101\r\n" +
+	"DTEND;VALUE=DATE:20240102\r\n" +
+	"SUMMARY:New Year's Day\r\n" +
+	"RRULE:FREQ=YEARLY\r\n" +
+	"EXDATE;VALUE=DATE:20280101\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20241001\r\n" +
+	"DTEND;VALUE=DATE:20241008\r\n" +
+	"SUMMARY:National\r\n" +
+	"  Day\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DTSTART:19990101T000000\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=\"Asia/Shanghai\":20240329T090000\r\n" +
+	"DURATION:P1D\r\n" +
+	"SUMMARY:Good Friday\\, observed\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20241225\r\n" +
+	"SUMMARY:Christmas\r\n" +
+	"RRULE:FREQ=YEARLY;COUNT=2\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20240501\r\n" +
+	"SUMMARY:Cancelled\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20241231T000000Z\r\n" +
+	"DTEND:20250102T000000Z\r\n" +
+	"SUMMARY:Year end\r\n" +
+	"RRULE:FREQ=YEARLY;UNTIL=20251231\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func Test_ParseICalendar(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := ParseICalendar(strings.NewReader(testHolidays))
+	assert.Nil(err)
+
+	holiday := func(year int, month time.Month, d int) string {
+		name, ok := c.Holiday(utcDate(year, month, d, 0))
+		if !ok {
+			return ""
+		}
+		return name
+	}
+	assert.Equal("New Year's Day", holiday(2024, 1, 1))
+	assert.Equal("New Year's Day", holiday(2030, 1, 1))
+	assert.Equal("", holiday(2023, 1, 1))
+	assert.Equal("", holiday(2028, 1, 1))
+	assert.Equal("", holiday(2024, 1, 2))
+
+	assert.Equal("National Day", holiday(2024, 10, 1))
+	assert.Equal("National Day", holiday(2024, 10, 7))
+	assert.Equal("", holiday(2024, 10, 8))
+	assert.Equal("", holiday(1999, 1, 1))
+
+	assert.Equal("Good Friday, observed", holiday(2024, 3, 29))
+	assert.Equal("", holiday(2024, 3, 30))
+
+	assert.Equal("Christmas", holiday(2024, 12, 25))
+	assert.Equal("Christmas", holiday(2025, 12, 25))
+	assert.Equal("", holiday(2026, 12, 25))
+
+	assert.Equal("", holiday(2024, 5, 1))
+
+	assert.Equal("Year end", holiday(2024, 12, 31))
+	assert.Equal("Year end", holiday(2025, 12, 31))
+	assert.Equal("", holiday(2026, 12, 31))
+
+	// 2024年10月1日至7日是假日, 9月最后一个工作日不受影响
+	assert.False(BusinessDays(c).Allowed(utcDate(2024, 10, 7, 0)))
+	assert.True(LastBusinessDayOfMonth(c).Allowed(utcDate(2024, 9, 30, 0)))
+}
+
+func Test_ParseICalendarInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	event := func(lines ...string) string {
+		return "BEGIN:VCALENDAR\nBEGIN:VEVENT\n" + strings.Join(lines, "\n") + "\nEND:VEVENT\nEND:VCALENDAR\n"
+	}
+	for _, content := range []string{
+		"",
+		"BEGIN:VEVENT\nDTSTART:20240101\nEND:VEVENT\n",
+		"BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:20240101\n",
+		event("DTSTART:20240101", "no colon"),
+		event("SUMMARY:no start"),
+		event("DTSTART:2024"),
+		event("DTSTART:2024AB01"),
+		event("DTSTART:20240101", "EXDATE:20240101,bad"),
+		event("DTSTART:20240101", "RRULE:FREQ=WEEKLY"),
+		event("DTSTART:20240101", "RRULE:FREQ=YEARLY;INTERVAL=2"),
+		event("DTSTART:20240101", "RRULE:FREQ=YEARLY;BYMONTH=1"),
+		event("DTSTART:20240101", "RRULE:FREQ=YEARLY;COUNT=0"),
+		event("DTSTART:20240601", "RRULE:FREQ=YEARLY;UNTIL=20240101"),
+	} {
+		_, err := ParseICalendar(strings.NewReader(content))
+		assert.True(errors.Is(err, ErrICalFormat), content)
+	}
+}
+
+func Test_LoadICalendar(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "holidays.ics")
+	assert.Nil(os.WriteFile(path, []byte(testHolidays), 0o600))
+	c, err := LoadICalendar(path)
+	assert.Nil(err)
+	assert.False(c.Allowed(utcDate(2024, 12, 25, 12)))
+
+	_, err = LoadICalendar(filepath.Join(t.TempDir(), "missing.ics"))
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+const (
+	campaignRetryInterval = time.Second
+	resignTimeout         = 3 * time.Second
+)
+
+// Elector 选主接口, discovery.Election实现了该接口
+type Elector interface {
+	Campaign(ctx context.Context) error // 阻塞直到成为leader或ctx结束
+	Resign(ctx context.Context) error   // 放弃leader
+	LeaderContext() context.Context     // 失去leader后被cancel, 不是leader时返回已cancel的context
+}
+
+// fencer 可选接口, elector实现Rev时以它作为fencing token写入JobStore, discovery.Election实现了该接口
+type fencer interface {
+	Rev() int64 // 当前leader任期的token, 随每次选举单调递增; 不是leader时为0
+}
+
+// WithElector 只有选举成为leader的调度器执行任务, 失去leader后重新参与选举.
+// 配合共享的JobStore使用: 执行前先保存下一次执行时间, 新leader从JobStore恢复, 同一次执行不会在多个副本上重复.
+// elector提供fencing token(Rev)时, lease过期后旧leader的保存被JobStore以ErrStaleFence拒绝, 不会再执行
+func WithElector(elector Elector) SchedulerOption {
+	return func(s *Scheduler) {
+		s.elector = elector
+	}
+}
+
+// IsLeader returns whether the scheduler is running jobs as the leader, always true without elector
+func (s *Scheduler) IsLeader() bool {
+	if s.elector == nil {
+		return true
+	}
+	return s.leading.Load()
+}
+
+// fence 当前leader的fencing token, 没有elector或elector不提供token时为0
+func (s *Scheduler) fence() int64 {
+	if f, ok := s.elector.(fencer); ok {
+		return f.Rev()
+	}
+	return 0
+}
+
+// stillLeader 保存执行时间后再次确认仍是leader, 避免保存期间lease过期
+func (s *Scheduler) stillLeader() bool {
+	return s.elector == nil || s.elector.LeaderContext().Err() == nil
+}
+
+// lead 循环参与选举, 成为leader后从JobStore恢复并执行任务, 直到失去leader或ctx结束
+func (s *Scheduler) lead(ctx context.Context) {
+	for {
+		if err := s.elector.Campaign(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Warn("scheduler campaign failed", logger.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(campaignRetryInterval):
+			}
+			continue
+		}
+
+		leaderCtx, cancel := mergeContext(ctx, s.elector.LeaderContext())
+		s.leading.Store(true)
+		s.logger.Info("scheduler became leader")
+		if err := s.Restore(leaderCtx); err != nil {
+			s.logger.Error("restore jobs from store failed", logger.Error(err))
+		}
+		s.tick(leaderCtx)
+		s.leading.Store(false)
+		cancel()
+
+		if ctx.Err() != nil {
+			resignCtx, cancelResign := context.WithTimeout(context.Background(), resignTimeout)
+			if err := s.elector.Resign(resignCtx); err != nil {
+				s.logger.Warn("scheduler resign failed", logger.Error(err))
+			}
+			cancelResign()
+			return
+		}
+		s.logger.Warn("scheduler leadership lost")
+	}
+}
+
+// mergeContext 任一context结束时返回的context结束
+func mergeContext(ctx, other context.Context) (context.Context, context.CancelFunc) {
+	merged, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(other, cancel)
+	return merged, func() {
+		stop()
+		cancel()
+	}
+}
+
+// MemoryElection 进程内选举, 用于测试和同一进程中的多个调度器
+type MemoryElection struct {
+	mu      sync.Mutex
+	leader  *memoryElector
+	rev     int64 // 每次选出leader时递增, 作为fencing token
+	changed chan struct{}
+}
+
+// NewMemoryElection creates an in-process election
+func NewMemoryElection() *MemoryElection {
+	return &MemoryElection{changed: make(chan struct{})}
+}
+
+// NewElector creates a candidate of the election
+func (e *MemoryElection) NewElector() Elector {
+	return &memoryElector{election: e}
+}
+
+type memoryElector struct {
+	election *MemoryElection
+	rev      int64
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func (m *memoryElector) Campaign(ctx context.Context) error {
+	e := m.election
+	for {
+		e.mu.Lock()
+		if e.leader == nil || e.leader == m {
+			if e.leader == nil {
+				m.ctx, m.cancel = context.WithCancel(context.Background())
+				e.rev++
+				m.rev = e.rev
+				e.leader = m
+			}
+			e.mu.Unlock()
+			return nil
+		}
+		changed := e.changed
+		e.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (m *memoryElector) Resign(context.Context) error {
+	e := m.election
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader != m {
+		return nil
+	}
+	m.cancel()
+	e.leader = nil
+	close(e.changed)
+	e.changed = make(chan struct{})
+	return nil
+}
+
+// Rev 成为leader时的fencing token, 不是leader时为0
+func (m *memoryElector) Rev() int64 {
+	e := m.election
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader == m {
+		return m.rev
+	}
+	return 0
+}
+
+func (m *memoryElector) LeaderContext() context.Context {
+	e := m.election
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader == m {
+		return m.ctx
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MemoryElection(t *testing.T) {
+	assert := assert.New(t)
+	election := NewMemoryElection()
+	e1, e2 := election.NewElector(), election.NewElector()
+
+	assert.Nil(e1.Campaign(context.Background()))
+	assert.Nil(e1.Campaign(context.Background()))
+	assert.Nil(e1.LeaderContext().Err())
+	assert.NotNil(e2.LeaderContext().Err())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, e2.Campaign(ctx))
+
+	elected := make(chan error, 1)
+	go func() { elected <- e2.Campaign(context.Background()) }()
+	leaderCtx := e1.LeaderContext()
+	assert.Nil(e2.Resign(context.Background()))
+	assert.Nil(e1.Resign(context.Background()))
+	assert.NotNil(leaderCtx.Err())
+	assert.Nil(<-elected)
+	assert.Nil(e2.LeaderContext().Err())
+}
+
+func Test_SchedulerLeaderFailover(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryJobStore()
+	election := NewMemoryElection()
+
+	var mu sync.Mutex
+	runs := map[int64]string{}
+	duplicated := false
+	newReplica := func(name string) (*Scheduler, Elector) {
+		elector := election.NewElector()
+		sched := NewScheduler(WithJobStore(store), WithElector(elector))
+		assert.Nil(sched.Every(1).Second().Name("job").DoFunc(func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			sec := time.Now().Unix()
+			if _, ok := runs[sec]; ok {
+				duplicated = true
+			}
+			runs[sec] = name
+			return nil
+		}))
+		return sched, elector
+	}
+
+	s1, e1 := newReplica("s1")
+	s2, e2 := newReplica("s2")
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	done1 := s1.StartContext(ctx1)
+	time.Sleep(100 * time.Millisecond)
+	done2 := s2.StartContext(ctx2)
+	defer func() {
+		cancel2()
+		<-done2
+	}()
+
+	time.Sleep(2500 * time.Millisecond)
+	assert.True(s1.IsLeader())
+	assert.False(s2.IsLeader())
+	s2.RunPending()
+
+	// s1停止后放弃leader, s2接管
+	cancel1()
+	<-done1
+	assert.NotNil(e1.LeaderContext().Err())
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(s2.IsLeader())
+
+	// 模拟失去leader, s2重新参与选举后继续执行
+	assert.Nil(e2.Resign(context.Background()))
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(s2.IsLeader())
+	s1.Wait()
+	s2.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.False(duplicated)
+	byReplica := map[string]int{}
+	for _, name := range runs {
+		byReplica[name]++
+	}
+	assert.True(byReplica["s1"] >= 1, runs)
+	assert.True(byReplica["s2"] >= 1, runs)
+}
+
+// hookJobStore 保存前执行hook, 用于模拟保存期间leader切换
+type hookJobStore struct {
+	JobStore
+	beforeSave func()
+}
+
+func (h *hookJobStore) Save(ctx context.Context, record JobRecord) error {
+	if hook := h.beforeSave; hook != nil {
+		h.beforeSave = nil
+		hook()
+	}
+	return h.JobStore.Save(ctx, record)
+}
+
+func Test_SchedulerLeaseLostMidTick(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	newLeader := func(store JobStore, elector Elector) (*Scheduler, *Task, *int32) {
+		var runs int32
+		sched := NewScheduler(WithJobStore(store), WithElector(elector))
+		task := sched.Every(1).Hour().Name("job")
+		assert.Nil(task.DoFunc(func(context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}))
+		assert.Nil(elector.Campaign(ctx))
+		sched.leading.Store(true)
+		assert.Nil(sched.Restore(ctx))
+		return sched, task, &runs
+	}
+
+	// lease在保存执行时间时过期, 新leader已从store恢复: 旧leader的保存因fencing token过小被拒绝
+	store := NewMemoryJobStore()
+	election := NewMemoryElection()
+	e1, e2 := election.NewElector(), election.NewElector()
+	hook := &hookJobStore{JobStore: store}
+	s1, task, runs := newLeader(hook, e1)
+	assert.Equal(int64(1), e1.(fencer).Rev())
+	hook.beforeSave = func() {
+		assert.Nil(e1.Resign(ctx))
+		assert.Nil(e2.Campaign(ctx))
+		assert.Nil(store.Save(ctx, JobRecord{Name: "job", Fence: e2.(fencer).Rev()}))
+	}
+	task.From(&time.Time{})
+	s1.RunPendingContext(ctx)
+	s1.Wait()
+	assert.Equal(int32(0), atomic.LoadInt32(runs))
+	record, err := store.Get(ctx, "job")
+	assert.Nil(err)
+	assert.Equal(int64(2), record.Fence)
+	assert.True(record.NextRun.IsZero())
+	assert.ErrorIs(store.Save(ctx, JobRecord{Name: "job", Fence: 1}), ErrStaleFence)
+
+	// elector不提供fencing token时, 保存后再次确认leader
+	election = NewMemoryElection()
+	e1 = election.NewElector()
+	hook = &hookJobStore{JobStore: NewMemoryJobStore()}
+	s1, task, runs = newLeader(hook, struct{ Elector }{e1})
+	hook.beforeSave = func() { assert.Nil(e1.Resign(ctx)) }
+	task.From(&time.Time{})
+	s1.RunPendingContext(ctx)
+	s1.Wait()
+	assert.Equal(int32(0), atomic.LoadInt32(runs))
+
+	// 仍是leader时正常执行
+	s2, task, runs := newLeader(NewMemoryJobStore(), election.NewElector())
+	task.From(&time.Time{})
+	s2.RunPendingContext(ctx)
+	s2.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(runs))
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func countingFunc(counter *int32) TaskFunc {
+	return func(context.Context) error {
+		atomic.AddInt32(counter, 1)
+		return nil
+	}
+}
+
+func Test_SchedulerOnce(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+
+	var runs int32
+	at := time.Now().Add(30 * time.Millisecond)
+	task := sched.Once(at)
+	assert.Nil(task.DoFunc(countingFunc(&runs)))
+	assert.True(at.Equal(task.NextScheduledTime()))
+	assert.Equal("once at "+at.Format(time.RFC3339), task.Spec())
+
+	sched.RunPending()
+	assert.Equal(int32(0), atomic.LoadInt32(&runs))
+	time.Sleep(50 * time.Millisecond)
+	sched.RunPending()
+	sched.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(&runs))
+	assert.Equal(0, sched.Len())
+
+	// 暂停期间到期, 恢复后执行
+	var paused int32
+	past := time.Now().Add(-time.Second)
+	task = Once(past).Pause()
+	assert.Nil(task.DoFunc(countingFunc(&paused)))
+	RunPending()
+	assert.Equal(1, defaultScheduler.Len())
+	assert.Equal(int32(0), atomic.LoadInt32(&paused))
+	task.Resume()
+	RunPending()
+	defaultScheduler.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(&paused))
+	assert.Equal(0, defaultScheduler.Len())
+}
+
+func Test_TaskLimitAndUntil(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+
+	var limited, until int32
+	assert.Nil(sched.Every(20).Milliseconds().Limit(2).DoFunc(countingFunc(&limited)))
+	assert.Nil(sched.Every(20).Milliseconds().Until(time.Now().Add(70 * time.Millisecond)).DoFunc(countingFunc(&until)))
+	expired := sched.Every(10).Milliseconds().Until(time.Now().Add(-time.Second))
+	assert.Nil(expired.DoFunc(countingFunc(&until)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sched.StartContext(ctx)
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+	<-done
+	sched.Wait()
+
+	assert.Equal(int32(2), atomic.LoadInt32(&limited))
+	assert.True(atomic.LoadInt32(&until) >= 2, until)
+	assert.True(atomic.LoadInt32(&until) <= 4, until)
+	assert.Equal(0, sched.Len())
+}
+
+func Test_TaskJitter(t *testing.T) {
+	assert := assert.New(t)
+	for i := 0; i < 20; i++ {
+		task := NewTask(1).Hour().Jitter(time.Minute)
+		assert.Nil(task.DoFunc(func(context.Context) error { return nil }))
+		next := task.NextScheduledTime()
+		assert.True(!next.Before(task.lastRun.Add(time.Hour)), next)
+		assert.True(next.Before(task.lastRun.Add(time.Hour+time.Minute)), next)
+		assert.True(task.jittered < time.Minute)
+	}
+}
+
+func Test_LifecycleRestore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store := NewMemoryJobStore()
+
+	var runs int32
+	at := time.Now().Add(-time.Second)
+	sched := NewScheduler(WithJobStore(store))
+	assert.Nil(sched.Once(at).Name("migrate").DoFunc(countingFunc(&runs)))
+	assert.Nil(sched.Every(1).Hour().Name("paused").DoFunc(countingFunc(&runs)))
+	assert.Nil(sched.Restore(ctx))
+	sched.TasksByName("paused")[0].Pause()
+	sched.RunPending()
+	sched.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(&runs))
+
+	record, err := store.Get(ctx, "migrate")
+	assert.Nil(err)
+	assert.True(record.Finished)
+	assert.Equal(1, record.Runs)
+
+	// 重启后已完成的一次性任务不再执行, 超过上限的任务只补执行剩余次数
+	paused := sched.TasksByName("paused")[0]
+	past := time.Now().Add(-3 * time.Hour)
+	paused.From(&past)
+	assert.Nil(sched.persist(ctx, paused))
+	assert.Nil(store.Save(ctx, JobRecord{Name: "limited", NextRun: time.Now().Add(-5 * time.Hour), Runs: 1}))
+
+	restarted := NewScheduler(WithJobStore(store), WithCatchUp(CatchUpAll))
+	assert.Nil(restarted.Once(at).Name("migrate").DoFunc(countingFunc(&runs)))
+	assert.Nil(restarted.Every(1).Hour().Name("paused").DoFunc(countingFunc(&runs)))
+	assert.Nil(restarted.Every(1).Hour().Name("limited").Limit(3).DoFunc(countingFunc(&runs)))
+	assert.Nil(restarted.Restore(ctx))
+	restarted.Wait()
+
+	assert.Equal(int32(3), atomic.LoadInt32(&runs))
+	assert.Empty(restarted.TasksByName("migrate"))
+	assert.Empty(restarted.TasksByName("limited"))
+	assert.True(restarted.TasksByName("paused")[0].Paused())
+	record, err = store.Get(ctx, "limited")
+	assert.Nil(err)
+	assert.True(record.Finished)
+	assert.Equal(3, record.Runs)
+
+	// 错过且跳过的一次性任务不再执行
+	skipped := NewScheduler(WithJobStore(NewMemoryJobStore()), WithCatchUp(CatchUpSkip))
+	assert.Nil(skipped.store.Save(ctx, JobRecord{Name: "once", NextRun: at}))
+	assert.Nil(skipped.Once(at).Name("once").DoFunc(countingFunc(&runs)))
+	assert.Nil(skipped.Restore(ctx))
+	skipped.RunPending()
+	skipped.Wait()
+	assert.Equal(int32(3), atomic.LoadInt32(&runs))
+	assert.Equal(0, skipped.Len())
+}
+
+func Test_RescheduleWhileRunning(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+	task := sched.Every(5).Milliseconds()
+	assert.Nil(task.DoFunc(func(context.Context) error { return nil }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sched.StartContext(ctx)
+	// 调度goroutine运行期间修改执行时间和新增任务, 在-race下不应报告数据竞争
+	for i := 0; i < 20; i++ {
+		next := time.Now().Add(time.Duration(i) * time.Millisecond)
+		task.From(&next)
+		assert.Nil(sched.Every(5).Milliseconds().Name(fmt.Sprintf("late-%d", i)).DoFunc(func(context.Context) error { return nil }))
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+	sched.Wait()
+
+	_, next := sched.NextRun()
+	assert.False(next.IsZero())
+	assert.Equal(21, sched.Len())
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"errors"
+	"time"
+
+	"github.com/uber-go/tally"
+
+	"github.com/kubeservice-stack/common/pkg/metrics"
+)
+
+// durationBuckets 执行耗时和延迟的分桶, 1ms到约9分钟
+var durationBuckets = tally.MustMakeExponentialDurationBuckets(time.Millisecond, 2, 20)
+
+// taskMetrics 单个任务的监控指标, 以task标签区分
+type taskMetrics struct {
+	runs     tally.Counter
+	failures tally.Counter
+	panics   tally.Counter
+	duration tally.Histogram
+	lag      tally.Histogram
+}
+
+func newTaskMetrics(scope tally.Scope, name string) *taskMetrics {
+	scope = scope.SubScope("schedule").Tagged(map[string]string{"task": name})
+	return &taskMetrics{
+		runs:     scope.Counter("runs"),
+		failures: scope.Counter("failures"),
+		panics:   scope.Counter("panics"),
+		duration: scope.Histogram("duration", durationBuckets),
+		lag:      scope.Histogram("lag", durationBuckets),
+	}
+}
+
+// WithMetricsScope 设置任务监控指标的tally scope, 默认metrics.DefaultTallyScope
+func WithMetricsScope(scope tally.Scope) SchedulerOption {
+	return func(s *Scheduler) {
+		s.scope = scope
+	}
+}
+
+func (s *Scheduler) metricsScope() tally.Scope {
+	if s.scope != nil {
+		return s.scope
+	}
+	return metrics.DefaultTallyScope.Scope
+}
+
+// observe 记录一次执行的指标
+func (j *Task) observe(lag, duration time.Duration, err error) {
+	m := j.metrics
+	if m == nil {
+		return
+	}
+	m.runs.Inc(1)
+	m.lag.RecordDuration(lag)
+	m.duration.RecordDuration(duration)
+	var perr *panicError
+	switch {
+	case errors.As(err, &perr):
+		m.panics.Inc(1)
+		m.failures.Inc(1)
+	case err != nil:
+		m.failures.Inc(1)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+
+	"github.com/kubeservice-stack/common/pkg/tracing"
+)
+
+func Test_TaskMetricsAndTracing(t *testing.T) {
+	assert := assert.New(t)
+	scope := tally.NewTestScope("", nil)
+	sched := NewScheduler(WithMetricsScope(scope))
+
+	fails := true
+	task := sched.Every(1).Minute().Name("billing")
+	assert.Nil(task.DoFunc(func(context.Context) error {
+		if fails {
+			return errors.New("boom")
+		}
+		return nil
+	}))
+
+	tracer := mocktracer.New()
+	ctx := tracing.ContextWithTracer(context.Background(), tracer)
+	past := time.Now().Add(-50 * time.Millisecond)
+	task.From(&past)
+	sched.RunPendingContext(ctx)
+	sched.Wait()
+	fails = false
+	sched.RunAll()
+	sched.Wait()
+
+	snapshot := scope.Snapshot()
+	counters := snapshot.Counters()
+	assert.Equal(int64(2), counters["schedule.runs+task=billing"].Value())
+	assert.Equal(int64(1), counters["schedule.failures+task=billing"].Value())
+	assert.Equal(int64(0), counters["schedule.panics+task=billing"].Value())
+
+	histograms := snapshot.Histograms()
+	var lagged, durations int64
+	for bucket, count := range histograms["schedule.lag+task=billing"].Durations() {
+		if bucket >= 50*time.Millisecond {
+			lagged += count
+		}
+	}
+	for _, count := range histograms["schedule.duration+task=billing"].Durations() {
+		durations += count
+	}
+	assert.Equal(int64(1), lagged)
+	assert.Equal(int64(2), durations)
+
+	spans := tracer.FinishedSpans()
+	assert.Len(spans, 1)
+	assert.Equal("schedule.run", spans[0].OperationName)
+	assert.Equal("billing", spans[0].Tag("task"))
+	assert.Equal(1, spans[0].Tag("attempts"))
+	assert.Equal(true, spans[0].Tag("error"))
+}
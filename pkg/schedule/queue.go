@@ -0,0 +1,55 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import "time"
+
+// maxTickInterval 没有到期任务时最长的等待时间, 用于应对系统时间跳变
+const maxTickInterval = time.Second
+
+// taskQueue 按下一次执行时间排序的最小堆, 实现heap.Interface
+type taskQueue []*Task
+
+func (q taskQueue) Len() int {
+	return len(q)
+}
+
+func (q taskQueue) Less(i, j int) bool {
+	return q[i].nextRun.Before(q[j].nextRun)
+}
+
+func (q taskQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *taskQueue) Push(x interface{}) {
+	j := x.(*Task)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	j.index = -1
+	*q = old[:n-1]
+	return j
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SchedulerQueueOrder(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+	now := time.Now()
+
+	tasks := make([]*Task, 0, MAXJOBNUM+10)
+	for i := 0; i < MAXJOBNUM+10; i++ {
+		task := sched.Every(1).Hour()
+		at := now.Add(time.Duration(MAXJOBNUM+10-i) * time.Minute)
+		task.From(&at)
+		assert.Nil(task.DoFunc(func(context.Context) error { return nil }))
+		tasks = append(tasks, task)
+	}
+	assert.Equal(MAXJOBNUM+10, sched.Len())
+
+	next, tm := sched.NextRun()
+	assert.Equal(tasks[len(tasks)-1], next)
+	assert.True(tm.Equal(now.Add(time.Minute)))
+
+	// 修改执行时间后调整队列
+	at := now.Add(time.Second)
+	tasks[0].From(&at)
+	next, _ = sched.NextRun()
+	assert.Equal(tasks[0], next)
+
+	sched.RemoveByRef(tasks[0])
+	next, _ = sched.NextRun()
+	assert.Equal(tasks[len(tasks)-1], next)
+	assert.Equal(-1, tasks[0].index)
+
+	sched.Clear()
+	assert.Equal(0, sched.Len())
+	next, _ = sched.NextRun()
+	assert.Nil(next)
+}
+
+func Test_SchedulerMilliseconds(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler()
+
+	var fast, slow int32
+	assert.Nil(sched.Every(50).Milliseconds().DoFunc(func(context.Context) error {
+		atomic.AddInt32(&fast, 1)
+		return nil
+	}))
+	assert.Nil(sched.Every(1).Minute().DoFunc(func(context.Context) error {
+		atomic.AddInt32(&slow, 1)
+		return nil
+	}))
+	assert.Equal("every 50 milliseconds", sched.Tasks()[0].Spec())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sched.StartContext(ctx)
+	time.Sleep(520 * time.Millisecond)
+
+	// 运行中添加的任务会唤醒等待
+	var added int32
+	at := time.Now().Add(20 * time.Millisecond)
+	assert.Nil(sched.Every(1).Hour().From(&at).DoFunc(func(context.Context) error {
+		atomic.AddInt32(&added, 1)
+		return nil
+	}))
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+	sched.Wait()
+
+	assert.True(atomic.LoadInt32(&fast) >= 8, fast)
+	assert.True(atomic.LoadInt32(&fast) <= 13, fast)
+	assert.Equal(int32(0), atomic.LoadInt32(&slow))
+	assert.Equal(int32(1), atomic.LoadInt32(&added))
+	assert.Equal(time.Millisecond, milliseconds.String())
+	assert.Equal("every 1 milliseconds", NewTask(1).Millisecond().Spec())
+}
@@ -17,33 +17,69 @@ limitations under the License.
 package schedule
 
 import (
-	"sort"
+	"container/heap"
+	"context"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/uber-go/tally"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
+	"github.com/kubeservice-stack/common/pkg/workpool"
 )
 
-// Scheduler struct, the only data member is the list of jobs.
+// Scheduler keeps the jobs in a min-heap ordered by their next run
 type Scheduler struct {
-	jobs [MAXJOBNUM]*Task // Array store jobs
-	size int              // Size of jobs which jobs holding.
-	loc  *time.Location   // Location to use when scheduling jobs with specified times
+	mu          sync.Mutex      // protects jobs, queue, next runs and runCtx
+	jobs        []*Task         // jobs in the order they were added
+	queue       taskQueue       // scheduled jobs ordered by next run
+	wakeup      chan struct{}   // notify the ticker goroutine when the queue changes
+	loc         *time.Location  // Location to use when scheduling jobs with specified times
+	pool        workpool.Pool   // Pool executing the jobs
+	poolMu      sync.Mutex      // protects pool, ownPool, starts, stopPool, runq and draining
+	ownPool     bool            // pool is the default pool created by the scheduler
+	starts      int             // number of StartContext calls not finished
+	stopPool    bool            // stop the default pool once runq is drained
+	runq        []workpool.Task // runs waiting to be submitted to the pool
+	draining    bool            // a goroutine is submitting runq to the pool
+	runCtx      context.Context // context of the running ticker, cancelled on stop or leadership loss
+	concurrency int             // Max workers of the default pool
+	running     sync.WaitGroup  // Jobs submitted and not finished
+	store       JobStore        // Optional store persisting the run times
+	catchUp     CatchUpPolicy   // Policy for the runs missed while stopped
+	restoreOnce sync.Once       // Restore from the store once when started
+	elector     Elector         // Optional elector, only the leader runs jobs
+	leading     atomic.Bool     // Whether the scheduler is the leader now
+	scope       tally.Scope     // Scope of the task metrics
+	logger      *logger.Logger
 }
 
 // NewScheduler creates a new scheduler
-func NewScheduler() *Scheduler {
-	return &Scheduler{
-		jobs: [MAXJOBNUM]*Task{},
-		size: 0,
-		loc:  time.Local,
+func NewScheduler(opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		wakeup:      make(chan struct{}, 1),
+		loc:         time.Local,
+		concurrency: defaultConcurrency,
+		logger:      logger.GetLogger("pkg/common/schedule", "Scheduler"),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // Tasks returns the list of Tasks from the Scheduler
 func (s *Scheduler) Tasks() []*Task {
-	return s.jobs[:s.size]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Task(nil), s.jobs...)
 }
 
 func (s *Scheduler) Len() int {
-	return s.size
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
 }
 
 func (s *Scheduler) Swap(i, j int) {
@@ -51,7 +87,7 @@ func (s *Scheduler) Swap(i, j int) {
 }
 
 func (s *Scheduler) Less(i, j int) bool {
-	return s.jobs[j].nextRun.Unix() >= s.jobs[i].nextRun.Unix()
+	return !s.jobs[j].nextRun.Before(s.jobs[i].nextRun)
 }
 
 // ChangeLoc changes the default time location
@@ -59,48 +95,216 @@ func (s *Scheduler) ChangeLoc(newLocation *time.Location) {
 	s.loc = newLocation
 }
 
-// Get the current runnable jobs, which shouldRun is True
-func (s *Scheduler) getRunnableTasks() (runningTasks [MAXJOBNUM]*Task, n int) {
-	runnableTasks := [MAXJOBNUM]*Task{}
-	n = 0
-	sort.Sort(s)
-	for i := 0; i < s.size; i++ {
-		if s.jobs[i].shouldRun() {
-			runnableTasks[n] = s.jobs[i]
-			n++
-		} else {
+// add 添加任务, 任务在Do等设置下一次执行时间后进入队列
+func (s *Scheduler) add(j *Task) *Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.sched = s
+	s.jobs = append(s.jobs, j)
+	return j
+}
+
+// fix 任务的下一次执行时间变化后调整在队列中的位置
+func (s *Scheduler) fix(j *Task) {
+	s.mu.Lock()
+	s.fixLocked(j)
+	s.mu.Unlock()
+	s.notify()
+}
+
+// reschedule 在锁内修改下一次执行时间并调整队列, 避免与popRunnableTasks并发修改nextRun.
+// t为nil时, 下一次执行时间已过期才重新计算
+func (s *Scheduler) reschedule(j *Task, t *time.Time) {
+	s.mu.Lock()
+	if t != nil {
+		j.nextRun = *t
+	} else if !j.nextRun.After(time.Now()) {
+		_ = j.scheduleNextRun()
+	}
+	s.fixLocked(j)
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Scheduler) fixLocked(j *Task) {
+	if j.sched != s {
+		return
+	}
+	if j.index >= 0 {
+		heap.Fix(&s.queue, j.index)
+	} else {
+		heap.Push(&s.queue, j)
+	}
+}
+
+// notify 唤醒等待中的ticker goroutine重新计算等待时间
+func (s *Scheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// dueTask 到期的任务
+type dueTask struct {
+	task      *Task
+	scheduled time.Time // 计划执行时间
+	run       bool      // 是否执行, 暂停或已过Until的任务不执行
+}
+
+// popRunnableTasks 取出所有到期的任务并计算下一次执行时间, 不再调度的任务从调度器中移除
+func (s *Scheduler) popRunnableTasks() []dueTask {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var dueTasks []dueTask
+	for len(s.queue) > 0 && s.queue[0].shouldRun() {
+		j := heap.Pop(&s.queue).(*Task)
+		due := dueTask{task: j, scheduled: j.nextRun}
+		switch {
+		case j.finished():
+		case j.Paused():
+			// 暂停的一次性任务在Resume后重新进入队列
+			if j.once {
+				continue
+			}
+		default:
+			due.run = true
+			j.fired++
+		}
+		dueTasks = append(dueTasks, due)
+
+		j.lastRun = now.Add(-j.jittered)
+		if !j.finished() {
+			// 无法计算下一次执行时间的任务不再进入队列
+			if err := j.scheduleNextRun(); err != nil {
+				continue
+			}
+		}
+		if j.finished() {
+			s.dropLocked(j)
+			continue
+		}
+		heap.Push(&s.queue, j)
+	}
+	return dueTasks
+}
+
+// dropLocked 移除已完成的任务, 不删除JobStore中的记录, 重启后不会再次执行
+func (s *Scheduler) dropLocked(j *Task) {
+	if j.index >= 0 {
+		heap.Remove(&s.queue, j.index)
+	}
+	for i, t := range s.jobs {
+		if t == j {
+			copy(s.jobs[i:], s.jobs[i+1:])
+			s.jobs[len(s.jobs)-1] = nil
+			s.jobs = s.jobs[:len(s.jobs)-1]
 			break
 		}
 	}
-	return runnableTasks, n
+	j.sched = nil
+}
+
+func (s *Scheduler) drop(j *Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j.sched == s {
+		s.dropLocked(j)
+	}
+}
+
+// untilNext 距离最近一个任务到期的时间
+func (s *Scheduler) untilNext() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return maxTickInterval
+	}
+	d := time.Until(s.queue[0].nextRun)
+	switch {
+	case d < 0:
+		return 0
+	case d > maxTickInterval:
+		return maxTickInterval
+	}
+	return d
 }
 
 // NextRun datetime when the next job should run.
 func (s *Scheduler) NextRun() (*Task, time.Time) {
-	if s.size <= 0 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next *Task
+	if len(s.queue) > 0 {
+		next = s.queue[0]
+	}
+	// 未进入队列的任务(例如还没有Do)也参与比较, 与以前的行为一致
+	if len(s.jobs) > len(s.queue) {
+		for _, j := range s.jobs {
+			if j.index < 0 && (next == nil || j.nextRun.Before(next.nextRun)) {
+				next = j
+			}
+		}
+	}
+	if next == nil {
 		return nil, time.Now()
 	}
-	sort.Sort(s)
-	return s.jobs[0], s.jobs[0].nextRun
+	return next, next.nextRun
 }
 
 // Every schedule a new periodic job with interval
 func (s *Scheduler) Every(interval uint64) *Task {
-	job := NewTask(interval).Loc(s.loc)
-	s.jobs[s.size] = job
-	s.size++
-	return job
+	return s.add(NewTask(interval).Loc(s.loc))
+}
+
+// Once schedule a new job running once at the time, the job is removed after running
+//
+//	s.Once(time.Now().Add(time.Hour)).Do(task)
+func (s *Scheduler) Once(at time.Time) *Task {
+	job := NewTask(1).Loc(s.loc)
+	job.once = true
+	job.nextRun = at
+	return s.add(job)
+}
+
+// Cron schedule a new job with standard 5/6-field cron expression
+//
+//	s.Cron("*/5 9-17 * * 1-5").Do(task)
+//	s.Cron("@every 1h30m").Do(task)
+func (s *Scheduler) Cron(expr string) *Task {
+	job := NewTask(1).Loc(s.loc)
+	job.cron, job.err = parseCron(expr)
+	return s.add(job)
 }
 
 // RunPending runs all the jobs that are scheduled to run.
 func (s *Scheduler) RunPending() {
-	runnableTasks, n := s.getRunnableTasks()
+	s.RunPendingContext(context.Background())
+}
 
-	if n != 0 {
-		for i := 0; i < n; i++ {
-			go runnableTasks[i].run()
-			runnableTasks[i].lastRun = time.Now()
-			runnableTasks[i].scheduleNextRun()
+// RunPendingContext runs all the jobs that are scheduled to run on the pool,
+// ctx is passed to the jobs and cancels them when done
+func (s *Scheduler) RunPendingContext(ctx context.Context) {
+	if !s.IsLeader() {
+		return
+	}
+	for _, due := range s.popRunnableTasks() {
+		j := due.task
+		// 先保存下一次执行时间再执行, leader切换后不会重复执行
+		if err := s.persist(ctx, j); err != nil {
+			s.logger.Warn("save job to store failed", logger.String("job", j.GetName()), logger.Error(err))
+			if s.elector != nil {
+				continue
+			}
+		}
+		if !s.stillLeader() {
+			s.logger.Warn("scheduler lost leadership, skip the run", logger.String("job", j.GetName()))
+			return
+		}
+		if due.run {
+			s.dispatch(ctx, j, due.scheduled)
 		}
 	}
 }
@@ -112,8 +316,8 @@ func (s *Scheduler) RunAll() {
 
 // RunAllwithDelay runs all jobs with delay seconds
 func (s *Scheduler) RunAllwithDelay(d int) {
-	for i := 0; i < s.size; i++ {
-		go s.jobs[i].run()
+	for _, j := range s.Tasks() {
+		s.dispatch(context.Background(), j, time.Now())
 		if d != 0 {
 			time.Sleep(time.Duration(d))
 		}
@@ -137,46 +341,41 @@ func (s *Scheduler) RemoveByRef(j *Task) {
 // RemoveByTag removes specific job j by tag
 func (s *Scheduler) RemoveByTag(t string) {
 	s.removeByCondition(func(someTask *Task) bool {
-		for _, a := range someTask.tags {
-			if a == t {
-				return true
-			}
-		}
-		return false
+		return hasTag(someTask, t)
 	})
 }
 
 func (s *Scheduler) removeByCondition(shouldRemove func(*Task) bool) {
-	i := 0
-
-	// keep deleting until no more jobs match the criteria
-	for {
-		found := false
-
-		for ; i < s.size; i++ {
-			if shouldRemove(s.jobs[i]) {
-				found = true
-				break
-			}
+	s.mu.Lock()
+	var removed []*Task
+	kept := s.jobs[:0]
+	for _, j := range s.jobs {
+		if !shouldRemove(j) {
+			kept = append(kept, j)
+			continue
 		}
-
-		if !found {
-			return
+		if j.index >= 0 {
+			heap.Remove(&s.queue, j.index)
 		}
+		j.sched = nil
+		removed = append(removed, j)
+	}
+	for i := len(kept); i < len(s.jobs); i++ {
+		s.jobs[i] = nil
+	}
+	s.jobs = kept
+	s.mu.Unlock()
 
-		for j := (i + 1); j < s.size; j++ {
-			s.jobs[i] = s.jobs[j]
-			i++
-		}
-		s.size--
-		s.jobs[s.size] = nil
+	for _, j := range removed {
+		s.unpersist(j)
 	}
 }
 
 // Scheduled checks if specific job j was already added
 func (s *Scheduler) Scheduled(j interface{}) bool {
-	for _, job := range s.jobs {
-		if job != nil && job.taskFunc == getFunctionName(j) {
+	name := getFunctionName(j)
+	for _, job := range s.Tasks() {
+		if job.taskFunc == name {
 			return true
 		}
 	}
@@ -185,33 +384,87 @@ func (s *Scheduler) Scheduled(j interface{}) bool {
 
 // Clear delete all scheduled jobs
 func (s *Scheduler) Clear() {
-	for i := 0; i < s.size; i++ {
-		s.jobs[i] = nil
-	}
-	s.size = 0
+	s.removeByCondition(func(*Task) bool { return true })
 }
 
 // Start all the pending jobs
-// Add seconds ticker
+// closing stopped cancels the context of running jobs
 func (s *Scheduler) Start() (stopped chan bool, done <-chan struct{}) {
 	stopped = make(chan bool, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopped
+		cancel()
+	}()
+	return stopped, s.StartContext(ctx)
+}
+
+// StartContext restores the jobs from the JobStore and runs the jobs when they are due until ctx is done,
+// the returned channel is closed when the ticker goroutine exits.
+// With WithElector the jobs run only while the scheduler is the leader.
+func (s *Scheduler) StartContext(ctx context.Context) <-chan struct{} {
 	doneCh := make(chan struct{})
-	ticker := time.NewTicker(1 * time.Second)
+	s.poolMu.Lock()
+	s.starts++
+	s.stopPool = false
+	s.poolMu.Unlock()
+	if s.elector != nil {
+		go func() {
+			defer close(doneCh)
+			defer s.releasePool()
+			s.lead(ctx)
+		}()
+		return doneCh
+	}
 
+	s.restoreOnce.Do(func() {
+		if err := s.Restore(ctx); err != nil {
+			s.logger.Error("restore jobs from store failed", logger.Error(err))
+		}
+	})
 	go func() {
 		defer close(doneCh)
-		for {
-			select {
-			case <-ticker.C:
-				s.RunPending()
-			case <-stopped:
-				ticker.Stop()
-				return
-			}
+		defer s.releasePool()
+		s.tick(ctx)
+	}()
+	return doneCh
+}
+
+// tick 等待到最近一个任务到期时执行, 队列变化时重新计算等待时间, 直到ctx结束
+func (s *Scheduler) tick(ctx context.Context) {
+	s.mu.Lock()
+	s.runCtx = ctx
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.runCtx == ctx {
+			s.runCtx = nil
 		}
+		s.mu.Unlock()
 	}()
 
-	return stopped, doneCh
+	timer := time.NewTimer(s.untilNext())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			s.RunPendingContext(ctx)
+		case <-s.wakeup:
+		case <-ctx.Done():
+			return
+		}
+		timer.Reset(s.untilNext())
+	}
+}
+
+// runContext 调度中时返回调度的context(WithElector时为leader的context), 手动触发的执行随之取消; 未启动时为Background
+func (s *Scheduler) runContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.runCtx != nil {
+		return s.runCtx
+	}
+	return context.Background()
 }
 
 // The following methods are shortcuts for not having to
@@ -222,6 +475,16 @@ func Every(interval uint64) *Task {
 	return defaultScheduler.Every(interval)
 }
 
+// Once schedules a new job running once at the time
+func Once(at time.Time) *Task {
+	return defaultScheduler.Once(at)
+}
+
+// Cron schedules a new job with cron expression
+func Cron(expr string) *Task {
+	return defaultScheduler.Cron(expr)
+}
+
 // RunPending run all jobs that are scheduled to run
 //
 // Please note that it is *intended behavior that run_pending()
@@ -264,12 +527,7 @@ func Remove(j interface{}) {
 
 // Scheduled checks if specific job j was already added
 func Scheduled(j interface{}) bool {
-	for _, job := range defaultScheduler.jobs {
-		if job != nil && job.taskFunc == getFunctionName(j) {
-			return true
-		}
-	}
-	return false
+	return defaultScheduler.Scheduled(j)
 }
 
 // NextRun gets the next running time
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const benchmarkJobs = 100000
+
+func newBenchmarkScheduler(b *testing.B, n int) *Scheduler {
+	sched := NewScheduler()
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		at := now.Add(time.Hour + time.Duration(i)*time.Millisecond)
+		if err := sched.Every(1).Hour().From(&at).DoFunc(func(context.Context) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return sched
+}
+
+func BenchmarkScheduler_Add100k(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		newBenchmarkScheduler(b, benchmarkJobs)
+	}
+}
+
+func BenchmarkScheduler_RunPendingIdle100k(b *testing.B) {
+	sched := newBenchmarkScheduler(b, benchmarkJobs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sched.RunPending()
+	}
+}
+
+func BenchmarkScheduler_NextRun100k(b *testing.B) {
+	sched := newBenchmarkScheduler(b, benchmarkJobs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sched.NextRun()
+	}
+}
+
+func BenchmarkScheduler_Reschedule100k(b *testing.B) {
+	sched := newBenchmarkScheduler(b, benchmarkJobs)
+	tasks := sched.Tasks()
+	now := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		at := now.Add(time.Duration(i%benchmarkJobs) * time.Second)
+		tasks[i%benchmarkJobs].From(&at)
+	}
+}
+
+func BenchmarkScheduler_Due100k(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		sched := newBenchmarkScheduler(b, benchmarkJobs)
+		past := time.Now().Add(-time.Second)
+		for _, task := range sched.Tasks() {
+			task.From(&past)
+		}
+		b.StartTimer()
+		// 10万个任务同时到期时只计算下一次执行时间, 不执行
+		if tasks := sched.popRunnableTasks(); len(tasks) != benchmarkJobs {
+			b.Fatalf("expect %d runnable tasks, got %d", benchmarkJobs, len(tasks))
+		}
+	}
+}
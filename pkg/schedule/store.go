@@ -0,0 +1,416 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
+	"github.com/kubeservice-stack/common/pkg/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobRecord 持久化的任务定义和执行时间, 任务函数不持久化, 重启后按Name匹配代码中注册的任务
+type JobRecord struct {
+	Name    string    `json:"name" gorm:"primaryKey;size:255"` // 任务名称, 见Task.Name
+	Spec    string    `json:"spec" gorm:"size:255"`            // 调度定义, 例如 "every 5 minutes" 或cron表达式
+	Tags    []string  `json:"tags" gorm:"serializer:json"`     // 标签
+	NextRun time.Time `json:"next_run"`                        // 下一次执行时间
+	LastRun time.Time `json:"last_run"`                        // 最近一次执行时间
+	Runs    int       `json:"runs"`                            // 已调度执行的次数, 用于Limit
+	Paused  bool      `json:"paused"`                          // 是否暂停
+	// Finished 一次性任务已执行, 达到Limit或超过Until; 重启后代码中注册的同名任务不再执行
+	Finished bool `json:"finished"`
+	// Fence 写入时leader的fencing token, 见WithElector; 没有elector时为0
+	Fence int64 `json:"fence"`
+}
+
+// TableName 数据库表名
+func (JobRecord) TableName() string {
+	return "schedule_jobs"
+}
+
+// JobStore 任务持久化存储
+type JobStore interface {
+	Save(ctx context.Context, record JobRecord) error        // 新增或更新, Fence小于已保存记录时返回ErrStaleFence
+	Get(ctx context.Context, name string) (JobRecord, error) // 不存在时返回ErrJobNotFound
+	List(ctx context.Context) ([]JobRecord, error)           // 按名称排序
+	Delete(ctx context.Context, name string) error           // 不存在时不报错
+}
+
+// memoryJobStore 内存存储, 用于测试和单进程场景
+type memoryJobStore struct {
+	mu      sync.RWMutex
+	records map[string]JobRecord
+}
+
+// NewMemoryJobStore creates a JobStore kept in memory
+func NewMemoryJobStore() JobStore {
+	return &memoryJobStore{records: make(map[string]JobRecord)}
+}
+
+func (m *memoryJobStore) Save(_ context.Context, record JobRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.records[record.Name]; ok && record.Fence < old.Fence {
+		return ErrStaleFence
+	}
+	record.Tags = append([]string(nil), record.Tags...)
+	m.records[record.Name] = record
+	return nil
+}
+
+func (m *memoryJobStore) Get(_ context.Context, name string) (JobRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.records[name]
+	if !ok {
+		return JobRecord{}, ErrJobNotFound
+	}
+	return record, nil
+}
+
+func (m *memoryJobStore) List(_ context.Context) ([]JobRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	records := make([]JobRecord, 0, len(m.records))
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+func (m *memoryJobStore) Delete(_ context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, name)
+	return nil
+}
+
+// fileJobStore 单文件存储, 内存中保留全部记录, 每次修改后原子替换文件
+type fileJobStore struct {
+	memoryJobStore
+	path string
+}
+
+// NewFileJobStore creates a JobStore persisted as a JSON file at path
+func NewFileJobStore(path string) (JobStore, error) {
+	f := &fileJobStore{
+		memoryJobStore: memoryJobStore{records: make(map[string]JobRecord)},
+		path:           path,
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return f, nil
+	case err != nil:
+		return nil, err
+	}
+	var records []JobRecord
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+	}
+	for _, record := range records {
+		f.records[record.Name] = record
+	}
+	return f, nil
+}
+
+func (f *fileJobStore) Save(ctx context.Context, record JobRecord) error {
+	if err := f.memoryJobStore.Save(ctx, record); err != nil {
+		return err
+	}
+	return f.flush(ctx)
+}
+
+func (f *fileJobStore) Delete(ctx context.Context, name string) error {
+	if err := f.memoryJobStore.Delete(ctx, name); err != nil {
+		return err
+	}
+	return f.flush(ctx)
+}
+
+// flush 先写临时文件再rename, 避免进程退出时留下不完整的文件
+func (f *fileJobStore) flush(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records := make([]JobRecord, 0, len(f.records))
+	for _, record := range f.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// sqlJobStore 基于orm的数据库存储, 多个副本可共享
+type sqlJobStore struct {
+	db *gorm.DB
+}
+
+// NewSQLJobStore creates a JobStore in the schedule_jobs table of conn, the table is migrated automatically
+func NewSQLJobStore(conn *orm.DBConn) (JobStore, error) {
+	if conn == nil || conn.DB() == nil {
+		return nil, orm.ErrDBNotconnected
+	}
+	if err := conn.DB().AutoMigrate(&JobRecord{}); err != nil {
+		return nil, err
+	}
+	return &sqlJobStore{db: conn.DB()}, nil
+}
+
+func (s *sqlJobStore) Save(ctx context.Context, record JobRecord) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var old JobRecord
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", record.Name).First(&old).Error
+		switch {
+		case err == nil && record.Fence < old.Fence:
+			return ErrStaleFence
+		case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+		return tx.Save(&record).Error
+	})
+}
+
+func (s *sqlJobStore) Get(ctx context.Context, name string) (JobRecord, error) {
+	var record JobRecord
+	err := s.db.WithContext(ctx).Where("name = ?", name).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return JobRecord{}, ErrJobNotFound
+	}
+	return record, err
+}
+
+func (s *sqlJobStore) List(ctx context.Context) ([]JobRecord, error) {
+	var records []JobRecord
+	err := s.db.WithContext(ctx).Order("name").Find(&records).Error
+	return records, err
+}
+
+func (s *sqlJobStore) Delete(ctx context.Context, name string) error {
+	return s.db.WithContext(ctx).Where("name = ?", name).Delete(&JobRecord{}).Error
+}
+
+// CatchUpPolicy 恢复任务时对停机期间错过的执行的处理策略
+type CatchUpPolicy int
+
+const (
+	// CatchUpOnce 错过多次只补执行一次, 默认策略
+	CatchUpOnce CatchUpPolicy = iota
+	// CatchUpAll 按错过的次数依次补执行
+	CatchUpAll
+	// CatchUpSkip 不补执行, 直接等待下一次调度
+	CatchUpSkip
+)
+
+// maxCatchUpRuns CatchUpAll时最多补执行的次数
+const maxCatchUpRuns = 1000
+
+// WithJobStore 持久化任务的执行时间, 启动时从store恢复
+func WithJobStore(store JobStore) SchedulerOption {
+	return func(s *Scheduler) {
+		s.store = store
+	}
+}
+
+// checkName 未指定Name时任务名默认为函数名, 同一函数的多个任务需要用Name区分, 否则store中的记录会互相覆盖
+func (s *Scheduler) checkName(j *Task, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil {
+		return nil
+	}
+	for _, other := range s.jobs {
+		if other != j && other.taskFunc != "" && other.GetName() == name {
+			return fmt.Errorf("%w: %s", ErrDuplicateTaskName, name)
+		}
+	}
+	return nil
+}
+
+// WithCatchUp 设置错过执行的补偿策略
+func WithCatchUp(policy CatchUpPolicy) SchedulerOption {
+	return func(s *Scheduler) {
+		s.catchUp = policy
+	}
+}
+
+// Restore loads next and last run times of the scheduled jobs from the JobStore,
+// fires the runs missed while stopped according to the CatchUpPolicy and saves new jobs.
+// StartContext calls it once automatically.
+func (s *Scheduler) Restore(ctx context.Context) error {
+	if s.store == nil {
+		return nil
+	}
+	now := time.Now()
+	for _, j := range s.Tasks() {
+		if j.err != nil || j.GetName() == "" {
+			continue
+		}
+		record, err := s.store.Get(ctx, j.GetName())
+		switch {
+		case errors.Is(err, ErrJobNotFound):
+			if err := s.persist(ctx, j); err != nil {
+				return err
+			}
+			continue
+		case err != nil:
+			return err
+		}
+
+		if record.Finished {
+			s.drop(j)
+			continue
+		}
+		s.mu.Lock()
+		if !record.LastRun.IsZero() {
+			j.lastRun = record.LastRun
+		}
+		j.fired = record.Runs
+		s.mu.Unlock()
+		if record.Paused {
+			j.Pause()
+		}
+		if !record.NextRun.IsZero() {
+			s.catchUpTask(ctx, j, record.NextRun, now)
+		}
+		if err := s.persist(ctx, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catchUpTask 从保存的下一次执行时间恢复任务, 计算并补执行错过的次数
+func (s *Scheduler) catchUpTask(ctx context.Context, j *Task, next, now time.Time) {
+	if !j.once {
+		next = j.allowedFrom(next)
+	}
+	if next.After(now) {
+		s.reschedule(j, &next)
+		return
+	}
+
+	missed, scheduled := 0, next
+	for !next.IsZero() && !next.After(now) && (j.until.IsZero() || !next.After(j.until)) && missed < maxCatchUpRuns {
+		missed++
+		next = j.nextAfter(next)
+	}
+	n := 0
+	switch {
+	case j.Paused():
+	case s.catchUp == CatchUpOnce:
+		n = min(missed, 1)
+	case s.catchUp == CatchUpAll:
+		n = missed
+	}
+	if j.limit > 0 {
+		s.mu.Lock()
+		n = max(min(n, j.limit-j.fired), 0)
+		s.mu.Unlock()
+	}
+	s.dispatchN(ctx, j, n, scheduled)
+
+	s.mu.Lock()
+	defer s.notify()
+	defer s.mu.Unlock()
+	j.fired += n
+	// 错过且不补执行的一次性任务视为已完成
+	if j.once && n == 0 && !j.Paused() {
+		j.fired = 1
+	}
+	j.lastRun = now
+	if !j.finished() {
+		j.scheduleNextRun()
+	}
+	if j.finished() {
+		if j.sched == s {
+			s.dropLocked(j)
+		}
+		return
+	}
+	s.fixLocked(j)
+}
+
+// persist 保存任务的定义和执行时间
+func (s *Scheduler) persist(ctx context.Context, j *Task) error {
+	if s.store == nil || j.GetName() == "" {
+		return nil
+	}
+	// 执行时间在s.mu内修改, 加锁读取一致的快照
+	s.mu.Lock()
+	record := JobRecord{
+		Name:     j.GetName(),
+		Spec:     j.Spec(),
+		Tags:     j.Tags(),
+		Runs:     j.fired,
+		Paused:   j.Paused(),
+		Finished: j.finished(),
+	}
+	if !j.nextRun.Equal(time.Unix(0, 0)) {
+		record.NextRun = j.nextRun
+	}
+	if !j.lastRun.Equal(time.Unix(0, 0)) {
+		record.LastRun = j.lastRun
+	}
+	s.mu.Unlock()
+	record.Fence = s.fence()
+	return s.store.Save(ctx, record)
+}
+
+// unpersist 删除已移除任务的记录
+func (s *Scheduler) unpersist(j *Task) {
+	if s.store == nil || j.GetName() == "" {
+		return
+	}
+	if err := s.store.Delete(context.Background(), j.GetName()); err != nil {
+		s.logger.Warn("delete job from store failed", logger.String("job", j.GetName()), logger.Error(err))
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/config"
+	"github.com/kubeservice-stack/common/pkg/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+func testJobStore(t *testing.T, store JobStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "a")
+	assert.Equal(ErrJobNotFound, err)
+
+	next := time.Now().Add(time.Hour).Truncate(time.Second)
+	assert.Nil(store.Save(ctx, JobRecord{Name: "b", Spec: "every 1 hours", Tags: []string{"x"}, NextRun: next}))
+	assert.Nil(store.Save(ctx, JobRecord{Name: "a", Spec: "@daily"}))
+	assert.Nil(store.Save(ctx, JobRecord{Name: "a", Spec: "@hourly"}))
+
+	record, err := store.Get(ctx, "b")
+	assert.Nil(err)
+	assert.Equal("every 1 hours", record.Spec)
+	assert.Equal([]string{"x"}, record.Tags)
+	assert.True(next.Equal(record.NextRun))
+
+	records, err := store.List(ctx)
+	assert.Nil(err)
+	assert.Len(records, 2)
+	assert.Equal("a", records[0].Name)
+	assert.Equal("@hourly", records[0].Spec)
+
+	// 较小的fencing token不能覆盖新leader的记录
+	assert.Nil(store.Save(ctx, JobRecord{Name: "a", Spec: "@hourly", Fence: 2}))
+	assert.ErrorIs(store.Save(ctx, JobRecord{Name: "a", Spec: "@daily", Fence: 1}), ErrStaleFence)
+	record, err = store.Get(ctx, "a")
+	assert.Nil(err)
+	assert.Equal("@hourly", record.Spec)
+	assert.Equal(int64(2), record.Fence)
+
+	assert.Nil(store.Delete(ctx, "a"))
+	assert.Nil(store.Delete(ctx, "a"))
+	records, err = store.List(ctx)
+	assert.Nil(err)
+	assert.Len(records, 1)
+}
+
+func Test_MemoryJobStore(t *testing.T) {
+	testJobStore(t, NewMemoryJobStore())
+}
+
+func Test_FileJobStore(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	store, err := NewFileJobStore(path)
+	assert.Nil(err)
+	testJobStore(t, store)
+
+	reopened, err := NewFileJobStore(path)
+	assert.Nil(err)
+	record, err := reopened.Get(context.Background(), "b")
+	assert.Nil(err)
+	assert.Equal([]string{"x"}, record.Tags)
+
+	_, err = NewFileJobStore(t.TempDir())
+	assert.NotNil(err)
+}
+
+func Test_SQLJobStore(t *testing.T) {
+	assert := assert.New(t)
+	conn, err := orm.NewDBConn(config.DBConfig{DBType: config.SQLITE3, Database: filepath.Join(t.TempDir(), "jobs.db")})
+	assert.Nil(err)
+	defer conn.Close()
+
+	store, err := NewSQLJobStore(conn)
+	assert.Nil(err)
+	testJobStore(t, store)
+
+	_, err = NewSQLJobStore(nil)
+	assert.Equal(orm.ErrDBNotconnected, err)
+}
+
+func Test_SchedulerRestore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	restore := func(policy CatchUpPolicy, next time.Time) (*Task, int32) {
+		store := NewMemoryJobStore()
+		assert.Nil(store.Save(ctx, JobRecord{Name: "job", NextRun: next, LastRun: next.Add(-time.Minute)}))
+		sched := NewScheduler(WithJobStore(store), WithCatchUp(policy))
+
+		var runs int32
+		task := sched.Every(1).Minute().Name("job")
+		assert.Nil(task.DoFunc(func(context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}))
+		assert.Nil(sched.Restore(ctx))
+		sched.Wait()
+
+		record, err := store.Get(ctx, "job")
+		assert.Nil(err)
+		assert.Equal("every 1 minutes", record.Spec)
+		assert.True(task.NextScheduledTime().Equal(record.NextRun))
+		return task, atomic.LoadInt32(&runs)
+	}
+
+	// 停机期间错过了5次
+	missed := now.Add(-5*time.Minute + time.Second)
+	task, runs := restore(CatchUpOnce, missed)
+	assert.Equal(int32(1), runs)
+	assert.True(task.NextScheduledTime().After(now))
+	_, runs = restore(CatchUpAll, missed)
+	assert.Equal(int32(5), runs)
+	_, runs = restore(CatchUpSkip, missed)
+	assert.Equal(int32(0), runs)
+
+	// 未到期的执行时间(例如From)被保留
+	future := now.Add(3 * time.Hour).Truncate(time.Second)
+	task, runs = restore(CatchUpOnce, future)
+	assert.Equal(int32(0), runs)
+	assert.True(future.Equal(task.NextScheduledTime()))
+}
+
+func Test_SchedulerPersist(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store := NewMemoryJobStore()
+	sched := NewScheduler(WithJobStore(store))
+
+	task := sched.Cron("@every 1h").Name("cron")
+	task.Tag("t")
+	assert.Nil(task.DoFunc(func(context.Context) error { return nil }))
+	assert.Nil(sched.Restore(ctx))
+	record, err := store.Get(ctx, "cron")
+	assert.Nil(err)
+	assert.Equal("@every 1h", record.Spec)
+	assert.Equal([]string{"t"}, record.Tags)
+
+	task.From(&time.Time{})
+	sched.RunPending()
+	sched.Wait()
+	record, err = store.Get(ctx, "cron")
+	assert.Nil(err)
+	assert.True(record.NextRun.After(time.Now()))
+	assert.WithinDuration(time.Now(), record.LastRun, time.Second)
+
+	sched.RemoveByTag("t")
+	_, err = store.Get(ctx, "cron")
+	assert.Equal(ErrJobNotFound, err)
+}
+
+func Test_SchedulerDuplicateName(t *testing.T) {
+	assert := assert.New(t)
+	sched := NewScheduler(WithJobStore(NewMemoryJobStore()))
+
+	// 未指定Name时默认为函数名, 同一函数的第二个任务会覆盖store中的记录
+	assert.Nil(sched.Every(1).Minute().Do(functionNameC))
+	assert.ErrorIs(sched.Every(2).Minute().Do(functionNameC), ErrDuplicateTaskName)
+	assert.Nil(sched.Every(2).Minute().Name("task-2").Do(functionNameC))
+	assert.ErrorIs(sched.Every(3).Minute().Name("task-2").DoFunc(func(context.Context) error { return nil }), ErrDuplicateTaskName)
+
+	// 重新设置同一个任务的函数不算重名
+	again := sched.Every(1).Hour().Name("again")
+	assert.Nil(again.Do(functionNameC))
+	assert.Nil(again.Do(functionNameC))
+
+	// 没有store时允许重名
+	plain := NewScheduler()
+	assert.Nil(plain.Every(1).Minute().Do(functionNameC))
+	assert.Nil(plain.Every(2).Minute().Do(functionNameC))
+}
+
+func Test_TaskSpec(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("every 5 minutes", NewTask(5).Minutes().Spec())
+	assert.Equal("every 1 days at 10:30", NewTask(1).Day().At("10:30").Spec())
+	assert.Equal("every 1 weeks on Monday at 08:00", NewTask(1).Monday().At("08:00").Spec())
+	assert.Equal("fn", NewTask(1).Name("fn").GetName())
+}
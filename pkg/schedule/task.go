@@ -17,10 +17,19 @@ limitations under the License.
 package schedule
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+
+	"github.com/kubeservice-stack/common/pkg/tracing"
 )
 
 // Task struct keeping information about job
@@ -38,6 +47,32 @@ type Task struct {
 	fparams  map[string][]interface{} // Map for function and  params of function
 	lock     bool                     // lock the job from running at same time form multiple instances
 	tags     []string                 // allow the user to tag jobs with certain labels
+	cron     *cronSpec                // optional cron expression, overrides interval and unit
+	timeout  time.Duration            // optional deadline of each run
+	overlap  OverlapPolicy            // policy when the previous run is still executing
+	runMu    sync.Mutex               // protects running, queued and history
+	running  int                      // number of executing runs
+	queued   bool                     // a run is queued behind the executing one
+	fn       TaskFunc                 // typed taskFunc set by DoFunc
+	retries  int                      // retries of a failed run
+	backoff  time.Duration            // wait before the first retry
+	history  TaskHistory              // run statistics and recent records
+	name     string                   // optional unique name, used as the key in JobStore
+	sched    *Scheduler               // scheduler the job was added to
+	metrics  *taskMetrics             // metrics created when first dispatched
+	paused   atomic.Bool              // paused jobs are rescheduled without running
+	once     bool                     // run once at nextRun then remove
+	limit    int                      // max number of scheduled runs, 0 means no limit
+	fired    int                      // number of scheduled runs
+	until    time.Time                // no runs are scheduled after until
+	jitter   time.Duration            // max random delay added to each next run
+	jittered time.Duration            // random delay added to the current next run
+	calendar Calendar                 // optional calendar excluding days from the schedule
+	index    int                      // index in the queue of the scheduler, -1 when not queued
+
+	onError   func(*Task, error)       // called when a run fails
+	onSuccess func(*Task)              // called when a run succeeds
+	onPanic   func(*Task, interface{}) // called when a run panics
 }
 
 // NewTask creates a new job with the time interval.
@@ -51,30 +86,58 @@ func NewTask(interval uint64) *Task {
 		funcs:    make(map[string]interface{}),
 		fparams:  make(map[string][]interface{}),
 		tags:     []string{},
+		index:    -1,
 	}
 }
 
 // True if the job should be run now
 func (j *Task) shouldRun() bool {
-	return time.Now().Unix() >= j.nextRun.Unix()
+	return !time.Now().Before(j.nextRun)
 }
 
 // Run the job and immediately reschedule it
-func (j *Task) run() ([]reflect.Value, error) {
+func (j *Task) run(ctx context.Context, scheduled time.Time) error {
 	if j.lock {
 		if locker == nil {
-			return nil, fmt.Errorf("trying to lock %s with nil locker", j.taskFunc)
+			return fmt.Errorf("trying to lock %s with nil locker", j.taskFunc)
 		}
 		key := getFunctionKey(j.taskFunc)
 
-		locker.Lock(key)
+		// 其他节点持有锁时跳过本次执行
+		if ok, err := locker.Lock(key); err != nil || !ok {
+			return err
+		}
 		defer locker.Unlock(key)
 	}
-	result, err := callTaskFuncWithParams(j.funcs[j.taskFunc], j.fparams[j.taskFunc])
+
+	start := time.Now()
+	span, spanCtx := tracing.StartSpan(ctx, "schedule.run", tracing.Tag{Key: "task", Value: j.GetName()})
+	attempts, err := j.invokeWithRetry(spanCtx)
+	span.SetTag("attempts", attempts)
 	if err != nil {
-		return nil, err
+		ext.LogError(span, err)
+	}
+	span.Finish()
+	duration := time.Since(start)
+	j.record(RunRecord{Start: start, Duration: duration, Attempts: attempts, Err: err})
+	j.observe(start.Sub(scheduled), duration, err)
+
+	var perr *panicError
+	switch {
+	case errors.As(err, &perr):
+		if j.onPanic != nil {
+			j.onPanic(j, perr.value)
+		}
+	case err != nil:
+		if j.onError != nil {
+			j.onError(j, err)
+		}
+	default:
+		if j.onSuccess != nil {
+			j.onSuccess(j)
+		}
 	}
-	return result, nil
+	return err
 }
 
 // Err should be checked to ensure an error didn't occur creating the job
@@ -89,20 +152,98 @@ func (j *Task) Do(taskFun interface{}, params ...interface{}) error {
 	}
 
 	typ := reflect.TypeOf(taskFun)
-	if typ.Kind() != reflect.Func {
+	if typ == nil || typ.Kind() != reflect.Func {
 		return ErrNotAFunction
 	}
 	fname := getFunctionName(taskFun)
+	if err := j.checkName(fname); err != nil {
+		return err
+	}
 	j.funcs[fname] = taskFun
 	j.fparams[fname] = params
 	j.taskFunc = fname
+	j.fn = nil
+
+	j.scheduleFirstRun()
+	return nil
+}
+
+// DoFunc specifies the typed taskFunc that should be called every time the job runs,
+// the returned error is recorded in the history and triggers retries and OnError
+//
+//	s.Every(1).Minute().Retry(3, time.Second).DoFunc(func(ctx context.Context) error { ... })
+func (j *Task) DoFunc(fn TaskFunc) error {
+	if j.err != nil {
+		return j.err
+	}
+	if fn == nil {
+		return ErrNotAFunction
+	}
+	fname := getFunctionName(fn)
+	if err := j.checkName(fname); err != nil {
+		return err
+	}
+	j.fn = fn
+	j.taskFunc = fname
+
+	j.scheduleFirstRun()
+	return nil
+}
+
+// checkName 任务名是JobStore记录的key, 配置了JobStore时不能与其他任务重名
+func (j *Task) checkName(taskFunc string) error {
+	if j.sched == nil {
+		return nil
+	}
+	name := j.name
+	if name == "" {
+		name = taskFunc
+	}
+	return j.sched.checkName(j, name)
+}
 
+func (j *Task) scheduleFirstRun() {
+	if j.sched != nil {
+		j.sched.reschedule(j, nil)
+		return
+	}
 	now := time.Now().In(j.loc)
 	if !j.nextRun.After(now) {
 		j.scheduleNextRun()
 	}
+}
 
-	return nil
+// fix 下一次执行时间变化后通知调度器
+func (j *Task) fix() {
+	if j.sched != nil {
+		j.sched.fix(j)
+	}
+}
+
+// Retry retries a failed run up to attempts times, waiting backoff before the first retry
+// and doubling it after each retry
+func (j *Task) Retry(attempts int, backoff time.Duration) *Task {
+	j.retries = attempts
+	j.backoff = backoff
+	return j
+}
+
+// OnError is called with the last error when a run fails after all retries
+func (j *Task) OnError(fn func(*Task, error)) *Task {
+	j.onError = fn
+	return j
+}
+
+// OnSuccess is called when a run succeeds
+func (j *Task) OnSuccess(fn func(*Task)) *Task {
+	j.onSuccess = fn
+	return j
+}
+
+// OnPanic is called with the recovered value when a run panics, panics are not retried
+func (j *Task) OnPanic(fn func(*Task, interface{})) *Task {
+	j.onPanic = fn
+	return j
 }
 
 // DoSafely does the same thing as Do, but logs unexpected panics, instead of unwinding them up the chain
@@ -143,6 +284,88 @@ func (j *Task) GetAt() string {
 	return fmt.Sprintf("%1.2d:%2.2d", j.atTime/time.Hour, (j.atTime%time.Hour)/time.Minute)
 }
 
+// Pause stops running the job until Resume, the next runs are still scheduled
+func (j *Task) Pause() *Task {
+	j.paused.Store(true)
+	return j
+}
+
+// Resume runs the paused job again from its next scheduled run
+func (j *Task) Resume() *Task {
+	j.paused.Store(false)
+	// 暂停期间到期的一次性任务重新进入队列
+	if j.once {
+		j.fix()
+	}
+	return j
+}
+
+// Paused returns whether the job is paused
+func (j *Task) Paused() bool {
+	return j.paused.Load()
+}
+
+// Limit removes the job after n scheduled runs, manual runs such as RunAll are not counted
+func (j *Task) Limit(n int) *Task {
+	j.limit = n
+	return j
+}
+
+// Until removes the job once its next run is after t
+func (j *Task) Until(t time.Time) *Task {
+	j.until = t
+	return j
+}
+
+// Jitter delays each scheduled run by a random duration in [0, d),
+// spreading the runs of the same job across replicas
+func (j *Task) Jitter(d time.Duration) *Task {
+	j.jitter = d
+	return j
+}
+
+// Calendar only schedules runs on the days allowed by c, runs falling on excluded days
+// are moved to the first schedule on the next allowed day. One-off jobs are not affected.
+//
+//	s.Every(1).Day().At("18:00").Calendar(schedule.LastBusinessDayOfMonth(holidays)).DoFunc(bill)
+func (j *Task) Calendar(c Calendar) *Task {
+	j.calendar = c
+	return j
+}
+
+// Name sets the unique name of the job, which identifies it in the JobStore
+// and defaults to the name of the taskFunc
+func (j *Task) Name(name string) *Task {
+	j.name = name
+	return j
+}
+
+// GetName returns the name of the job
+func (j *Task) GetName() string {
+	if j.name != "" {
+		return j.name
+	}
+	return j.taskFunc
+}
+
+// Spec returns a readable definition of the schedule, e.g. "every 5 minutes at 10:30"
+func (j *Task) Spec() string {
+	if j.once {
+		return "once at " + j.nextRun.Format(time.RFC3339)
+	}
+	if j.cron != nil {
+		return j.cron.expr
+	}
+	spec := fmt.Sprintf("every %d %s", j.interval, unitNames[j.unit])
+	if j.unit == weeks {
+		spec += " on " + j.startDay.String()
+	}
+	if (j.unit == days || j.unit == weeks) && j.atTime != 0 {
+		spec += " at " + j.GetAt()
+	}
+	return spec
+}
+
 // Loc sets the location for which to interpret "At"
 //
 //	s.Every(1).Day().At("10:30").Loc(time.UTC).Do(task)
@@ -180,6 +403,8 @@ func (j *Task) periodDuration() (time.Duration, error) {
 	var periodDuration time.Duration
 
 	switch j.unit {
+	case milliseconds:
+		periodDuration = interval * time.Millisecond
 	case seconds:
 		periodDuration = interval * time.Second
 	case minutes:
@@ -201,20 +426,61 @@ func (j *Task) roundToMidnight(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, j.loc)
 }
 
-// scheduleNextRun Compute the instant when this job should run next
+// scheduleNextRun Compute the instant when this job should run next, with random jitter
 func (j *Task) scheduleNextRun() error {
+	// 一次性任务的执行时间固定
+	if j.once {
+		return nil
+	}
+	if err := j.computeNextRun(); err != nil {
+		return err
+	}
+	if j.calendar != nil {
+		next := j.allowedFrom(j.nextRun)
+		if next.IsZero() {
+			return ErrCalendarNeverAllows
+		}
+		j.nextRun = next
+	}
+	j.jittered = 0
+	if j.jitter > 0 {
+		j.jittered = rand.N(j.jitter)
+		j.nextRun = j.nextRun.Add(j.jittered)
+	}
+	return nil
+}
+
+// finished 任务是否不再需要调度: 一次性任务已执行, 达到执行次数上限, 或下一次执行时间超过Until
+func (j *Task) finished() bool {
+	switch {
+	case j.once && j.fired > 0:
+		return true
+	case j.limit > 0 && j.fired >= j.limit:
+		return true
+	case !j.until.IsZero() && j.nextRun.After(j.until):
+		return true
+	}
+	return false
+}
+
+// computeNextRun 计算下一次执行时间, 不包括jitter
+func (j *Task) computeNextRun() error {
 	now := time.Now()
 	if j.lastRun.Equal(time.Unix(0, 0)) {
 		j.lastRun = now
 	}
 
+	if j.cron != nil {
+		return j.scheduleNextCronRun(now)
+	}
+
 	periodDuration, err := j.periodDuration()
 	if err != nil {
 		return err
 	}
 
 	switch j.unit {
-	case seconds, minutes, hours:
+	case milliseconds, seconds, minutes, hours:
 		j.nextRun = j.lastRun.Add(periodDuration)
 	case days:
 		j.nextRun = j.roundToMidnight(j.lastRun)
@@ -237,6 +503,67 @@ func (j *Task) scheduleNextRun() error {
 	return nil
 }
 
+// scheduleNextCronRun 按cron表达式在任务时区中计算下一次执行时间
+func (j *Task) scheduleNextCronRun(now time.Time) error {
+	base := now
+	if j.lastRun.After(base) {
+		base = j.lastRun
+	}
+	next := j.cron.next(base.In(j.loc))
+	if next.IsZero() {
+		return ErrCronNeverFires
+	}
+	j.nextRun = next
+	return nil
+}
+
+// nextAfter 返回t之后的下一次调度时间, t是一次调度时间, 无法计算时返回零值
+func (j *Task) nextAfter(t time.Time) time.Time {
+	if j.once {
+		return time.Time{}
+	}
+	if j.cron != nil {
+		return j.allowedFrom(j.cron.next(t.In(j.loc)))
+	}
+	period, err := j.periodDuration()
+	if err != nil {
+		return time.Time{}
+	}
+	return j.allowedFrom(t.Add(period))
+}
+
+// allowedFrom 返回不早于调度时间t且日历允许的第一个调度时间, 找不到时返回零值
+func (j *Task) allowedFrom(t time.Time) time.Time {
+	if j.calendar == nil {
+		return t
+	}
+	for i := 0; i < maxCalendarDays && !t.IsZero(); i++ {
+		local := t.In(j.loc)
+		if j.calendar.Allowed(local) {
+			return t
+		}
+		// 从次日零点开始找下一个调度时间
+		t = j.firstFrom(t, time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, j.loc))
+	}
+	return time.Time{}
+}
+
+// firstFrom 返回调度时间t之后不早于day的第一个调度时间
+func (j *Task) firstFrom(t, day time.Time) time.Time {
+	if j.cron != nil && j.cron.every == 0 {
+		return j.cron.next(day.Add(-time.Second))
+	}
+	period, err := j.periodDuration()
+	if j.cron != nil {
+		period, err = j.cron.every, nil
+	}
+	if err != nil || period <= 0 {
+		return time.Time{}
+	}
+	n := (day.Sub(t) + period - 1) / period
+	return t.Add(n * period)
+}
+
 // NextScheduledTime returns the time of when this job is to run next
 func (j *Task) NextScheduledTime() time.Time {
 	return j.nextRun
@@ -252,6 +579,10 @@ func (j *Task) mustInterval(i uint64) error {
 
 // From schedules the next run of the job
 func (j *Task) From(t *time.Time) *Task {
+	if j.sched != nil {
+		j.sched.reschedule(j, t)
+		return j
+	}
 	j.nextRun = *t
 	return j
 }
@@ -262,6 +593,11 @@ func (j *Task) setUnit(unit timeUnit) *Task {
 	return j
 }
 
+// Milliseconds set the unit with milliseconds
+func (j *Task) Milliseconds() *Task {
+	return j.setUnit(milliseconds)
+}
+
 // Seconds set the unit with seconds
 func (j *Task) Seconds() *Task {
 	return j.setUnit(seconds)
@@ -287,6 +623,12 @@ func (j *Task) Weeks() *Task {
 	return j.setUnit(weeks)
 }
 
+// Millisecond sets the unit with millisecond, which interval is 1
+func (j *Task) Millisecond() *Task {
+	j.mustInterval(1)
+	return j.Milliseconds()
+}
+
 // Second sets the unit with second
 func (j *Task) Second() *Task {
 	j.mustInterval(1)
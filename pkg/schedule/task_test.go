@@ -17,6 +17,8 @@ limitations under the License.
 package schedule
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -141,3 +143,46 @@ func Test_TaskLock(t *testing.T) {
 	err = task1.Lock().Hours().At(now.Format("15:04:05")).Loc(time.UTC).Do(CallBackPanic)
 	assert.Nil(err)
 }
+
+type countLocker struct {
+	ok       bool
+	err      error
+	unlocked int
+}
+
+func (s *countLocker) Lock(key string) (bool, error) {
+	return s.ok, s.err
+}
+
+func (s *countLocker) Unlock(key string) error {
+	s.unlocked++
+	return nil
+}
+
+func Test_TaskLockNotAcquired(t *testing.T) {
+	assert := assert.New(t)
+	defer SetLocker(locker)
+
+	runs := 0
+	task := NewTask(1).Lock()
+	assert.Nil(task.Seconds().Do(func() { runs++ }))
+
+	// 其他节点持有锁时跳过本次执行, 不释放别人的锁
+	held := &countLocker{}
+	SetLocker(held)
+	assert.Nil(task.run(context.Background(), time.Now()))
+	assert.Equal(0, runs)
+	assert.Equal(0, held.unlocked)
+
+	failed := &countLocker{err: errors.New("locker unavailable")}
+	SetLocker(failed)
+	assert.NotNil(task.run(context.Background(), time.Now()))
+	assert.Equal(0, runs)
+	assert.Equal(0, failed.unlocked)
+
+	acquired := &countLocker{ok: true}
+	SetLocker(acquired)
+	assert.Nil(task.run(context.Background(), time.Now()))
+	assert.Equal(1, runs)
+	assert.Equal(1, acquired.unlocked)
+}
@@ -0,0 +1,351 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+
+	"github.com/kubeservice-stack/common/pkg/dag"
+	"github.com/kubeservice-stack/common/pkg/tracing"
+)
+
+// StepStatus 工作流步骤在一次执行中的状态
+type StepStatus int
+
+const (
+	// StepPending 未执行
+	StepPending StepStatus = iota
+	// StepSucceeded 执行成功
+	StepSucceeded
+	// StepFailed 重试后仍然失败
+	StepFailed
+	// StepSkipped 上游步骤失败或执行被取消, 没有执行
+	StepSkipped
+)
+
+var stepStatusNames = map[StepStatus]string{
+	StepPending:   "pending",
+	StepSucceeded: "succeeded",
+	StepFailed:    "failed",
+	StepSkipped:   "skipped",
+}
+
+func (s StepStatus) String() string {
+	return stepStatusNames[s]
+}
+
+// Step 工作流中的一个步骤, 作为dag.Vertex的Value
+type Step struct {
+	fn      TaskFunc
+	retries int
+	backoff time.Duration
+	timeout time.Duration
+}
+
+// NewStep creates a workflow step running fn
+func NewStep(fn TaskFunc) *Step {
+	return &Step{fn: fn}
+}
+
+// Retry 失败时最多重试attempts次, 退避时间从backoff开始翻倍
+func (st *Step) Retry(attempts int, backoff time.Duration) *Step {
+	st.retries = attempts
+	st.backoff = backoff
+	return st
+}
+
+// Timeout 单个步骤的执行超时时间, 包括重试
+func (st *Step) Timeout(d time.Duration) *Step {
+	st.timeout = d
+	return st
+}
+
+// invoke 执行一次步骤, panic转换为panicError
+func (st *Step) invoke(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r}
+		}
+	}()
+	return st.fn(ctx)
+}
+
+// StepRecord 一次工作流执行中单个步骤的记录
+type StepRecord struct {
+	Step     string        // 步骤ID, 即dag.Vertex的ID
+	Status   StepStatus    // 状态
+	Start    time.Time     // 开始时间
+	Duration time.Duration // 耗时, 包括重试等待
+	Attempts int           // 执行次数
+	Err      error         // 最后一次执行的错误
+}
+
+// WorkflowRun 工作流一次执行的记录
+type WorkflowRun struct {
+	Workflow string        // 工作流名称
+	Start    time.Time     // 开始时间
+	Duration time.Duration // 耗时
+	Steps    []StepRecord  // 各步骤记录, 按拓扑顺序
+	Err      error         // 失败步骤的错误, 全部成功时为nil
+}
+
+// Workflow 按依赖关系执行dag.DAG中的步骤, 无依赖的分支并行执行,
+// 步骤失败时跳过其所有下游步骤
+//
+//	wf, err := schedule.NewWorkflow("etl", graph)
+//	s.Every(1).Day().At("02:00").Name("etl").DoFunc(wf.Func())
+type Workflow struct {
+	name     string
+	ids      []string
+	steps    []*Step
+	children [][]int
+	parents  []int
+	limit    int
+
+	mu   sync.Mutex
+	runs []WorkflowRun
+}
+
+// NewWorkflow creates a workflow from the graph. Each vertex value must be a *Step or a TaskFunc.
+// The graph is copied, later changes to it are not reflected in the workflow.
+func NewWorkflow(name string, graph *dag.DAG) (*Workflow, error) {
+	vertices := graph.Vertices()
+	index := make(map[*dag.Vertex]int, len(vertices))
+	w := &Workflow{
+		name:     name,
+		ids:      make([]string, len(vertices)),
+		steps:    make([]*Step, len(vertices)),
+		children: make([][]int, len(vertices)),
+		parents:  make([]int, len(vertices)),
+	}
+	for i, v := range vertices {
+		index[v] = i
+		w.ids[i] = v.ID
+		switch fn := v.Value.(type) {
+		case *Step:
+			w.steps[i] = fn
+		case TaskFunc:
+			w.steps[i] = NewStep(fn)
+		case func(context.Context) error:
+			w.steps[i] = NewStep(fn)
+		}
+		if w.steps[i] == nil || w.steps[i].fn == nil {
+			return nil, fmt.Errorf("%w: %s", ErrStepFunc, v.ID)
+		}
+	}
+	for i, v := range vertices {
+		for _, child := range v.Children.Values() {
+			c, ok := index[child.(*dag.Vertex)]
+			if !ok {
+				return nil, fmt.Errorf("vertex %s not found in the graph", child.(*dag.Vertex).ID)
+			}
+			w.children[i] = append(w.children[i], c)
+			w.parents[c]++
+		}
+	}
+	if err := w.sort(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// sort 按拓扑顺序重排步骤, 存在环时返回ErrWorkflowCycle
+func (w *Workflow) sort() error {
+	n := len(w.ids)
+	pending := append([]int(nil), w.parents...)
+	order := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if pending[i] == 0 {
+			order = append(order, i)
+		}
+	}
+	for k := 0; k < len(order); k++ {
+		for _, c := range w.children[order[k]] {
+			if pending[c]--; pending[c] == 0 {
+				order = append(order, c)
+			}
+		}
+	}
+	if len(order) != n {
+		return ErrWorkflowCycle
+	}
+
+	position := make([]int, n)
+	for pos, i := range order {
+		position[i] = pos
+	}
+	ids := make([]string, n)
+	steps := make([]*Step, n)
+	children := make([][]int, n)
+	parents := make([]int, n)
+	for pos, i := range order {
+		ids[pos], steps[pos], parents[pos] = w.ids[i], w.steps[i], w.parents[i]
+		for _, c := range w.children[i] {
+			children[pos] = append(children[pos], position[c])
+		}
+	}
+	w.ids, w.steps, w.children, w.parents = ids, steps, children, parents
+	return nil
+}
+
+// GetName returns the name of the workflow
+func (w *Workflow) GetName() string {
+	return w.name
+}
+
+// Steps returns the step IDs in topological order
+func (w *Workflow) Steps() []string {
+	return append([]string(nil), w.ids...)
+}
+
+// Concurrency 同时执行的步骤数上限, 0表示不限制
+func (w *Workflow) Concurrency(n int) *Workflow {
+	w.limit = n
+	return w
+}
+
+// Func returns a TaskFunc running the workflow, to be scheduled with Task.DoFunc
+func (w *Workflow) Func() TaskFunc {
+	return func(ctx context.Context) error {
+		return w.Run(ctx).Err
+	}
+}
+
+// Run 执行一次工作流, 返回并保存执行记录
+func (w *Workflow) Run(ctx context.Context) WorkflowRun {
+	n := len(w.ids)
+	run := WorkflowRun{Workflow: w.name, Start: time.Now(), Steps: make([]StepRecord, n)}
+	for i, id := range w.ids {
+		run.Steps[i].Step = id
+	}
+
+	var sem chan struct{}
+	if w.limit > 0 {
+		sem = make(chan struct{}, w.limit)
+	}
+	// 每个goroutine只写自己的记录, 通过done交还给协调者
+	done := make(chan int, n)
+	start := func(i int) {
+		go func() {
+			defer func() { done <- i }()
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-ctx.Done():
+				}
+			}
+			w.runStep(ctx, i, &run.Steps[i])
+		}()
+	}
+
+	pending := append([]int(nil), w.parents...)
+	blocked := make([]bool, n)
+	remaining := n
+	var complete func(i int)
+	complete = func(i int) {
+		remaining--
+		ok := run.Steps[i].Status == StepSucceeded
+		for _, c := range w.children[i] {
+			blocked[c] = blocked[c] || !ok
+			if pending[c]--; pending[c] > 0 {
+				continue
+			}
+			if blocked[c] {
+				run.Steps[c].Status = StepSkipped
+				complete(c)
+			} else {
+				start(c)
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		if pending[i] == 0 {
+			start(i)
+		}
+	}
+	for remaining > 0 {
+		complete(<-done)
+	}
+
+	var errs []error
+	for _, rec := range run.Steps {
+		if rec.Status == StepFailed {
+			errs = append(errs, fmt.Errorf("step %s: %w", rec.Step, rec.Err))
+		} else if rec.Err != nil && len(errs) == 0 {
+			errs = append(errs, rec.Err)
+		}
+	}
+	run.Err = errors.Join(errs...)
+	run.Duration = time.Since(run.Start)
+	w.record(run)
+	return run
+}
+
+// runStep 执行单个步骤并填写记录, ctx已取消时标记为跳过
+func (w *Workflow) runStep(ctx context.Context, i int, rec *StepRecord) {
+	if err := ctx.Err(); err != nil {
+		rec.Status = StepSkipped
+		rec.Err = err
+		return
+	}
+
+	st := w.steps[i]
+	if st.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.timeout)
+		defer cancel()
+	}
+	rec.Start = time.Now()
+	span, spanCtx := tracing.StartSpan(ctx, "schedule.step",
+		tracing.Tag{Key: "workflow", Value: w.name}, tracing.Tag{Key: "step", Value: rec.Step})
+	rec.Attempts, rec.Err = retryCall(spanCtx, st.retries, st.backoff, st.invoke)
+	span.SetTag("attempts", rec.Attempts)
+	if rec.Err != nil {
+		ext.LogError(span, rec.Err)
+	}
+	span.Finish()
+	rec.Duration = time.Since(rec.Start)
+	if rec.Err != nil {
+		rec.Status = StepFailed
+	} else {
+		rec.Status = StepSucceeded
+	}
+}
+
+// record 保存最近historySize次执行记录
+func (w *Workflow) record(run WorkflowRun) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.runs) >= historySize {
+		w.runs = append(w.runs[:0], w.runs[1:]...)
+	}
+	w.runs = append(w.runs, run)
+}
+
+// Runs returns the recent runs of the workflow, from oldest to newest
+func (w *Workflow) Runs() []WorkflowRun {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]WorkflowRun(nil), w.runs...)
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kubeservice-stack/common/pkg/dag"
+)
+
+func newTestGraph(assert *assert.Assertions, vertices []*dag.Vertex, edges ...[2]int) *dag.DAG {
+	graph := dag.NewDAG()
+	for _, v := range vertices {
+		assert.Nil(graph.AddVertex(v))
+	}
+	for _, e := range edges {
+		assert.Nil(graph.AddEdge(vertices[e[0]], vertices[e[1]]))
+	}
+	return graph
+}
+
+func Test_WorkflowRun(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var order []string
+	step := func(id string) TaskFunc {
+		return func(context.Context) error {
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+			return nil
+		}
+	}
+	// b和c互相等待, 只有并行执行才能完成
+	left, right := make(chan struct{}), make(chan struct{})
+	branch := func(id string, mine, other chan struct{}) TaskFunc {
+		return func(ctx context.Context) error {
+			close(mine)
+			select {
+			case <-other:
+			case <-time.After(time.Second):
+				return errors.New("branches not run in parallel")
+			}
+			return step(id)(ctx)
+		}
+	}
+
+	vertices := []*dag.Vertex{
+		dag.NewVertex("d", step("d")),
+		dag.NewVertex("b", NewStep(branch("b", left, right))),
+		dag.NewVertex("c", branch("c", right, left)),
+		dag.NewVertex("a", step("a")),
+	}
+	graph := newTestGraph(assert, vertices, [2]int{3, 1}, [2]int{3, 2}, [2]int{1, 0}, [2]int{2, 0})
+	wf, err := NewWorkflow("diamond", graph)
+	assert.Nil(err)
+	assert.Equal("diamond", wf.GetName())
+	assert.Equal([]string{"a", "b", "c", "d"}, wf.Steps())
+
+	run := wf.Run(context.Background())
+	assert.Nil(run.Err)
+	assert.Equal("diamond", run.Workflow)
+	assert.Len(order, 4)
+	assert.Equal("a", order[0])
+	assert.Equal("d", order[3])
+	for _, rec := range run.Steps {
+		assert.Equal(StepSucceeded, rec.Status, rec.Step)
+		assert.Equal(1, rec.Attempts)
+	}
+	assert.Len(wf.Runs(), 1)
+}
+
+func Test_WorkflowFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	errStep := errors.New("step failed")
+	var attempts, downstream int32
+	vertices := []*dag.Vertex{
+		dag.NewVertex("a", TaskFunc(func(context.Context) error { return nil })),
+		dag.NewVertex("b", NewStep(func(context.Context) error {
+			atomic.AddInt32(&attempts, 1)
+			return errStep
+		}).Retry(2, time.Millisecond)),
+		dag.NewVertex("c", TaskFunc(func(context.Context) error {
+			atomic.AddInt32(&downstream, 1)
+			return nil
+		})),
+		dag.NewVertex("d", TaskFunc(func(context.Context) error { panic("boom") })),
+		dag.NewVertex("e", TaskFunc(func(context.Context) error { return nil })),
+	}
+	graph := newTestGraph(assert, vertices, [2]int{0, 1}, [2]int{1, 2}, [2]int{0, 3}, [2]int{0, 4})
+	wf, err := NewWorkflow("failure", graph)
+	assert.Nil(err)
+
+	run := wf.Run(context.Background())
+	assert.True(errors.Is(run.Err, errStep))
+	assert.True(errors.Is(run.Err, ErrTaskPanic))
+	assert.Equal(int32(3), atomic.LoadInt32(&attempts))
+	assert.Equal(int32(0), atomic.LoadInt32(&downstream))
+
+	status := map[string]StepRecord{}
+	for _, rec := range run.Steps {
+		status[rec.Step] = rec
+	}
+	assert.Equal(StepSucceeded, status["a"].Status)
+	assert.Equal(StepFailed, status["b"].Status)
+	assert.Equal(3, status["b"].Attempts)
+	assert.Equal(StepSkipped, status["c"].Status)
+	assert.Equal("skipped", status["c"].Status.String())
+	assert.Equal(StepFailed, status["d"].Status)
+	assert.Equal(1, status["d"].Attempts)
+	assert.Equal(StepSucceeded, status["e"].Status)
+
+	// 取消后所有步骤跳过
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	run = wf.Run(ctx)
+	assert.True(errors.Is(run.Err, context.Canceled))
+	for _, rec := range run.Steps {
+		assert.Equal(StepSkipped, rec.Status, rec.Step)
+	}
+	assert.Len(wf.Runs(), 2)
+}
+
+func Test_WorkflowInvalid(t *testing.T) {
+	assert := assert.New(t)
+	ok := TaskFunc(func(context.Context) error { return nil })
+
+	vertices := []*dag.Vertex{dag.NewVertex("a", ok), dag.NewVertex("b", ok), dag.NewVertex("c", ok)}
+	graph := newTestGraph(assert, vertices, [2]int{0, 1}, [2]int{1, 2}, [2]int{2, 1})
+	_, err := NewWorkflow("cycle", graph)
+	assert.Equal(ErrWorkflowCycle, err)
+
+	graph = newTestGraph(assert, []*dag.Vertex{dag.NewVertex("a", "not a func")})
+	_, err = NewWorkflow("invalid", graph)
+	assert.True(errors.Is(err, ErrStepFunc))
+
+	_, err = NewWorkflow("nil", newTestGraph(assert, []*dag.Vertex{dag.NewVertex("a", NewStep(nil))}))
+	assert.True(errors.Is(err, ErrStepFunc))
+}
+
+func Test_WorkflowScheduled(t *testing.T) {
+	assert := assert.New(t)
+
+	var running, peak, runs int32
+	step := func(context.Context) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}
+	vertices := []*dag.Vertex{
+		dag.NewVertex("a", TaskFunc(step)),
+		dag.NewVertex("b", TaskFunc(step)),
+		dag.NewVertex("c", TaskFunc(step)),
+	}
+	wf, err := NewWorkflow("scheduled", newTestGraph(assert, vertices))
+	assert.Nil(err)
+	wf.Concurrency(1)
+
+	sched := NewScheduler()
+	assert.Nil(sched.Every(50).Milliseconds().Name("scheduled").Limit(2).DoFunc(wf.Func()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sched.StartContext(ctx)
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-done
+	sched.Wait()
+
+	assert.Len(wf.Runs(), 2)
+	assert.Equal(int32(6), atomic.LoadInt32(&runs))
+	assert.Equal(int32(1), atomic.LoadInt32(&peak))
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var ErrUnsupportedAggregate = errors.New("unsupported aggregate")
+
+// AggregateFunc aggregates the points of a series into a single value.
+type AggregateFunc int
+
+const (
+	AggregateSum AggregateFunc = iota
+	AggregateAvg
+	AggregateMin
+	AggregateMax
+	AggregateCount
+	AggregateLast
+	// AggregateIncrease is the increase over the series, counter resets are compensated for counters and histograms.
+	AggregateIncrease
+	// AggregateRate is AggregateIncrease per second.
+	AggregateRate
+)
+
+func (f AggregateFunc) String() string {
+	switch f {
+	case AggregateSum:
+		return "sum"
+	case AggregateAvg:
+		return "avg"
+	case AggregateMin:
+		return "min"
+	case AggregateMax:
+		return "max"
+	case AggregateCount:
+		return "count"
+	case AggregateLast:
+		return "last"
+	case AggregateIncrease:
+		return "increase"
+	case AggregateRate:
+		return "rate"
+	default:
+		return ErrUnknown
+	}
+}
+
+// Aggregate aggregates the points of the series.
+// Histogram points are aggregated by their observation count, string points only support AggregateCount.
+func (s *Series) Aggregate(fn AggregateFunc) (float64, error) {
+	if len(s.Points) == 0 {
+		return 0, ErrNoDataPoints
+	}
+	if fn == AggregateCount {
+		return float64(len(s.Points)), nil
+	}
+	values := make([]float64, 0, len(s.Points))
+	for _, p := range s.Points {
+		switch p.ValueType() {
+		case ValueFloat:
+			values = append(values, p.Value)
+		case ValueHistogram:
+			values = append(values, float64(p.Histogram.Count))
+		default:
+			return 0, fmt.Errorf("%w: %s of %s points", ErrUnsupportedAggregate, fn, p.ValueType())
+		}
+	}
+
+	switch fn {
+	case AggregateSum, AggregateAvg:
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		if fn == AggregateAvg {
+			return sum / float64(len(values)), nil
+		}
+		return sum, nil
+	case AggregateMin:
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min, nil
+	case AggregateMax:
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max, nil
+	case AggregateLast:
+		return values[len(values)-1], nil
+	case AggregateIncrease:
+		return s.increase(values), nil
+	case AggregateRate:
+		elapsed := s.Points[len(s.Points)-1].Timestamp - s.Points[0].Timestamp
+		if elapsed <= 0 {
+			return 0, nil
+		}
+		seconds := float64(elapsed) / float64(toPrecision(time.Second, s.Precision))
+		return s.increase(values) / seconds, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedAggregate, fn)
+	}
+}
+
+// increase gives back the increase of values. A gauge may go down, but a decrease of
+// a counter or histogram means it has been reset, so the value after the reset is the increase.
+func (s *Series) increase(values []float64) float64 {
+	if !s.monotonic() {
+		return values[len(values)-1] - values[0]
+	}
+	var inc float64
+	for i := 1; i < len(values); i++ {
+		delta := values[i] - values[i-1]
+		if delta < 0 {
+			delta = values[i]
+		}
+		inc += delta
+	}
+	return inc
+}
+
+func (s *Series) monotonic() bool {
+	switch s.Metadata.Type {
+	case MetricTypeCounter, MetricTypeHistogram:
+		return true
+	case MetricTypeUnknown:
+		// Histograms are cumulative even without metadata.
+		return len(s.Points) > 0 && s.Points[0].ValueType() == ValueHistogram
+	default:
+		return false
+	}
+}
+
+// HistogramIncrease gives back the bucket-wise increase of a histogram series, compensating resets.
+func (s *Series) HistogramIncrease() (*Histogram, error) {
+	if len(s.Points) == 0 {
+		return nil, ErrNoDataPoints
+	}
+	var result *Histogram
+	for i, p := range s.Points {
+		if p.ValueType() != ValueHistogram {
+			return nil, fmt.Errorf("%w: histogram increase of %s points", ErrUnsupportedAggregate, p.ValueType())
+		}
+		if i == 0 {
+			result = &Histogram{Buckets: make([]Bucket, len(p.Histogram.Buckets))}
+			for j := range p.Histogram.Buckets {
+				result.Buckets[j].UpperBound = p.Histogram.Buckets[j].UpperBound
+			}
+			continue
+		}
+		prev, cur := s.Points[i-1].Histogram, p.Histogram
+		if len(prev.Buckets) != len(cur.Buckets) || len(cur.Buckets) != len(result.Buckets) {
+			return nil, fmt.Errorf("%w: histogram buckets changed", ErrUnsupportedAggregate)
+		}
+		reset := cur.Count < prev.Count
+		for j := range cur.Buckets {
+			if reset || cur.Buckets[j].Count < prev.Buckets[j].Count {
+				reset = true
+				break
+			}
+		}
+		if reset {
+			result.Count += cur.Count
+			result.Sum += cur.Sum
+			for j := range cur.Buckets {
+				result.Buckets[j].Count += cur.Buckets[j].Count
+			}
+			continue
+		}
+		result.Count += cur.Count - prev.Count
+		result.Sum += cur.Sum - prev.Sum
+		for j := range cur.Buckets {
+			result.Buckets[j].Count += cur.Buckets[j].Count - prev.Buckets[j].Count
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Series_Aggregate(t *testing.T) {
+	points := []*DataPoint{
+		{Timestamp: 0, Value: 1},
+		{Timestamp: 10, Value: 5},
+		{Timestamp: 20, Value: 2}, // counter reset
+		{Timestamp: 30, Value: 4},
+	}
+	tests := []struct {
+		name    string
+		typ     MetricType
+		fn      AggregateFunc
+		want    float64
+		wantErr bool
+	}{
+		{name: "sum", typ: MetricTypeGauge, fn: AggregateSum, want: 12},
+		{name: "avg", typ: MetricTypeGauge, fn: AggregateAvg, want: 3},
+		{name: "min", typ: MetricTypeGauge, fn: AggregateMin, want: 1},
+		{name: "max", typ: MetricTypeGauge, fn: AggregateMax, want: 5},
+		{name: "count", typ: MetricTypeGauge, fn: AggregateCount, want: 4},
+		{name: "last", typ: MetricTypeGauge, fn: AggregateLast, want: 4},
+		{name: "gauge increase", typ: MetricTypeGauge, fn: AggregateIncrease, want: 3},
+		{name: "counter increase", typ: MetricTypeCounter, fn: AggregateIncrease, want: 8},
+		{name: "counter rate", typ: MetricTypeCounter, fn: AggregateRate, want: 8.0 / 30},
+		{name: "unknown func", typ: MetricTypeGauge, fn: AggregateFunc(100), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Series{Metadata: MetricMetadata{Type: tt.typ}, Precision: Seconds, Points: points}
+			got, err := s.Aggregate(tt.fn)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+
+	s := &Series{}
+	_, err := s.Aggregate(AggregateSum)
+	assert.ErrorIs(t, err, ErrNoDataPoints)
+
+	s = &Series{Points: []*DataPoint{{Timestamp: 1, Text: "deployed"}}}
+	got, err := s.Aggregate(AggregateCount)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), got)
+	_, err = s.Aggregate(AggregateSum)
+	assert.ErrorIs(t, err, ErrUnsupportedAggregate)
+}
+
+func Test_Series_HistogramIncrease(t *testing.T) {
+	assert := assert.New(t)
+	hist := func(sum float64, count uint64, b1, b2 uint64) *Histogram {
+		return &Histogram{Sum: sum, Count: count, Buckets: []Bucket{{UpperBound: 1, Count: b1}, {UpperBound: 10, Count: b2}}}
+	}
+	s := &Series{
+		Precision: Seconds,
+		Points: []*DataPoint{
+			{Timestamp: 0, Histogram: hist(10, 4, 2, 4)},
+			{Timestamp: 10, Histogram: hist(20, 6, 3, 6)},
+			{Timestamp: 20, Histogram: hist(5, 1, 1, 1)}, // reset
+		},
+	}
+	got, err := s.HistogramIncrease()
+	assert.Nil(err)
+	assert.Equal(&Histogram{Sum: 15, Count: 3, Buckets: []Bucket{{UpperBound: 1, Count: 2}, {UpperBound: 10, Count: 3}}}, got)
+
+	inc, err := s.Aggregate(AggregateIncrease)
+	assert.Nil(err)
+	assert.Equal(float64(3), inc)
+
+	_, err = (&Series{Points: []*DataPoint{{Value: 1}}}).HistogramIncrease()
+	assert.ErrorIs(err, ErrUnsupportedAggregate)
+}
+
+func TestValueTypes(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("float", ValueFloat.String())
+	assert.Equal("histogram", ValueHistogram.String())
+	assert.Equal("string", ValueString.String())
+	assert.Equal(ErrUnknown, ValueType(10).String())
+	assert.Equal("counter", MetricTypeCounter.String())
+	assert.Equal("event", MetricTypeEvent.String())
+	assert.Equal(ErrUnknown, MetricType(10).String())
+	assert.Equal("rate", AggregateRate.String())
+	assert.Equal(ErrUnknown, AggregateFunc(100).String())
+}
@@ -22,9 +22,17 @@ import (
 )
 
 var (
-	ErrNoDataPoints = errors.New("no data points found") // 数据不存在
-	ErrNoRowsData   = errors.New("no rows given")        // row empty
-	ErrUnknown      = "UNKNOWN"
+	ErrNoDataPoints    = errors.New("no data points found")    // 数据不存在
+	ErrNoRowsData      = errors.New("no rows given")           // row empty
+	ErrOverloaded      = errors.New("storage overloaded")      // 写入并发超限
+	ErrOutOfOrder      = errors.New("out of order sample")     // 早于所有可写partition的数据
+	ErrOutOfRetention  = errors.New("out of retention sample") // 超出retention的数据
+	ErrDuplicateSample = errors.New("duplicate sample")        // 相同时间戳的数据
+	ErrTypeMismatch    = errors.New("value type mismatch")     // 数据类型与metric类型不符
+	ErrUnknown         = "UNKNOWN"
+
+	// errDuplicateDropped 被DuplicateKeepFirst忽略的数据, 只用于InsertReport计数
+	errDuplicateDropped = errors.New("duplicate sample dropped")
 )
 
 type TimestampPrecision int
@@ -51,11 +59,36 @@ func (p TimestampPrecision) String() string {
 	}
 }
 
+// DuplicatePolicy decides what happens when a data point has the same timestamp as a stored one.
+type DuplicatePolicy int
+
+const (
+	DuplicateKeepFirst DuplicatePolicy = iota // 保留已有数据, 忽略新数据
+	DuplicateKeepLast                         // 新数据覆盖已有数据
+	DuplicateReject                           // 拒绝新数据, 并在InsertReport中返回
+)
+
+func (p DuplicatePolicy) String() string {
+	switch p {
+	case DuplicateKeepFirst:
+		return "keep_first"
+	case DuplicateKeepLast:
+		return "keep_last"
+	case DuplicateReject:
+		return "reject"
+	default:
+		return ErrUnknown
+	}
+}
+
 const (
 	defaultPartitionDuration     = 5 * time.Minute  // 数据块时间块
 	defaultRetention             = 24 * time.Hour   // 时间保留时间，通过checkExpiredInterval进行数据淘汰，数据最大保留时间 = defaultRetention+checkExpiredInterval
+	defaultCheckExpiredInterval  = time.Minute      // 默认数据淘汰周期
 	defaultTimestampPrecision    = Seconds          // 默认时间戳精度
 	defaultWriteTimeout          = 30 * time.Second // 数据写入超时时间
 	defaultWorkersLimit          = 1                // 默认处理的goroutine数
 	defaultwritablePartitionsNum = 2                // 默认可写入的Partition个数. 超过这时间数据丢弃
+	defaultOutOfOrderWindow      = 0                // 默认不接受早于metric最新数据的乱序数据
+	defaultDuplicatePolicy       = DuplicateKeepFirst
 )
@@ -37,3 +37,11 @@ func TestTimestampPrecision(t *testing.T) {
 	a = TimestampPrecision(10)
 	assert.Equal(a.String(), ErrUnknown)
 }
+
+func TestDuplicatePolicy(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("keep_first", DuplicateKeepFirst.String())
+	assert.Equal("keep_last", DuplicateKeepLast.String())
+	assert.Equal("reject", DuplicateReject.String())
+	assert.Equal(ErrUnknown, DuplicatePolicy(10).String())
+}
@@ -16,23 +16,61 @@ limitations under the License.
 
 package storage
 
+import "errors"
+
 type StorageInterface interface {
 	Reader
+	MetadataStore
 	// The precision of timestamps is nanoseconds by default. It can be changed using WithTimestampPrecision.
 	InsertRows(rows []Row) error
+	// InsertRowsWithReport inserts rows like InsertRows, and reports the rows which could not be stored.
+	InsertRowsWithReport(rows []Row) (*InsertReport, error)
+	// Snapshot writes a consistent copy of the storage into dir, it can be opened with Restore.
+	Snapshot(dir string) error
 	// Close gracefully shutdowns by flushing any unwritten data to the underlying disk partition.
 	Close() error
 }
 
 type Reader interface {
 	Select(name string, labels []Label, start, end int64) (points []*DataPoint, err error)
+	// SelectSeries is Select giving back the points along with the metadata of the metric.
+	SelectSeries(name string, labels []Label, start, end int64) (*Series, error)
+}
+
+// MetadataStore keeps the type of each metric name.
+type MetadataStore interface {
+	// SetMetadata sets the metadata of a metric name, rows not matching its type get rejected afterwards.
+	SetMetadata(name string, metadata MetricMetadata) error
+	// Metadata gives back the metadata of a metric name.
+	Metadata(name string) (MetricMetadata, bool)
 }
 
 type DataPoint struct {
-	// The actual value. This field must be set.
+	// The actual value. This field must be set for float points.
 	Value float64
 	// Unix timestamp.
 	Timestamp int64
+	// Histogram is set for histogram points.
+	Histogram *Histogram
+	// Text is set for string and event points.
+	Text string
+	// Kind is the kind of value the point carries. When it is left ValueFloat the kind is inferred
+	// from the fields set, so an event point with an empty Text must set it to ValueString.
+	Kind ValueType
+}
+
+// ValueType gives back the kind of value the point carries.
+func (p *DataPoint) ValueType() ValueType {
+	switch {
+	case p.Kind != ValueFloat:
+		return p.Kind
+	case p.Histogram != nil:
+		return ValueHistogram
+	case p.Text != "":
+		return ValueString
+	default:
+		return ValueFloat
+	}
 }
 
 type Row struct {
@@ -43,3 +81,30 @@ type Row struct {
 	// This field must be set.
 	DataPoint
 }
+
+// RejectedRow is a row which has not been stored, Reason is one of
+// ErrOutOfOrder, ErrOutOfRetention, ErrDuplicateSample or ErrTypeMismatch.
+type RejectedRow struct {
+	Row
+	Reason error
+}
+
+// InsertReport describes the result of InsertRowsWithReport.
+type InsertReport struct {
+	// The number of rows stored.
+	Inserted int
+	// The number of rows dropped by DuplicateKeepFirst, they are neither stored nor rejected.
+	Dropped  int
+	Rejected []RejectedRow
+}
+
+// Count returns the number of rejected rows for the given reason.
+func (r *InsertReport) Count(reason error) int {
+	n := 0
+	for i := range r.Rejected {
+		if errors.Is(r.Rejected[i].Reason, reason) {
+			n++
+		}
+	}
+	return n
+}
@@ -21,6 +21,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 // A memoryPartition implements a partition to store data points on heap.
@@ -38,33 +39,38 @@ type memoryPartition struct {
 	// The timestamp range of partitions after which they get persisted
 	partitionDuration  int64
 	timestampPrecision TimestampPrecision
-	once               sync.Once
+	// How far behind the newest point of a metric an out-of-order point is still accepted.
+	outOfOrderWindow int64
+	duplicatePolicy  DuplicatePolicy
+	once             sync.Once
 }
 
 func NewMemoryPartition(partitionDuration time.Duration, precision TimestampPrecision) partition {
-	var d int64
-	switch precision {
-	case Nanoseconds:
-		d = partitionDuration.Nanoseconds()
-	case Microseconds:
-		d = partitionDuration.Microseconds()
-	case Milliseconds:
-		d = partitionDuration.Milliseconds()
-	case Seconds:
-		d = int64(partitionDuration.Seconds())
-	default:
-		d = partitionDuration.Nanoseconds()
-	}
+	return newMemoryPartition(partitionDuration, precision, 0, DuplicateKeepFirst)
+}
+
+func newMemoryPartition(partitionDuration time.Duration, precision TimestampPrecision,
+	outOfOrderWindow time.Duration, duplicatePolicy DuplicatePolicy) partition {
 	return &memoryPartition{
-		partitionDuration:  d,
+		partitionDuration:  toPrecision(partitionDuration, precision),
 		timestampPrecision: precision,
+		outOfOrderWindow:   toPrecision(outOfOrderWindow, precision),
+		duplicatePolicy:    duplicatePolicy,
 	}
 }
 
 // insertRows inserts the given rows to partition.
 func (m *memoryPartition) insertRows(rows []Row) ([]Row, error) {
+	outdatedRows, _, err := m.appendRows(rows)
+	return outdatedRows, err
+}
+
+// appendRows inserts the given rows to partition, gives back the rows older than the partition
+// and the rows rejected by the out-of-order window or the duplicate policy.
+// Rows dropped by DuplicateKeepFirst are given back with errDuplicateDropped.
+func (m *memoryPartition) appendRows(rows []Row) ([]Row, []RejectedRow, error) {
 	if len(rows) == 0 {
-		return nil, ErrNoRowsData
+		return nil, nil, ErrNoRowsData
 	}
 
 	// Set min timestamp at only first.
@@ -80,8 +86,8 @@ func (m *memoryPartition) insertRows(rows []Row) ([]Row, error) {
 	})
 
 	outdatedRows := make([]Row, 0)
-	maxTimestamp := rows[0].Timestamp
-	var rowsNum int64
+	var rejectedRows []RejectedRow
+	var maxTimestamp, rowsNum int64
 	for i := range rows {
 		row := rows[i]
 		if row.Timestamp < m.minTimestamp() {
@@ -91,13 +97,19 @@ func (m *memoryPartition) insertRows(rows []Row) ([]Row, error) {
 		if row.Timestamp == 0 {
 			row.Timestamp = toUnix(time.Now(), m.timestampPrecision)
 		}
+		name := marshalMetricName(row.Name, row.Labels)
+		mt := m.getMetric(name, row.Name, row.Labels)
+		added, err := mt.insertPoint(&row.DataPoint, m.outOfOrderWindow, m.duplicatePolicy)
+		if err != nil {
+			rejectedRows = append(rejectedRows, RejectedRow{Row: row, Reason: err})
+			continue
+		}
 		if row.Timestamp > maxTimestamp {
 			maxTimestamp = row.Timestamp
 		}
-		name := marshalMetricName(row.Name, row.Labels)
-		mt := m.getMetric(name)
-		mt.insertPoint(&row.DataPoint)
-		rowsNum++
+		if added {
+			rowsNum++
+		}
 	}
 	atomic.AddInt64(&m.numPoints, rowsNum)
 
@@ -106,7 +118,7 @@ func (m *memoryPartition) insertRows(rows []Row) ([]Row, error) {
 		atomic.SwapInt64(&m.maxT, maxTimestamp)
 	}
 
-	return outdatedRows, nil
+	return outdatedRows, rejectedRows, nil
 }
 
 func toUnix(t time.Time, precision TimestampPrecision) int64 {
@@ -124,27 +136,86 @@ func toUnix(t time.Time, precision TimestampPrecision) int64 {
 	}
 }
 
+// toPrecision converts the given duration into the unit of the given precision.
+func toPrecision(d time.Duration, precision TimestampPrecision) int64 {
+	switch precision {
+	case Nanoseconds:
+		return d.Nanoseconds()
+	case Microseconds:
+		return d.Microseconds()
+	case Milliseconds:
+		return d.Milliseconds()
+	case Seconds:
+		return int64(d.Seconds())
+	default:
+		return d.Nanoseconds()
+	}
+}
+
 func (m *memoryPartition) selectDataPoints(metric string, labels []Label, start, end int64) ([]*DataPoint, error) {
 	name := marshalMetricName(metric, labels)
-	mt := m.getMetric(name)
-	return mt.selectPoints(start, end), nil
+	value, ok := m.metrics.Load(name)
+	if !ok {
+		return []*DataPoint{}, nil
+	}
+	return value.(*memoryMetric).selectPoints(start, end), nil
 }
 
 // getMetric gives back the reference to the metrics list whose name is the given one.
 // If none, it creates a new one.
-func (m *memoryPartition) getMetric(name string) *memoryMetric {
+func (m *memoryPartition) getMetric(name, metric string, labels []Label) *memoryMetric {
 	value, ok := m.metrics.Load(name)
 	if !ok {
-		value = &memoryMetric{
-			name:             name,
-			points:           make([]*DataPoint, 0, 1000),
-			outOfOrderPoints: make([]*DataPoint, 0),
-		}
-		m.metrics.Store(name, value)
+		value, _ = m.metrics.LoadOrStore(name, &memoryMetric{
+			name:   name,
+			metric: metric,
+			labels: append([]Label(nil), labels...),
+			points: make([]*DataPoint, 0, 1000),
+		})
 	}
 	return value.(*memoryMetric)
 }
 
+// bytes estimates the heap size of the data points the partition holds.
+func (m *memoryPartition) bytes() int64 {
+	var total int64
+	m.metrics.Range(func(_, value interface{}) bool {
+		total += value.(*memoryMetric).bytes()
+		return true
+	})
+	return total
+}
+
+// series gives back the marshaled names of the series the partition holds.
+func (m *memoryPartition) series() []string {
+	names := make([]string, 0)
+	m.metrics.Range(func(key, _ interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	return names
+}
+
+// trim removes the points older than the boundary given back for each series, and returns the number of removed points.
+func (m *memoryPartition) trim(boundary func(metric string, labels []Label) (int64, bool)) int {
+	var removed int
+	m.metrics.Range(func(key, value interface{}) bool {
+		mt := value.(*memoryMetric)
+		b, ok := boundary(mt.metric, mt.labels)
+		if !ok {
+			return true
+		}
+		n, empty := mt.trimBefore(b)
+		if empty {
+			m.metrics.Delete(key)
+		}
+		removed += n
+		return true
+	})
+	atomic.AddInt64(&m.numPoints, -int64(removed))
+	return removed
+}
+
 func (m *memoryPartition) minTimestamp() int64 {
 	return atomic.LoadInt64(&m.minT)
 }
@@ -174,17 +245,19 @@ func (m *memoryPartition) expired() bool {
 // memoryMetric has a list of ordered data points that belong to the memoryMetric
 type memoryMetric struct {
 	name         string
+	metric       string
+	labels       []Label
 	size         int64
 	minTimestamp int64
 	maxTimestamp int64
 	// points must kept in order
-	points           []*DataPoint
-	outOfOrderPoints []*DataPoint
-	mu               sync.RWMutex
+	points []*DataPoint
+	mu     sync.RWMutex
 }
 
-func (m *memoryMetric) insertPoint(point *DataPoint) {
-	size := atomic.LoadInt64(&m.size)
+// insertPoint inserts the point in order. added reports whether the number of points grows,
+// it is false when a duplicated point is kept or replaced.
+func (m *memoryMetric) insertPoint(point *DataPoint, outOfOrderWindow int64, policy DuplicatePolicy) (added bool, err error) {
 	// TODO: Consider to stop using mutex every time.
 	//   Instead, fix the capacity of points slice, kind of like:
 	/*
@@ -195,6 +268,7 @@ func (m *memoryMetric) insertPoint(point *DataPoint) {
 	*/
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	size := atomic.LoadInt64(&m.size)
 
 	// First insertion
 	if size == 0 {
@@ -202,21 +276,55 @@ func (m *memoryMetric) insertPoint(point *DataPoint) {
 		atomic.StoreInt64(&m.minTimestamp, point.Timestamp)
 		atomic.StoreInt64(&m.maxTimestamp, point.Timestamp)
 		atomic.AddInt64(&m.size, 1)
-		return
+		return true, nil
 	}
 	// Insert point in order
 	if m.points[size-1].Timestamp < point.Timestamp {
 		m.points = append(m.points, point)
 		atomic.StoreInt64(&m.maxTimestamp, point.Timestamp)
 		atomic.AddInt64(&m.size, 1)
-		return
+		return true, nil
 	}
 
-	m.outOfOrderPoints = append(m.outOfOrderPoints, point)
+	if point.Timestamp < m.points[size-1].Timestamp-outOfOrderWindow {
+		return false, ErrOutOfOrder
+	}
+
+	// Slices given back by selectPoints share the underlying array,
+	// so the points are copied instead of being shifted in place.
+	idx := sort.Search(int(size), func(i int) bool {
+		return m.points[i].Timestamp >= point.Timestamp
+	})
+	if m.points[idx].Timestamp == point.Timestamp {
+		switch policy {
+		case DuplicateKeepLast:
+			points := make([]*DataPoint, size, cap(m.points))
+			copy(points, m.points)
+			points[idx] = point
+			m.points = points
+			return false, nil
+		case DuplicateReject:
+			return false, ErrDuplicateSample
+		default:
+			return false, errDuplicateDropped
+		}
+	}
+	points := make([]*DataPoint, size+1, cap(m.points)+1)
+	copy(points, m.points[:idx])
+	points[idx] = point
+	copy(points[idx+1:], m.points[idx:size])
+	m.points = points
+	if idx == 0 {
+		atomic.StoreInt64(&m.minTimestamp, point.Timestamp)
+	}
+	atomic.AddInt64(&m.size, 1)
+	return true, nil
 }
 
 // selectPoints returns a new slice by re-slicing with [startIdx:endIdx].
 func (m *memoryMetric) selectPoints(start, end int64) []*DataPoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	size := atomic.LoadInt64(&m.size)
 	minTimestamp := atomic.LoadInt64(&m.minTimestamp)
 	maxTimestamp := atomic.LoadInt64(&m.maxTimestamp)
@@ -226,8 +334,6 @@ func (m *memoryMetric) selectPoints(start, end int64) []*DataPoint {
 		return []*DataPoint{}
 	}
 
-	m.mu.RLock()
-	defer m.mu.RUnlock()
 	if start <= minTimestamp {
 		startIdx = 0
 	} else {
@@ -247,3 +353,40 @@ func (m *memoryMetric) selectPoints(start, end int64) []*DataPoint {
 	}
 	return m.points[startIdx:endIdx]
 }
+
+// trimBefore removes the points older than boundary, it gives back the number of removed points
+// and whether the metric became empty.
+func (m *memoryMetric) trimBefore(boundary int64) (removed int, empty bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	size := int(atomic.LoadInt64(&m.size))
+	idx := sort.Search(size, func(i int) bool {
+		return m.points[i].Timestamp >= boundary
+	})
+	if idx == 0 {
+		return 0, size == 0
+	}
+	// Slices given back by selectPoints share the underlying array, so keep it untouched.
+	m.points = append(make([]*DataPoint, 0, size-idx), m.points[idx:size]...)
+	atomic.StoreInt64(&m.size, int64(size-idx))
+	if idx < size {
+		atomic.StoreInt64(&m.minTimestamp, m.points[0].Timestamp)
+	}
+	return idx, idx == size
+}
+
+// pointBytes is the estimated heap size of a float DataPoint and its pointer.
+const pointBytes = int64(unsafe.Sizeof(DataPoint{})) + int64(unsafe.Sizeof(uintptr(0)))
+
+func (m *memoryMetric) bytes() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	total := int64(len(m.name))
+	for _, p := range m.points {
+		total += pointBytes + int64(len(p.Text))
+		if p.Histogram != nil {
+			total += int64(unsafe.Sizeof(Histogram{})) + int64(len(p.Histogram.Buckets))*int64(unsafe.Sizeof(Bucket{}))
+		}
+	}
+	return total
+}
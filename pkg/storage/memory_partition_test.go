@@ -245,3 +245,108 @@ func Test_toUnix(t *testing.T) {
 		})
 	}
 }
+
+func Test_memoryPartition_appendRows(t *testing.T) {
+	tests := []struct {
+		name           string
+		window         time.Duration
+		policy         DuplicatePolicy
+		rows           []Row
+		wantDataPoints []*DataPoint
+		wantRejected   []RejectedRow
+	}{
+		{
+			name: "reject out-of-order rows without window",
+			rows: []Row{
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.1}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 3, Value: 0.3}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 2, Value: 0.2}},
+			},
+			wantDataPoints: []*DataPoint{
+				{Timestamp: 1, Value: 0.1},
+				{Timestamp: 3, Value: 0.3},
+			},
+			wantRejected: []RejectedRow{
+				{Row: Row{Name: "metric1", DataPoint: DataPoint{Timestamp: 2, Value: 0.2}}, Reason: ErrOutOfOrder},
+			},
+		},
+		{
+			name:   "accept out-of-order rows within window",
+			window: 2 * time.Second,
+			rows: []Row{
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.1}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 4, Value: 0.4}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 3, Value: 0.3}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 2, Value: 0.2}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.5}},
+			},
+			wantDataPoints: []*DataPoint{
+				{Timestamp: 1, Value: 0.1},
+				{Timestamp: 2, Value: 0.2},
+				{Timestamp: 3, Value: 0.3},
+				{Timestamp: 4, Value: 0.4},
+			},
+			wantRejected: []RejectedRow{
+				{Row: Row{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.5}}, Reason: ErrOutOfOrder},
+			},
+		},
+		{
+			name:   "keep first duplicated row",
+			policy: DuplicateKeepFirst,
+			rows: []Row{
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.1}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.2}},
+			},
+			wantDataPoints: []*DataPoint{
+				{Timestamp: 1, Value: 0.1},
+			},
+			wantRejected: []RejectedRow{
+				{Row: Row{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.2}}, Reason: errDuplicateDropped},
+			},
+		},
+		{
+			name:   "keep last duplicated row",
+			policy: DuplicateKeepLast,
+			window: time.Second,
+			rows: []Row{
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.1}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 2, Value: 0.2}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.3}},
+			},
+			wantDataPoints: []*DataPoint{
+				{Timestamp: 1, Value: 0.3},
+				{Timestamp: 2, Value: 0.2},
+			},
+		},
+		{
+			name:   "reject duplicated row",
+			policy: DuplicateReject,
+			rows: []Row{
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.1}},
+				{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.2}},
+			},
+			wantDataPoints: []*DataPoint{
+				{Timestamp: 1, Value: 0.1},
+			},
+			wantRejected: []RejectedRow{
+				{Row: Row{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 0.2}}, Reason: ErrDuplicateSample},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMemoryPartition(time.Hour, Seconds, tt.window, tt.policy).(*memoryPartition)
+			var rejected []RejectedRow
+			for _, row := range tt.rows {
+				_, r, err := m.appendRows([]Row{row})
+				assert.Nil(t, err)
+				rejected = append(rejected, r...)
+			}
+			assert.Equal(t, tt.wantRejected, rejected)
+			assert.Equal(t, len(tt.wantDataPoints), m.size())
+
+			got, _ := m.selectDataPoints("metric1", nil, 0, 5)
+			assert.Equal(t, tt.wantDataPoints, got)
+		})
+	}
+}
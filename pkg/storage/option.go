@@ -19,6 +19,8 @@ package storage
 import (
 	"time"
 
+	"github.com/uber-go/tally"
+
 	"github.com/kubeservice-stack/common/pkg/logger"
 )
 
@@ -38,6 +40,36 @@ func WithRetention(retention time.Duration) Option {
 	}
 }
 
+// Defaults to 0, which means no limit. The oldest partitions are removed once the
+// estimated size of the data points exceeds maxBytes.
+func WithMaxBytes(maxBytes int64) Option {
+	return func(s *Storage) {
+		s.maxBytes = maxBytes
+	}
+}
+
+// WithRetentionRules sets per-metric or per-label retentions, the first matching rule applies.
+func WithRetentionRules(rules ...RetentionRule) Option {
+	return func(s *Storage) {
+		s.retentionRules = append(s.retentionRules, rules...)
+	}
+}
+
+// Defaults to 1min.
+func WithCheckExpiredInterval(interval time.Duration) Option {
+	return func(s *Storage) {
+		s.checkExpiredInterval = interval
+	}
+}
+
+// WithMetricsScope reports the partitions, bytes, series, points and evictions gauges into scope.
+// Defaults to no metrics.
+func WithMetricsScope(scope tally.Scope) Option {
+	return func(s *Storage) {
+		s.scope = scope
+	}
+}
+
 // Defaults to Nanoseconds
 func WithTimestampPrecision(precision TimestampPrecision) Option {
 	return func(s *Storage) {
@@ -58,3 +90,17 @@ func WithLogger(logger *logger.Logger) Option {
 		s.logger = logger
 	}
 }
+
+// Defaults to 0, a data point older than the newest one of its metric gets rejected.
+func WithOutOfOrderWindow(window time.Duration) Option {
+	return func(s *Storage) {
+		s.outOfOrderWindow = window
+	}
+}
+
+// Defaults to DuplicateKeepFirst.
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(s *Storage) {
+		s.duplicatePolicy = policy
+	}
+}
@@ -20,7 +20,12 @@ type partition interface {
 	// Write operations
 
 	insertRows(rows []Row) (outdatedRows []Row, err error)
+	// appendRows is insertRows which also gives back the rows rejected inside the partition.
+	appendRows(rows []Row) (outdatedRows []Row, rejectedRows []RejectedRow, err error)
 	clean() error
+	// snapshot writes a copy of the partition into file, which is serialized for
+	// in-memory partitions and hard-linked for file backed ones.
+	snapshot(file string) error
 
 	// Read operations
 
@@ -31,6 +36,13 @@ type partition interface {
 	maxTimestamp() int64
 	// size returns the number of data points the partition holds.
 	size() int
+	// bytes returns the estimated memory size of the data points the partition holds.
+	bytes() int64
+	// series returns the marshaled names of the series the partition holds.
+	series() []string
+	// trim removes the points of each series older than the boundary given back for it,
+	// series for which ok is false are kept. It returns the number of removed points.
+	trim(boundary func(metric string, labels []Label) (b int64, ok bool)) int
 	// active means not only writable but having the qualities to be the head partition.
 	active() bool
 	// expired means it should get removed.
@@ -35,6 +35,26 @@ func (f *fakePartition) insertRows(_ []Row) ([]Row, error) {
 	return nil, f.err
 }
 
+func (f *fakePartition) appendRows(_ []Row) ([]Row, []RejectedRow, error) {
+	return nil, nil, f.err
+}
+
+func (f *fakePartition) snapshot(_ string) error {
+	return f.err
+}
+
+func (f *fakePartition) bytes() int64 {
+	return 0
+}
+
+func (f *fakePartition) series() []string {
+	return nil
+}
+
+func (f *fakePartition) trim(_ func(string, []Label) (int64, bool)) int {
+	return 0
+}
+
 func (f *fakePartition) selectDataPoints(_ string, _ []Label, _, _ int64) ([]*DataPoint, error) {
 	return nil, f.err
 }
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/klauspost/compress/snappy"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+const defaultMaxRemoteWriteBytes = 32 << 20 // 单次remote_write请求体上限 32MiB
+
+// remoteWriteHandler receives samples pushed by prometheus remote_write compatible agents.
+type remoteWriteHandler struct {
+	storage   StorageInterface
+	precision TimestampPrecision
+	logger    *logger.Logger
+}
+
+// NewRemoteWriteHandler returns a http.Handler accepting snappy-compressed remote_write requests
+// and inserting the samples into the given storage.
+//
+//	stg, _ := storage.NewStorage()
+//	http.Handle("/api/v1/write", storage.NewRemoteWriteHandler(stg))
+//
+// It answers 204 when all samples are stored, 400 for malformed requests and samples
+// out of order, out of retention or duplicated (which must not be retried), and 503 when the storage is overloaded.
+func NewRemoteWriteHandler(stg StorageInterface) http.Handler {
+	h := &remoteWriteHandler{
+		storage:   stg,
+		precision: defaultTimestampPrecision,
+		logger:    logger.GetLogger("pkg/common/storage", "remote_write"),
+	}
+	if s, ok := stg.(*Storage); ok {
+		h.precision = s.timestampPrecision
+		if s.logger != nil {
+			h.logger = s.logger
+		}
+	}
+	return h
+}
+
+func (h *remoteWriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	compressed, err := io.ReadAll(io.LimitReader(r.Body, defaultMaxRemoteWriteBytes+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(compressed) > defaultMaxRemoteWriteBytes {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	buf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", ErrInvalidWriteRequest, err), http.StatusBadRequest)
+		return
+	}
+	rows, err := decodeWriteRequest(buf, h.precision)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rows) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := h.write(rows); err != nil {
+		h.logger.Warn("remote write failed", logger.Error(err), logger.Int64("rows", int64(len(rows))))
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *remoteWriteHandler) write(rows []Row) error {
+	report, err := h.storage.InsertRowsWithReport(rows)
+	if err != nil {
+		return err
+	}
+	for _, reason := range []error{ErrOutOfRetention, ErrOutOfOrder, ErrDuplicateSample} {
+		if n := report.Count(reason); n > 0 {
+			return fmt.Errorf("%w: %d of %d samples dropped", reason, n, len(rows))
+		}
+	}
+	return nil
+}
+
+// statusCode maps an insert error to the http status remote_write clients understand:
+// 4xx are dropped by the client, 5xx are retried.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrOutOfOrder), errors.Is(err, ErrOutOfRetention),
+		errors.Is(err, ErrDuplicateSample), errors.Is(err, ErrNoRowsData):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrOverloaded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
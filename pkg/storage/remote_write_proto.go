@@ -0,0 +1,203 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 按照 prometheus prompb 的字段编号解析 remote_write 协议:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+//
+// 其余字段(metadata, exemplars, histograms)直接跳过.
+const (
+	metricNameLabel = "__name__"
+
+	writeRequestTimeseriesField = 1
+	timeSeriesLabelsField       = 1
+	timeSeriesSamplesField      = 2
+	labelNameField              = 1
+	labelValueField             = 2
+	sampleValueField            = 1
+	sampleTimestampField        = 2
+)
+
+var ErrInvalidWriteRequest = errors.New("invalid remote write request")
+
+// decodeWriteRequest decodes an uncompressed remote_write protobuf payload into rows.
+// The timestamps of remote_write are milliseconds, they are converted into the given precision.
+func decodeWriteRequest(buf []byte, precision TimestampPrecision) ([]Row, error) {
+	rows := make([]Row, 0)
+	err := walkFields(buf, func(num protowire.Number, typ protowire.Type, val []byte) error {
+		if num != writeRequestTimeseriesField || typ != protowire.BytesType {
+			return nil
+		}
+		var err error
+		rows, err = decodeTimeSeries(val, precision, rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func decodeTimeSeries(buf []byte, precision TimestampPrecision, rows []Row) ([]Row, error) {
+	var (
+		name   string
+		labels []Label
+		points []DataPoint
+	)
+	err := walkFields(buf, func(num protowire.Number, typ protowire.Type, val []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case timeSeriesLabelsField:
+			label, err := decodeLabel(val)
+			if err != nil {
+				return err
+			}
+			if label.Key == metricNameLabel {
+				name = label.Value
+				return nil
+			}
+			labels = append(labels, label)
+		case timeSeriesSamplesField:
+			point, err := decodeSample(val, precision)
+			if err != nil {
+				return err
+			}
+			points = append(points, point)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%w: time series without %s label", ErrInvalidWriteRequest, metricNameLabel)
+	}
+	for i := range points {
+		rows = append(rows, Row{
+			Name:      name,
+			Labels:    append([]Label(nil), labels...),
+			DataPoint: points[i],
+		})
+	}
+	return rows, nil
+}
+
+func decodeLabel(buf []byte) (Label, error) {
+	var label Label
+	err := walkFields(buf, func(num protowire.Number, typ protowire.Type, val []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case labelNameField:
+			label.Key = string(val)
+		case labelValueField:
+			label.Value = string(val)
+		}
+		return nil
+	})
+	return label, err
+}
+
+func decodeSample(buf []byte, precision TimestampPrecision) (DataPoint, error) {
+	var point DataPoint
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			return point, fmt.Errorf("%w: %s", ErrInvalidWriteRequest, protowire.ParseError(n))
+		}
+		buf = buf[n:]
+		switch {
+		case num == sampleValueField && typ == protowire.Fixed64Type:
+			v, m := protowire.ConsumeFixed64(buf)
+			if m < 0 {
+				return point, fmt.Errorf("%w: %s", ErrInvalidWriteRequest, protowire.ParseError(m))
+			}
+			point.Value = math.Float64frombits(v)
+			n = m
+		case num == sampleTimestampField && typ == protowire.VarintType:
+			v, m := protowire.ConsumeVarint(buf)
+			if m < 0 {
+				return point, fmt.Errorf("%w: %s", ErrInvalidWriteRequest, protowire.ParseError(m))
+			}
+			point.Timestamp = fromMilliseconds(int64(v), precision)
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, buf)
+			if n < 0 {
+				return point, fmt.Errorf("%w: %s", ErrInvalidWriteRequest, protowire.ParseError(n))
+			}
+		}
+		buf = buf[n:]
+	}
+	return point, nil
+}
+
+// walkFields iterates over the top level fields of a protobuf message.
+// val is the payload for length-delimited fields and nil otherwise.
+func walkFields(buf []byte, fn func(num protowire.Number, typ protowire.Type, val []byte) error) error {
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidWriteRequest, protowire.ParseError(n))
+		}
+		buf = buf[n:]
+		var val []byte
+		if typ == protowire.BytesType {
+			val, n = protowire.ConsumeBytes(buf)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, buf)
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidWriteRequest, protowire.ParseError(n))
+		}
+		buf = buf[n:]
+		if err := fn(num, typ, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fromMilliseconds(ts int64, precision TimestampPrecision) int64 {
+	switch precision {
+	case Nanoseconds:
+		return ts * 1e6
+	case Microseconds:
+		return ts * 1e3
+	case Milliseconds:
+		return ts
+	case Seconds:
+		return ts / 1e3
+	default:
+		return ts * 1e6
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type testSample struct {
+	value     float64
+	timestamp int64
+}
+
+func encodeTestSeries(labels map[string]string, samples ...testSample) []byte {
+	var series []byte
+	for k, v := range labels {
+		var label []byte
+		label = protowire.AppendTag(label, labelNameField, protowire.BytesType)
+		label = protowire.AppendString(label, k)
+		label = protowire.AppendTag(label, labelValueField, protowire.BytesType)
+		label = protowire.AppendString(label, v)
+		series = protowire.AppendTag(series, timeSeriesLabelsField, protowire.BytesType)
+		series = protowire.AppendBytes(series, label)
+	}
+	for _, s := range samples {
+		var sample []byte
+		sample = protowire.AppendTag(sample, sampleValueField, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, sampleTimestampField, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		series = protowire.AppendTag(series, timeSeriesSamplesField, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+	}
+	var req []byte
+	req = protowire.AppendTag(req, writeRequestTimeseriesField, protowire.BytesType)
+	return protowire.AppendBytes(req, series)
+}
+
+func postRemoteWrite(h http.Handler, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, body)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_decodeWriteRequest(t *testing.T) {
+	assert := assert.New(t)
+	body := encodeTestSeries(map[string]string{"__name__": "cpu", "host": "a"},
+		testSample{value: 0.5, timestamp: 1600000000000},
+		testSample{value: 0.6, timestamp: 1600000001000},
+	)
+	rows, err := decodeWriteRequest(body, Seconds)
+	assert.Nil(err)
+	assert.Equal([]Row{
+		{Name: "cpu", Labels: []Label{{Key: "host", Value: "a"}}, DataPoint: DataPoint{Value: 0.5, Timestamp: 1600000000}},
+		{Name: "cpu", Labels: []Label{{Key: "host", Value: "a"}}, DataPoint: DataPoint{Value: 0.6, Timestamp: 1600000001}},
+	}, rows)
+
+	_, err = decodeWriteRequest(encodeTestSeries(map[string]string{"host": "a"}, testSample{timestamp: 1}), Seconds)
+	assert.ErrorIs(err, ErrInvalidWriteRequest)
+
+	_, err = decodeWriteRequest([]byte{0x0a, 0xff}, Seconds)
+	assert.ErrorIs(err, ErrInvalidWriteRequest)
+
+	assert.Equal(int64(1000000), fromMilliseconds(1, Nanoseconds))
+	assert.Equal(int64(1000), fromMilliseconds(1, Microseconds))
+	assert.Equal(int64(1), fromMilliseconds(1, Milliseconds))
+}
+
+func Test_remoteWriteHandler(t *testing.T) {
+	assert := assert.New(t)
+	stg, err := NewStorage(
+		WithPartitionDuration(1*time.Hour),
+		WithTimestampPrecision(Seconds),
+		WithRetention(10*time.Minute),
+	)
+	assert.Nil(err)
+	defer stg.Close()
+	h := NewRemoteWriteHandler(stg)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/write", nil))
+	assert.Equal(http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader([]byte("not snappy"))))
+	assert.Equal(http.StatusBadRequest, rec.Code)
+
+	rec = postRemoteWrite(h, encodeTestSeries(map[string]string{"__name__": "cpu", "host": "a"},
+		testSample{value: 1, timestamp: 1600000000000},
+		testSample{value: 2, timestamp: 1600000001000},
+	))
+	assert.Equal(http.StatusNoContent, rec.Code)
+
+	points, err := stg.Select("cpu", []Label{{Key: "host", Value: "a"}}, 1600000000, 1600000002)
+	assert.Nil(err)
+	assert.Equal([]*DataPoint{
+		{Value: 1, Timestamp: 1600000000},
+		{Value: 2, Timestamp: 1600000001},
+	}, points)
+
+	// older than the head partition min timestamp.
+	rec = postRemoteWrite(h, encodeTestSeries(map[string]string{"__name__": "cpu"},
+		testSample{value: 1, timestamp: 1599999999000},
+	))
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.Contains(rec.Body.String(), ErrOutOfOrder.Error())
+
+	// older than the retention.
+	rec = postRemoteWrite(h, encodeTestSeries(map[string]string{"__name__": "cpu"},
+		testSample{value: 1, timestamp: 1599990000000},
+	))
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.Contains(rec.Body.String(), ErrOutOfRetention.Error())
+
+	assert.Equal(http.StatusServiceUnavailable, statusCode(ErrOverloaded))
+	assert.Equal(http.StatusInternalServerError, statusCode(ErrNoDataPoints))
+}
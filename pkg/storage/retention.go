@@ -0,0 +1,193 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/logger"
+)
+
+// RetentionRule keeps the series matching Metric and Labels for Retention,
+// instead of the global retention set by WithRetention.
+type RetentionRule struct {
+	// Metric name to match, empty matches every metric.
+	Metric string
+	// Labels the series must all have, empty matches every series.
+	Labels []Label
+	// Retention of the matching series. A rule longer than the global retention has no effect,
+	// since whole partitions are removed by the global retention.
+	Retention time.Duration
+}
+
+func (r RetentionRule) match(metric string, labels []Label) bool {
+	if r.Metric != "" && r.Metric != metric {
+		return false
+	}
+	for _, want := range r.Labels {
+		found := false
+		for _, label := range labels {
+			if label.Key == want.Key && label.Value == want.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats describes what a storage holds.
+type Stats struct {
+	Partitions int
+	// Estimated memory size of the data points.
+	Bytes  int64
+	Series int
+	Points int
+	// Partitions and points removed by the retention policies since the storage started.
+	EvictedPartitions int64
+	EvictedPoints     int64
+}
+
+// Stats gives back the current partition count, size, series count and evictions.
+func (s *Storage) Stats() Stats {
+	stats := Stats{
+		EvictedPartitions: atomic.LoadInt64(&s.evictedPartitions),
+		EvictedPoints:     atomic.LoadInt64(&s.evictedPoints),
+	}
+	series := make(map[string]struct{})
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		part := iterator.value()
+		if part == nil {
+			continue
+		}
+		stats.Partitions++
+		stats.Bytes += part.bytes()
+		stats.Points += part.size()
+		for _, name := range part.series() {
+			series[name] = struct{}{}
+		}
+	}
+	stats.Series = len(series)
+	return stats
+}
+
+// retentionLoop applies the retention policies every checkExpiredInterval until the storage is closed.
+func (s *Storage) retentionLoop() {
+	if s.checkExpiredInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.checkExpiredInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.doneCh:
+			return
+		case <-ticker.C:
+			if err := s.applyRetention(); err != nil {
+				s.logger.Error("failed to apply retention", logger.Error(err))
+			}
+		}
+	}
+}
+
+// applyRetention removes the data points older than the per-metric rules, the partitions older
+// than the global retention, then the oldest partitions until the storage fits into maxBytes.
+func (s *Storage) applyRetention() error {
+	if head := s.partitionList.getHead(); head != nil && head.maxTimestamp() > 0 && len(s.retentionRules) > 0 {
+		headMax := head.maxTimestamp()
+		iterator := s.partitionList.newIterator()
+		for iterator.next() {
+			removed := iterator.value().trim(func(metric string, labels []Label) (int64, bool) {
+				for _, rule := range s.retentionRules {
+					if rule.match(metric, labels) {
+						return headMax - toPrecision(rule.Retention, s.timestampPrecision), true
+					}
+				}
+				return 0, false
+			})
+			atomic.AddInt64(&s.evictedPoints, int64(removed))
+		}
+	}
+
+	if err := s.removeExpiredPartitions(); err != nil {
+		return fmt.Errorf("failed to remove expired partitions: %w", err)
+	}
+	if err := s.removeOversizedPartitions(); err != nil {
+		return fmt.Errorf("failed to remove oversized partitions: %w", err)
+	}
+	s.reportStats()
+	return nil
+}
+
+// removeOversizedPartitions removes the oldest partitions, but the head one, while the storage exceeds maxBytes.
+func (s *Storage) removeOversizedPartitions() error {
+	if s.maxBytes <= 0 {
+		return nil
+	}
+	var (
+		parts []partition
+		sizes []int64
+		total int64
+	)
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		part := iterator.value()
+		if part == nil {
+			return fmt.Errorf("unexpected nil partition found")
+		}
+		parts = append(parts, part)
+		sizes = append(sizes, part.bytes())
+		total += sizes[len(sizes)-1]
+	}
+	for i := len(parts) - 1; i > 0 && total > s.maxBytes; i-- {
+		if err := s.evictPartition(parts[i]); err != nil {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+func (s *Storage) evictPartition(part partition) error {
+	points := part.size()
+	if err := s.partitionList.remove(part); err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.evictedPartitions, 1)
+	atomic.AddInt64(&s.evictedPoints, int64(points))
+	if s.scope != nil {
+		s.scope.Counter("evictions").Inc(1)
+	}
+	return nil
+}
+
+func (s *Storage) reportStats() {
+	if s.scope == nil {
+		return
+	}
+	stats := s.Stats()
+	s.scope.Gauge("partitions").Update(float64(stats.Partitions))
+	s.scope.Gauge("bytes").Update(float64(stats.Bytes))
+	s.scope.Gauge("series").Update(float64(stats.Series))
+	s.scope.Gauge("points").Update(float64(stats.Points))
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uber-go/tally"
+)
+
+func TestRetentionRule_match(t *testing.T) {
+	assert := assert.New(t)
+	labels := []Label{{Key: "host", Value: "a"}, {Key: "env", Value: "dev"}}
+	assert.True(RetentionRule{}.match("cpu", labels))
+	assert.True(RetentionRule{Metric: "cpu"}.match("cpu", labels))
+	assert.False(RetentionRule{Metric: "mem"}.match("cpu", labels))
+	assert.True(RetentionRule{Labels: []Label{{Key: "env", Value: "dev"}}}.match("cpu", labels))
+	assert.False(RetentionRule{Labels: []Label{{Key: "env", Value: "prod"}}}.match("cpu", labels))
+}
+
+func Test_storage_applyRetention(t *testing.T) {
+	assert := assert.New(t)
+	scope := tally.NewTestScope("storage", nil)
+	s := newStorage(
+		WithPartitionDuration(100*time.Second),
+		WithTimestampPrecision(Seconds),
+		WithRetention(1*time.Hour),
+		WithRetentionRules(RetentionRule{Metric: "debug", Retention: 10 * time.Second}),
+		WithMetricsScope(scope),
+	)
+	assert.Nil(s.newPartition(nil))
+	defer s.Close()
+
+	var rows []Row
+	for ts := int64(1600000000); ts < 1600000050; ts++ {
+		rows = append(rows, Row{Name: "cpu", DataPoint: DataPoint{Value: 1, Timestamp: ts}})
+		rows = append(rows, Row{Name: "debug", DataPoint: DataPoint{Value: 1, Timestamp: ts}})
+	}
+	assert.Nil(s.InsertRows(rows))
+
+	stats := s.Stats()
+	assert.Equal(1, stats.Partitions)
+	assert.Equal(2, stats.Series)
+	assert.Equal(100, stats.Points)
+	assert.True(stats.Bytes > 0)
+
+	assert.Nil(s.applyRetention())
+	points, err := s.Select("debug", nil, 1600000000, 1600000050)
+	assert.Nil(err)
+	assert.Len(points, 11)
+	assert.Equal(int64(1600000039), points[0].Timestamp)
+	points, err = s.Select("cpu", nil, 1600000000, 1600000050)
+	assert.Nil(err)
+	assert.Len(points, 50)
+
+	stats = s.Stats()
+	assert.Equal(61, stats.Points)
+	assert.Equal(int64(39), stats.EvictedPoints)
+	assert.Equal(int64(0), stats.EvictedPartitions)
+	assert.Equal(float64(2), scope.Snapshot().Gauges()["storage.series+"].Value())
+}
+
+func Test_storage_removeExpiredPartitions(t *testing.T) {
+	assert := assert.New(t)
+	s := newStorage(
+		WithPartitionDuration(10*time.Second),
+		WithTimestampPrecision(Seconds),
+		WithRetention(30*time.Second),
+	)
+	assert.Nil(s.newPartition(nil))
+	defer s.Close()
+
+	old := NewMemoryPartition(10*time.Second, Seconds)
+	_, err := old.insertRows([]Row{{Name: "cpu", DataPoint: DataPoint{Timestamp: 1600000000}}})
+	assert.Nil(err)
+	head := NewMemoryPartition(10*time.Second, Seconds)
+	_, err = head.insertRows([]Row{{Name: "cpu", DataPoint: DataPoint{Timestamp: 1600000100}}})
+	assert.Nil(err)
+	s.partitionList = newPartitionList()
+	s.partitionList.insert(old)
+	s.partitionList.insert(head)
+
+	assert.Nil(s.removeExpiredPartitions())
+	stats := s.Stats()
+	assert.Equal(1, stats.Partitions)
+	assert.Equal(int64(1), stats.EvictedPartitions)
+	assert.Equal(int64(1), stats.EvictedPoints)
+}
+
+func Test_storage_removeOversizedPartitions(t *testing.T) {
+	assert := assert.New(t)
+	s := newStorage(WithPartitionDuration(10*time.Second), WithTimestampPrecision(Seconds))
+	defer s.Close()
+
+	for i := int64(0); i < 3; i++ {
+		p := NewMemoryPartition(10*time.Second, Seconds)
+		_, err := p.insertRows([]Row{
+			{Name: "cpu", DataPoint: DataPoint{Timestamp: 1600000000 + i*10}},
+			{Name: "cpu", DataPoint: DataPoint{Timestamp: 1600000001 + i*10}},
+		})
+		assert.Nil(err)
+		s.partitionList.insert(p)
+	}
+	s.maxBytes = s.partitionList.getHead().bytes() + 1
+
+	assert.Nil(s.removeOversizedPartitions())
+	stats := s.Stats()
+	assert.Equal(1, stats.Partitions)
+	assert.Equal(int64(2), stats.EvictedPartitions)
+	assert.Equal(int64(4), stats.EvictedPoints)
+	points, err := s.Select("cpu", nil, 1600000000, 1600000030)
+	assert.Nil(err)
+	assert.Len(points, 2)
+}
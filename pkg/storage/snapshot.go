@@ -0,0 +1,273 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubeservice-stack/common/pkg/codec"
+	"github.com/kubeservice-stack/common/pkg/utils"
+)
+
+/*
+	snapshot 目录结构:
+
+	<dir>
+	├── manifest          精度, partition时长, metric元数据
+	├── partition-0000    最新的partition
+	├── partition-0001
+	└── ...
+
+内存partition序列化为msgpack文件; 目前只支持内存partition, 没有基于文件的partition, 不做数据文件的硬链接.
+快照先写入同级临时目录, 全部落盘后rename为<dir>, 保证快照原子可见.
+*/
+const (
+	snapshotVersion        = 1
+	snapshotManifestFile   = "manifest"
+	snapshotPartitionFile  = "partition-%04d"
+	snapshotTempDirPattern = ".%s.tmp-*"
+)
+
+var ErrSnapshotExists = errors.New("snapshot directory already exists")
+
+var snapshotCodec = codec.NewMSGPack()
+
+type snapshotManifest struct {
+	Version           int
+	Precision         TimestampPrecision
+	PartitionDuration time.Duration
+	Partitions        int
+	Metadata          map[string]MetricMetadata
+}
+
+type snapshotPartition struct {
+	MinT      int64
+	MaxT      int64
+	NumPoints int64
+	Metrics   []snapshotMetric
+}
+
+type snapshotMetric struct {
+	// Name is the marshaled metric name, see marshalMetricName.
+	Name string
+	// Metric and Labels are kept for the retention rules to match the restored series.
+	Metric string
+	Labels []Label
+	Points []*DataPoint
+}
+
+// Snapshot writes a consistent copy of all partitions into dir, which must not exist.
+// Writes are blocked while the partitions are captured.
+// Only in-memory partitions are supported: every partition is serialized into the snapshot,
+// nothing is hard-linked.
+func (s *Storage) Snapshot(dir string) error {
+	if utils.Exist(dir) {
+		return fmt.Errorf("%w: %s", ErrSnapshotExists, dir)
+	}
+	parent := filepath.Dir(filepath.Clean(dir))
+	if err := utils.MkDirIfNotExist(parent); err != nil {
+		return fmt.Errorf("failed to create snapshot parent directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(parent, fmt.Sprintf(snapshotTempDirPattern, filepath.Base(dir)))
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := s.snapshot(tmpDir); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return fmt.Errorf("failed to publish snapshot: %w", err)
+	}
+	return syncDir(parent)
+}
+
+func (s *Storage) snapshot(dir string) error {
+	// Take every write slot so that no rows are inserted while capturing.
+	for i := 0; i < cap(s.workersLimitCh); i++ {
+		s.workersLimitCh <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < cap(s.workersLimitCh); i++ {
+			<-s.workersLimitCh
+		}
+	}()
+
+	manifest := snapshotManifest{
+		Version:           snapshotVersion,
+		Precision:         s.timestampPrecision,
+		PartitionDuration: s.partitionDuration,
+		Metadata:          make(map[string]MetricMetadata),
+	}
+	s.metadata.Range(func(key, value interface{}) bool {
+		manifest.Metadata[key.(string)] = value.(MetricMetadata)
+		return true
+	})
+
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		part := iterator.value()
+		if part == nil {
+			return fmt.Errorf("unexpected empty partition found")
+		}
+		if part.size() == 0 {
+			continue
+		}
+		name := filepath.Join(dir, fmt.Sprintf(snapshotPartitionFile, manifest.Partitions))
+		if err := part.snapshot(name); err != nil {
+			return fmt.Errorf("failed to snapshot partition: %w", err)
+		}
+		manifest.Partitions++
+	}
+
+	data, err := snapshotCodec.Marshal(&manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot manifest: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(dir, snapshotManifestFile), data); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// snapshot serializes all the metrics of the partition into file.
+func (m *memoryPartition) snapshot(file string) error {
+	sp := snapshotPartition{
+		MinT:      m.minTimestamp(),
+		MaxT:      m.maxTimestamp(),
+		NumPoints: atomic.LoadInt64(&m.numPoints),
+	}
+	m.metrics.Range(func(key, value interface{}) bool {
+		mt := value.(*memoryMetric)
+		mt.mu.RLock()
+		points := make([]*DataPoint, len(mt.points))
+		copy(points, mt.points)
+		mt.mu.RUnlock()
+		sp.Metrics = append(sp.Metrics, snapshotMetric{
+			Name:   key.(string),
+			Metric: mt.metric,
+			Labels: mt.labels,
+			Points: points,
+		})
+		return true
+	})
+	data, err := snapshotCodec.Marshal(&sp)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(file, data)
+}
+
+// Restore opens a storage from a snapshot written by Snapshot.
+// The timestamp precision and partition duration of the snapshot take precedence over opts.
+func Restore(dir string, opts ...Option) (StorageInterface, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot manifest: %w", err)
+	}
+	var manifest snapshotManifest
+	if err := snapshotCodec.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot manifest: %w", err)
+	}
+	if manifest.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", manifest.Version)
+	}
+
+	opts = append(opts, WithTimestampPrecision(manifest.Precision), WithPartitionDuration(manifest.PartitionDuration))
+	s := newStorage(opts...)
+	for name, metadata := range manifest.Metadata {
+		s.metadata.Store(name, metadata)
+	}
+	// Partitions are numbered from the newest one, insert the oldest first.
+	for i := manifest.Partitions - 1; i >= 0; i-- {
+		p, err := s.restoreMemoryPartition(filepath.Join(dir, fmt.Sprintf(snapshotPartitionFile, i)))
+		if err != nil {
+			return nil, err
+		}
+		s.partitionList.insert(p)
+	}
+	if manifest.Partitions == 0 {
+		s.newPartition(nil)
+	}
+	go s.retentionLoop()
+	return s, nil
+}
+
+func (s *Storage) restoreMemoryPartition(file string) (partition, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot partition: %w", err)
+	}
+	var sp snapshotPartition
+	if err := snapshotCodec.Unmarshal(data, &sp); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot partition %s: %w", file, err)
+	}
+	m := newMemoryPartition(s.partitionDuration, s.timestampPrecision, s.outOfOrderWindow, s.duplicatePolicy).(*memoryPartition)
+	// minT is immutable once set.
+	m.once.Do(func() {})
+	m.minT = sp.MinT
+	m.maxT = sp.MaxT
+	m.numPoints = sp.NumPoints
+	for _, metric := range sp.Metrics {
+		if len(metric.Points) == 0 {
+			continue
+		}
+		m.metrics.Store(metric.Name, &memoryMetric{
+			name:         metric.Name,
+			metric:       metric.Metric,
+			labels:       metric.Labels,
+			size:         int64(len(metric.Points)),
+			minTimestamp: metric.Points[0].Timestamp,
+			maxTimestamp: metric.Points[len(metric.Points)-1].Timestamp,
+			points:       metric.Points,
+		})
+	}
+	return m, nil
+}
+
+func writeFileSync(file string, data []byte) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Some platforms do not support syncing a directory.
+	_ = d.Sync()
+	return nil
+}
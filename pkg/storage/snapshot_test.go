@@ -0,0 +1,130 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_storage_SnapshotRestore(t *testing.T) {
+	assert := assert.New(t)
+	stg, err := NewStorage(
+		WithPartitionDuration(20*time.Second),
+		WithTimestampPrecision(Seconds),
+	)
+	assert.Nil(err)
+	assert.Nil(stg.SetMetadata("latency", MetricMetadata{Type: MetricTypeHistogram, Unit: "seconds"}))
+
+	// spread rows over both writable partitions.
+	for ts := int64(1600000000); ts < 1600000030; ts++ {
+		assert.Nil(stg.InsertRows([]Row{
+			{Name: "metric1", Labels: []Label{{Key: "host", Value: "a"}}, DataPoint: DataPoint{Timestamp: ts, Value: float64(ts % 7)}},
+		}))
+	}
+	assert.Nil(stg.InsertRows([]Row{
+		{Name: "latency", DataPoint: DataPoint{Timestamp: 1600000029, Histogram: &Histogram{
+			Count: 2, Sum: 0.3, Buckets: []Bucket{{UpperBound: 0.5, Count: 2}},
+		}}},
+	}))
+	want, err := stg.Select("metric1", []Label{{Key: "host", Value: "a"}}, 1600000000, 1600000030)
+	assert.Nil(err)
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	assert.Nil(stg.Snapshot(dir))
+	assert.ErrorIs(stg.Snapshot(dir), ErrSnapshotExists)
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	assert.Nil(err)
+	assert.Len(entries, 1) // no temporary directory left behind
+
+	// writes after the snapshot are not part of it.
+	assert.Nil(stg.InsertRows([]Row{{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000030}}}))
+	assert.Nil(stg.Close())
+
+	restored, err := Restore(dir)
+	assert.Nil(err)
+	defer restored.Close()
+
+	got, err := restored.Select("metric1", []Label{{Key: "host", Value: "a"}}, 1600000000, 1600000030)
+	assert.Nil(err)
+	assert.Equal(want, got)
+
+	series, err := restored.SelectSeries("latency", nil, 1600000000, 1600000030)
+	assert.Nil(err)
+	assert.Equal(MetricTypeHistogram, series.Metadata.Type)
+	assert.Equal(uint64(2), series.Points[0].Histogram.Count)
+
+	// the restored storage keeps accepting writes.
+	assert.Nil(restored.InsertRows([]Row{{Name: "metric2", DataPoint: DataPoint{Timestamp: 1600000031, Value: 1}}}))
+	points, err := restored.Select("metric2", nil, 1600000031, 1600000032)
+	assert.Nil(err)
+	assert.Len(points, 1)
+
+	_, err = Restore(filepath.Join(t.TempDir(), "nothing"))
+	assert.NotNil(err)
+}
+
+func Test_storage_SnapshotRestoreRetention(t *testing.T) {
+	assert := assert.New(t)
+	labels := []Label{{Key: "host", Value: "a"}}
+	opts := []Option{
+		WithPartitionDuration(100 * time.Second),
+		WithTimestampPrecision(Seconds),
+		WithRetention(1 * time.Hour),
+		WithRetentionRules(RetentionRule{Metric: "debug", Labels: labels, Retention: 10 * time.Second}),
+	}
+	stg, err := NewStorage(opts...)
+	assert.Nil(err)
+
+	var rows []Row
+	for ts := int64(1600000000); ts < 1600000050; ts++ {
+		rows = append(rows, Row{Name: "debug", Labels: labels, DataPoint: DataPoint{Value: 1, Timestamp: ts}})
+	}
+	assert.Nil(stg.InsertRows(rows))
+	dir := filepath.Join(t.TempDir(), "backup")
+	assert.Nil(stg.Snapshot(dir))
+	assert.Nil(stg.Close())
+
+	restored, err := Restore(dir, opts...)
+	assert.Nil(err)
+	defer restored.Close()
+
+	// the rules keep matching the restored series.
+	assert.Nil(restored.(*Storage).applyRetention())
+	points, err := restored.Select("debug", labels, 1600000000, 1600000050)
+	assert.Nil(err)
+	assert.Len(points, 11)
+	assert.Equal(int64(1600000039), points[0].Timestamp)
+}
+
+func Test_storage_SnapshotEmpty(t *testing.T) {
+	assert := assert.New(t)
+	stg, err := NewStorage()
+	assert.Nil(err)
+	defer stg.Close()
+
+	dir := filepath.Join(t.TempDir(), "empty")
+	assert.Nil(stg.Snapshot(dir))
+	restored, err := Restore(dir)
+	assert.Nil(err)
+	assert.Nil(restored.InsertRows([]Row{{Name: "metric1", DataPoint: DataPoint{Timestamp: 1, Value: 1}}}))
+	assert.Nil(restored.Close())
+}
@@ -22,6 +22,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/uber-go/tally"
+
 	"github.com/kubeservice-stack/common/pkg/logger"
 	"github.com/kubeservice-stack/common/pkg/utils"
 )
@@ -48,11 +50,23 @@ import (
 */
 type Storage struct {
 	partitionList partitionList
+	// A hash map from metric name to MetricMetadata.
+	metadata sync.Map
 
 	partitionDuration  time.Duration
 	retention          time.Duration
 	timestampPrecision TimestampPrecision
 	writeTimeout       time.Duration
+	outOfOrderWindow   time.Duration
+	duplicatePolicy    DuplicatePolicy
+
+	// retention policies besides retention, applied every checkExpiredInterval.
+	maxBytes             int64
+	retentionRules       []RetentionRule
+	checkExpiredInterval time.Duration
+	evictedPartitions    int64
+	evictedPoints        int64
+	scope                tally.Scope
 
 	logger         *logger.Logger
 	workersLimitCh chan struct{}
@@ -65,49 +79,106 @@ type Storage struct {
 }
 
 func NewStorage(opts ...Option) (StorageInterface, error) {
+	s := newStorage(opts...)
+
+	// new partition
+	s.newPartition(nil)
+	go s.retentionLoop()
+
+	return s, nil
+}
+
+// newStorage creates a storage without any partition.
+func newStorage(opts ...Option) *Storage {
 	s := &Storage{
-		partitionList:      newPartitionList(),
-		workersLimitCh:     make(chan struct{}, defaultWorkersLimit),
-		partitionDuration:  defaultPartitionDuration,
-		retention:          defaultRetention,
-		timestampPrecision: defaultTimestampPrecision,
-		writeTimeout:       defaultWriteTimeout,
-		doneCh:             make(chan struct{}),
-		timerpool:          utils.NewTimerPool(),
-		logger:             logger.GetLogger("pkg/common/storage", "storage"),
+		partitionList:        newPartitionList(),
+		workersLimitCh:       make(chan struct{}, defaultWorkersLimit),
+		partitionDuration:    defaultPartitionDuration,
+		retention:            defaultRetention,
+		timestampPrecision:   defaultTimestampPrecision,
+		writeTimeout:         defaultWriteTimeout,
+		outOfOrderWindow:     defaultOutOfOrderWindow,
+		duplicatePolicy:      defaultDuplicatePolicy,
+		checkExpiredInterval: defaultCheckExpiredInterval,
+		doneCh:               make(chan struct{}),
+		timerpool:            utils.NewTimerPool(),
+		logger:               logger.GetLogger("pkg/common/storage", "storage"),
 	}
 
 	// setting option
 	for _, opt := range opts {
 		opt(s)
 	}
-
-	// new partition
-	s.newPartition(nil)
-
-	return s, nil
+	return s
 }
 
 func (s *Storage) newPartition(p partition) error {
 	if p == nil {
-		p = NewMemoryPartition(s.partitionDuration, s.timestampPrecision)
+		p = newMemoryPartition(s.partitionDuration, s.timestampPrecision, s.outOfOrderWindow, s.duplicatePolicy)
 	}
 	s.partitionList.insert(p)
 	return nil
 }
 
 func (s *Storage) InsertRows(rows []Row) error {
+	_, _, err := s.insertRows(rows)
+	return err
+}
+
+// InsertRowsWithReport inserts rows like InsertRows does, but instead of dropping them silently
+// it reports the rows older than the retention, older than every writable partition
+// or rejected by the out-of-order window and the duplicate policy.
+// The retention is relative to the newest data point the head partition holds.
+func (s *Storage) InsertRowsWithReport(rows []Row) (*InsertReport, error) {
+	if len(rows) == 0 {
+		return nil, ErrNoRowsData
+	}
+	report := &InsertReport{}
+	rowsToInsert := rows
+	if head := s.partitionList.getHead(); head != nil && head.maxTimestamp() > 0 && s.retention > 0 {
+		boundary := head.maxTimestamp() - toPrecision(s.retention, s.timestampPrecision)
+		rowsToInsert = make([]Row, 0, len(rows))
+		for i := range rows {
+			if rows[i].Timestamp != 0 && rows[i].Timestamp < boundary {
+				report.Rejected = append(report.Rejected, RejectedRow{Row: rows[i], Reason: ErrOutOfRetention})
+				continue
+			}
+			rowsToInsert = append(rowsToInsert, rows[i])
+		}
+	}
+	if len(rowsToInsert) == 0 {
+		return report, nil
+	}
+	rejected, dropped, err := s.insertRows(rowsToInsert)
+	if err != nil {
+		return nil, err
+	}
+	report.Rejected = append(report.Rejected, rejected...)
+	report.Dropped = dropped
+	report.Inserted = len(rows) - len(report.Rejected) - dropped
+	return report, nil
+}
+
+// insertRows writes rows into the writable partitions, and gives back the rows
+// which have been rejected and the number of duplicated rows which have been dropped.
+func (s *Storage) insertRows(rows []Row) ([]RejectedRow, int, error) {
 	s.wg.Add(1)
 	defer s.wg.Done()
 
-	insert := func() error {
+	rows, typeRejectedRows := s.checkValueTypes(rows)
+	if len(rows) == 0 {
+		return typeRejectedRows, 0, nil
+	}
+
+	insert := func() ([]RejectedRow, error) {
 		defer func() { <-s.workersLimitCh }()
 		if err := s.ensureActiveHead(); err != nil {
-			return err
+			return nil, err
 		}
 		iterator := s.partitionList.newIterator()
 		n := s.partitionList.size()
 		rowsToInsert := rows
+		rejectedRows := typeRejectedRows
 
 		for i := 0; i < n && i < defaultwritablePartitionsNum; i++ {
 			if len(rowsToInsert) == 0 {
@@ -116,20 +187,25 @@ func (s *Storage) InsertRows(rows []Row) error {
 			if !iterator.next() {
 				break
 			}
-			outdatedRows, err := iterator.value().insertRows(rowsToInsert)
+			outdatedRows, rejected, err := iterator.value().appendRows(rowsToInsert)
 			if err != nil {
-				return fmt.Errorf("failed to insert rows: %w", err)
+				return nil, fmt.Errorf("failed to insert rows: %w", err)
 			}
+			rejectedRows = append(rejectedRows, rejected...)
 			rowsToInsert = outdatedRows
 		}
-		return nil
+		// Rows older than every writable partition can not be stored anymore.
+		for i := range rowsToInsert {
+			rejectedRows = append(rejectedRows, RejectedRow{Row: rowsToInsert[i], Reason: ErrOutOfOrder})
+		}
+		return rejectedRows, nil
 	}
 
 	// Limit the number of concurrent goroutines to prevent from out of memory
 	// errors and CPU trashing even if too many goroutines attempt to write.
 	select {
 	case s.workersLimitCh <- struct{}{}:
-		return insert()
+		return splitDropped(insert())
 	default:
 	}
 
@@ -139,12 +215,78 @@ func (s *Storage) InsertRows(rows []Row) error {
 	select {
 	case s.workersLimitCh <- struct{}{}:
 		s.timerpool.Put(t)
-		return insert()
+		return splitDropped(insert())
 	case <-t.C:
 		s.timerpool.Put(t)
-		return fmt.Errorf("failed to write a data point in %s, since it is overloaded with %d concurrent writers",
-			s.writeTimeout, defaultWorkersLimit)
+		return nil, 0, fmt.Errorf("%w: failed to write a data point in %s, since it is overloaded with %d concurrent writers",
+			ErrOverloaded, s.writeTimeout, defaultWorkersLimit)
+	}
+}
+
+// splitDropped separates the duplicated rows dropped by DuplicateKeepFirst from the rejected rows.
+func splitDropped(rejectedRows []RejectedRow, err error) ([]RejectedRow, int, error) {
+	if err != nil {
+		return nil, 0, err
+	}
+	dropped := 0
+	kept := rejectedRows[:0]
+	for i := range rejectedRows {
+		if rejectedRows[i].Reason == errDuplicateDropped {
+			dropped++
+			continue
+		}
+		kept = append(kept, rejectedRows[i])
+	}
+	if len(kept) == 0 {
+		kept = nil
+	}
+	return kept, dropped, nil
+}
+
+// checkValueTypes splits rows into the ones matching the type of their metric and the others.
+func (s *Storage) checkValueTypes(rows []Row) ([]Row, []RejectedRow) {
+	var (
+		validRows    []Row
+		rejectedRows []RejectedRow
+	)
+	for i := range rows {
+		metadata, ok := s.Metadata(rows[i].Name)
+		if !ok || metadata.Type.accepts(rows[i].ValueType()) {
+			if validRows != nil {
+				validRows = append(validRows, rows[i])
+			}
+			continue
+		}
+		if validRows == nil {
+			validRows = append(make([]Row, 0, len(rows)), rows[:i]...)
+		}
+		rejectedRows = append(rejectedRows, RejectedRow{Row: rows[i], Reason: ErrTypeMismatch})
+	}
+	if validRows == nil {
+		return rows, nil
+	}
+	return validRows, rejectedRows
+}
+
+// SetMetadata sets the metadata of the metric name.
+func (s *Storage) SetMetadata(name string, metadata MetricMetadata) error {
+	if name == "" {
+		return fmt.Errorf("metric must be set")
+	}
+	if metadata.Type.String() == ErrUnknown {
+		return fmt.Errorf("unknown metric type %d", metadata.Type)
+	}
+	s.metadata.Store(name, metadata)
+	return nil
+}
+
+// Metadata gives back the metadata of the metric name.
+func (s *Storage) Metadata(name string) (MetricMetadata, bool) {
+	value, ok := s.metadata.Load(name)
+	if !ok {
+		return MetricMetadata{}, false
 	}
+	return value.(MetricMetadata), true
 }
 
 func (s *Storage) ensureActiveHead() error {
@@ -233,6 +375,21 @@ func (s *Storage) Select(name string, labels []Label, start, end int64) ([]*Data
 	return points, nil
 }
 
+func (s *Storage) SelectSeries(name string, labels []Label, start, end int64) (*Series, error) {
+	points, err := s.Select(name, labels, start, end)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _ := s.Metadata(name)
+	return &Series{
+		Name:      name,
+		Labels:    labels,
+		Metadata:  metadata,
+		Precision: s.timestampPrecision,
+		Points:    points,
+	}, nil
+}
+
 func (s *Storage) Close() error {
 	s.wg.Wait()
 	close(s.doneCh)
@@ -262,15 +419,25 @@ func (s *Storage) removeExpiredPartitions() error {
 		if part == nil {
 			return fmt.Errorf("unexpected nil partition found")
 		}
-		if part.expired() {
+		if part.expired() || s.outOfRetention(part) {
 			expiredList = append(expiredList, part)
 		}
 	}
 
 	for i := range expiredList {
-		if err := s.partitionList.remove(expiredList[i]); err != nil {
+		if err := s.evictPartition(expiredList[i]); err != nil {
 			return fmt.Errorf("failed to remove expired partition")
 		}
 	}
 	return nil
 }
+
+// outOfRetention reports whether all the points of the partition are older than the retention,
+// which is relative to the newest data point the head partition holds.
+func (s *Storage) outOfRetention(part partition) bool {
+	head := s.partitionList.getHead()
+	if head == nil || head == part || head.maxTimestamp() == 0 || s.retention <= 0 || part.maxTimestamp() == 0 {
+		return false
+	}
+	return part.maxTimestamp() < head.maxTimestamp()-toPrecision(s.retention, s.timestampPrecision)
+}
@@ -127,3 +127,132 @@ func Test_storage_Select(t *testing.T) {
 		})
 	}
 }
+
+func Test_storage_InsertRowsWithReport(t *testing.T) {
+	assert := assert.New(t)
+	stg, err := NewStorage(
+		WithPartitionDuration(1*time.Hour),
+		WithTimestampPrecision(Seconds),
+		WithRetention(10*time.Minute),
+		WithOutOfOrderWindow(5*time.Second),
+		WithDuplicatePolicy(DuplicateReject),
+	)
+	assert.Nil(err)
+	defer stg.Close()
+
+	_, err = stg.InsertRowsWithReport(nil)
+	assert.ErrorIs(err, ErrNoRowsData)
+
+	report, err := stg.InsertRowsWithReport([]Row{
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000000, Value: 0.1}},
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000010, Value: 0.2}},
+	})
+	assert.Nil(err)
+	assert.Equal(2, report.Inserted)
+	assert.Empty(report.Rejected)
+
+	report, err = stg.InsertRowsWithReport([]Row{
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000007, Value: 0.3}}, // within out-of-order window
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000001, Value: 0.4}}, // out of order
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000010, Value: 0.5}}, // duplicated
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1599999999, Value: 0.6}}, // older than partitions
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1599990000, Value: 0.7}}, // out of retention
+	})
+	assert.Nil(err)
+	assert.Equal(1, report.Inserted)
+	assert.Equal(2, report.Count(ErrOutOfOrder))
+	assert.Equal(1, report.Count(ErrDuplicateSample))
+	assert.Equal(1, report.Count(ErrOutOfRetention))
+
+	points, err := stg.Select("metric1", nil, 1600000000, 1600000011)
+	assert.Nil(err)
+	assert.Equal([]*DataPoint{
+		{Timestamp: 1600000000, Value: 0.1},
+		{Timestamp: 1600000007, Value: 0.3},
+		{Timestamp: 1600000010, Value: 0.2},
+	}, points)
+}
+
+func Test_storage_InsertRowsWithReportDropped(t *testing.T) {
+	assert := assert.New(t)
+	stg, err := NewStorage(
+		WithTimestampPrecision(Seconds),
+		WithDuplicatePolicy(DuplicateKeepFirst),
+	)
+	assert.Nil(err)
+	defer stg.Close()
+
+	report, err := stg.InsertRowsWithReport([]Row{
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000000, Value: 0.1}},
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000000, Value: 0.2}},
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000001, Value: 0.3}},
+	})
+	assert.Nil(err)
+	assert.Equal(2, report.Inserted)
+	assert.Equal(1, report.Dropped)
+	assert.Empty(report.Rejected)
+
+	report, err = stg.InsertRowsWithReport([]Row{
+		{Name: "metric1", DataPoint: DataPoint{Timestamp: 1600000001, Value: 0.4}},
+	})
+	assert.Nil(err)
+	assert.Equal(0, report.Inserted)
+	assert.Equal(1, report.Dropped)
+	assert.Equal(0, report.Count(ErrDuplicateSample))
+
+	points, err := stg.Select("metric1", nil, 1600000000, 1600000002)
+	assert.Nil(err)
+	assert.Equal([]*DataPoint{
+		{Timestamp: 1600000000, Value: 0.1},
+		{Timestamp: 1600000001, Value: 0.3},
+	}, points)
+}
+
+func Test_storage_typedSeries(t *testing.T) {
+	assert := assert.New(t)
+	stg, err := NewStorage(WithTimestampPrecision(Seconds))
+	assert.Nil(err)
+	defer stg.Close()
+
+	assert.NotNil(stg.SetMetadata("", MetricMetadata{}))
+	assert.NotNil(stg.SetMetadata("requests", MetricMetadata{Type: MetricType(100)}))
+	assert.Nil(stg.SetMetadata("requests", MetricMetadata{Type: MetricTypeCounter, Help: "total requests"}))
+	assert.Nil(stg.SetMetadata("latency", MetricMetadata{Type: MetricTypeHistogram}))
+	assert.Nil(stg.SetMetadata("deploy", MetricMetadata{Type: MetricTypeEvent}))
+	_, ok := stg.Metadata("unknown")
+	assert.False(ok)
+
+	report, err := stg.InsertRowsWithReport([]Row{
+		{Name: "requests", DataPoint: DataPoint{Timestamp: 1600000000, Value: 10}},
+		{Name: "requests", DataPoint: DataPoint{Timestamp: 1600000001, Text: "oops"}},
+		{Name: "latency", DataPoint: DataPoint{Timestamp: 1600000000, Histogram: &Histogram{Count: 1, Sum: 0.5}}},
+		{Name: "latency", DataPoint: DataPoint{Timestamp: 1600000001, Value: 1}},
+		{Name: "deploy", DataPoint: DataPoint{Timestamp: 1600000000, Text: "v1.0.0"}},
+		{Name: "deploy", DataPoint: DataPoint{Timestamp: 1600000001, Kind: ValueString}},
+		{Name: "deploy", DataPoint: DataPoint{Timestamp: 1600000002}},
+	})
+	assert.Nil(err)
+	assert.Equal(4, report.Inserted)
+	assert.Equal(3, report.Count(ErrTypeMismatch))
+
+	series, err := stg.SelectSeries("requests", nil, 1600000000, 1600000002)
+	assert.Nil(err)
+	assert.Equal(MetricTypeCounter, series.Metadata.Type)
+	assert.Equal(Seconds, series.Precision)
+	assert.Equal([]*DataPoint{{Timestamp: 1600000000, Value: 10}}, series.Points)
+
+	series, err = stg.SelectSeries("deploy", nil, 1600000000, 1600000002)
+	assert.Nil(err)
+	assert.Equal(ValueString, series.Points[0].ValueType())
+	assert.Equal("v1.0.0", series.Points[0].Text)
+	assert.Len(series.Points, 2)
+	assert.Equal(ValueString, series.Points[1].ValueType())
+	assert.Equal("", series.Points[1].Text)
+
+	series, err = stg.SelectSeries("latency", nil, 1600000000, 1600000002)
+	assert.Nil(err)
+	assert.Equal(ValueHistogram, series.Points[0].ValueType())
+
+	_, err = stg.SelectSeries("nothing", nil, 1600000000, 1600000002)
+	assert.ErrorIs(err, ErrNoDataPoints)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023 The KubeService-Stack Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+// ValueType is the kind of value a DataPoint carries.
+type ValueType int
+
+const (
+	ValueFloat     ValueType = iota // DataPoint.Value
+	ValueHistogram                  // DataPoint.Histogram
+	ValueString                     // DataPoint.Text
+)
+
+func (v ValueType) String() string {
+	switch v {
+	case ValueFloat:
+		return "float"
+	case ValueHistogram:
+		return "histogram"
+	case ValueString:
+		return "string"
+	default:
+		return ErrUnknown
+	}
+}
+
+// Bucket is a cumulative histogram bucket: Count observations are less than or equal to UpperBound.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Histogram is a native histogram data point.
+type Histogram struct {
+	// Buckets must be sorted by UpperBound.
+	Buckets []Bucket
+	// Sum of all observations.
+	Sum float64
+	// Count of all observations.
+	Count uint64
+}
+
+// MetricType is the type of a metric, stored as metadata besides the data points.
+type MetricType int
+
+const (
+	MetricTypeUnknown MetricType = iota
+	MetricTypeGauge
+	MetricTypeCounter
+	MetricTypeHistogram
+	MetricTypeEvent
+)
+
+func (t MetricType) String() string {
+	switch t {
+	case MetricTypeUnknown:
+		return "unknown"
+	case MetricTypeGauge:
+		return "gauge"
+	case MetricTypeCounter:
+		return "counter"
+	case MetricTypeHistogram:
+		return "histogram"
+	case MetricTypeEvent:
+		return "event"
+	default:
+		return ErrUnknown
+	}
+}
+
+// accepts reports whether a point of the given value type can be stored into a metric of this type.
+func (t MetricType) accepts(v ValueType) bool {
+	switch t {
+	case MetricTypeGauge, MetricTypeCounter:
+		return v == ValueFloat
+	case MetricTypeHistogram:
+		return v == ValueHistogram
+	case MetricTypeEvent:
+		return v == ValueString
+	default:
+		return true
+	}
+}
+
+// MetricMetadata describes a metric name.
+type MetricMetadata struct {
+	Type MetricType
+	Help string
+	Unit string
+}
+
+// Series is a typed time series given back by SelectSeries.
+type Series struct {
+	Name     string
+	Labels   []Label
+	Metadata MetricMetadata
+	// The precision of the timestamps of Points.
+	Precision TimestampPrecision
+	Points    []*DataPoint
+}